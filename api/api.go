@@ -3,41 +3,98 @@ package api
 import (
 	"encoding/json"
 	"fmt"
-	"github.com/go-redis/redis/v7"
 	"github.com/gorilla/mux"
 	"log"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/PonyFest/auction-bot/auction"
+	"github.com/PonyFest/auction-bot/catalog"
 )
 
 type APIServer struct {
 	server *http.Server
 	auction *auction.Auction
+	// auctions are the auctions served under /api/auctions, by ID.
+	auctions map[string]*APIServer
+	// router routes the requests for an auction under /api/auctions.
+	router *mux.Router
 }
 
-func New(auction *auction.Auction, password string) *APIServer {
+// New returns a server for the API of the given auction, under /api. Other
+// auctions can be served alongside it with AddAuction.
+func New(auction *auction.Auction, password, adminPassword string) *APIServer {
 	h := mux.NewRouter()
 	a := &APIServer{
 		auction: auction,
+		auctions: map[string]*APIServer{},
 		server: &http.Server{
-			Handler: basicAuth(acceptAllCors(h), password),
+			Handler: basicAuth(acceptAllCors(h), password, adminPassword),
 		},
 	}
-	h.HandleFunc("/api/openItem", a.handleOpenItem)
-	h.HandleFunc("/api/events", a.handleEvents)
-	h.HandleFunc("/api/closeItem", a.handleCloseItem)
-	h.HandleFunc("/api/items", a.handleGetItems)
-	h.HandleFunc("/api/currentItem", a.handleGetCurrentItem)
-	h.HandleFunc("/api/items/{itemId}", a.handleItem)
-	h.HandleFunc("/api/items/{itemId}/bids", a.handleItemBids)
-	h.HandleFunc("/api/items/{itemId}/bids/{bidId}", a.handleSpecificBid)
-	h.HandleFunc("/api/total", a.handleTotal)
+	h.HandleFunc("/api/auctions", a.handleAuctions)
+	h.PathPrefix("/api/auctions/{auctionId}/").HandlerFunc(a.serveAuction)
+	a.routes(h.PathPrefix("/api").Subrouter())
 	return a
 }
 
+// routes adds the routes for an auction's API to h.
+func (a *APIServer) routes(h *mux.Router) {
+	h.HandleFunc("/openItem", a.handleOpenItem)
+	h.HandleFunc("/events", a.handleEvents)
+	h.HandleFunc("/events/log", a.handleEventLog)
+	h.HandleFunc("/events/stats", a.handleEventStats)
+	h.HandleFunc("/closeItem", a.handleCloseItem)
+	h.HandleFunc("/pauseItem", a.handlePauseItem)
+	h.HandleFunc("/resumeItem", a.handleResumeItem)
+	h.HandleFunc("/next", a.handleNext)
+	h.HandleFunc("/upcoming", a.handleUpcoming)
+	h.HandleFunc("/queue", a.handleQueue)
+	h.HandleFunc("/queue/{itemId}", a.handleQueuedItem)
+	h.HandleFunc("/queue/{itemId}/move", a.handleMoveQueuedItem)
+	h.HandleFunc("/queue/{itemId}/skip", a.handleSkipQueuedItem)
+	h.HandleFunc("/autoAdvance", a.handleAutoAdvance)
+	h.HandleFunc("/schedule", a.handleSchedule)
+	h.HandleFunc("/schedule.ics", a.handleScheduleCalendar)
+	h.HandleFunc("/extendDeadline", a.handleExtendDeadline)
+	h.HandleFunc("/cancelDeadline", a.handleCancelDeadline)
+	h.HandleFunc("/items", a.handleItems)
+	h.HandleFunc("/currentItem", a.handleGetCurrentItem)
+	h.HandleFunc("/openItems", a.handleGetOpenItems)
+	h.HandleFunc("/items/import", a.handleImportItems)
+	h.HandleFunc("/items/export", a.handleExportItems)
+	h.HandleFunc("/items/{itemId}", a.handleItem)
+	h.HandleFunc("/items/{itemId}/bids", a.handleItemBids)
+	h.HandleFunc("/items/{itemId}/bids/{bidId}", a.handleSpecificBid)
+	h.HandleFunc("/items/{itemId}/buyNow", a.handleBuyNow)
+	h.HandleFunc("/items/{itemId}/events", a.handleItemEventLog)
+	h.HandleFunc("/items/{itemId}/state", a.handleItemState)
+	h.HandleFunc("/items/{itemId}/opening", a.handleItemOpening)
+	h.HandleFunc("/total", a.handleTotal)
+	h.HandleFunc("/reconcile", a.handleReconcile)
+	h.HandleFunc("/bidRules", a.handleBidRules)
+	h.HandleFunc("/archive", a.handleArchive)
+}
+
+// handleEvents streams auction events as server-sent events. Each event
+// carries its ID from the event log, so a client that reconnects with a
+// Last-Event-ID header (or a lastEventId parameter, for clients that can't set
+// headers) gets everything it missed first. The stream can be narrowed with a
+// comma-separated list of event "types", and to a single "itemId".
 func (a *APIServer) handleEvents(w http.ResponseWriter, r *http.Request) {
+	filter := newEventFilter(r)
+	lastEventId := r.Header.Get("Last-Event-ID")
+	if lastEventId == "" {
+		lastEventId = r.FormValue("lastEventId")
+	}
+	ch, err := a.auction.EventsAfter(r.Context(), lastEventId)
+	if err != nil {
+		auctionError(w, "couldn't subscribe to events", err, http.StatusInternalServerError)
+		return
+	}
+
 	w.Header().Set("Content-Type", "text/event-stream")
 	w.Header().Set("Cache-Control", "no-cache")
 	w.Header().Set("Connection", "keep-alive")
@@ -46,19 +103,29 @@ func (a *APIServer) handleEvents(w http.ResponseWriter, r *http.Request) {
 	_, _ = w.Write([]byte(": hello\n\n"))
 	w.(http.Flusher).Flush()
 
-	ch := a.auction.Events()
 	const pingTime = 45 * time.Second
 	pingChannel := time.After(pingTime)
 	for {
 		output := ""
 		select {
-		case event := <-ch:
-			data := map[string]interface{}{"type": event.Event(), "event": event}
+		case event, ok := <-ch:
+			if !ok {
+				// Either the client went away, or it fell too far behind
+				// and should reconnect to catch up.
+				return
+			}
+			if !filter.matches(event) {
+				continue
+			}
+			data := map[string]interface{}{"type": event.Event.Event(), "event": event.Event}
 			j, err := json.Marshal(data)
 			if err != nil {
 				break
 			}
 			output = fmt.Sprintf("data: %s\n\n", j)
+			if event.ID != "" {
+				output = fmt.Sprintf("id: %s\n", event.ID) + output
+			}
 		case <-pingChannel:
 			pingChannel = time.After(pingTime)
 			output = ": ping\n\n"
@@ -74,80 +141,423 @@ func (a *APIServer) handleEvents(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// eventFilter picks the events a client of the event stream asked for. Empty
+// fields match everything. Events that aren't logged, such as connectionLost,
+// always match, since every client needs to know about them.
+type eventFilter struct {
+	types  map[string]bool
+	itemId string
+}
+
+func newEventFilter(r *http.Request) eventFilter {
+	filter := eventFilter{itemId: r.FormValue("itemId")}
+	for _, t := range strings.Split(r.FormValue("types"), ",") {
+		if t = strings.TrimSpace(t); t != "" {
+			if filter.types == nil {
+				filter.types = map[string]bool{}
+			}
+			filter.types[t] = true
+		}
+	}
+	return filter
+}
+
+func (f eventFilter) matches(event auction.LoggedEvent) bool {
+	if event.ID == "" {
+		return true
+	}
+	if f.types != nil && !f.types[event.Event.Event()] {
+		return false
+	}
+	return f.itemId == "" || event.ItemID == f.itemId
+}
+
 func (a *APIServer) handleGetCurrentItem(w http.ResponseWriter, r *http.Request) {
 	item := a.auction.CurrentItem()
 	response := map[string]interface{}{
 		"status": "ok",
 		"item": item,
+		"deadline": nil,
+	}
+	if item != nil {
+		redactItem(r, item)
+		if deadline, ok := a.auction.Deadline(item.ID); ok {
+			response["deadline"] = deadlineMillis(deadline)
+		}
+		if hasReserve, met := a.auction.ReserveStatus(item.ID); hasReserve {
+			response["reserveMet"] = met
+		}
+		if _, available := a.auction.BuyNowPrice(item); available {
+			response["buyNowAvailable"] = true
+		}
+		if price, _, err := a.auction.CurrentPrice(item.ID); err == nil {
+			response["price"] = price
+		}
 	}
 	if err := json.NewEncoder(w).Encode(response); err != nil {
-		http.Error(w, fmt.Sprintf("encoding JSON failed: %v", err), http.StatusInternalServerError)
+		httpError(w, fmt.Sprintf("encoding JSON failed: %v", err), http.StatusInternalServerError)
+	}
+}
+
+func (a *APIServer) handleGetOpenItems(w http.ResponseWriter, r *http.Request) {
+	items, err := a.auction.OpenItems()
+	if err != nil {
+		httpError(w, fmt.Sprintf("couldn't get open items: %v", err), http.StatusInternalServerError)
+		return
+	}
+	currentItemId := ""
+	if currentItem := a.auction.CurrentItem(); currentItem != nil {
+		currentItemId = currentItem.ID
+	}
+	type openItem struct {
+		Item            auction.Item `json:"item"`
+		Deadline        *int64       `json:"deadline"`
+		TopBid          *auction.Bid `json:"topBid"`
+		Current         bool         `json:"current"`
+		Price           *int         `json:"price,omitempty"`
+		ReserveMet      *bool        `json:"reserveMet,omitempty"`
+		BuyNowAvailable bool         `json:"buyNowAvailable,omitempty"`
+	}
+	openItems := make([]openItem, 0, len(items))
+	for _, item := range items {
+		o := openItem{Item: item, Current: item.ID == currentItemId}
+		redactItem(r, &o.Item)
+		if deadline, ok := a.auction.Deadline(item.ID); ok {
+			millis := deadlineMillis(deadline)
+			o.Deadline = &millis
+		}
+		if bids, err := a.auction.GetTopBids(item.ID, 1); err == nil && len(bids) == 1 {
+			o.TopBid = &bids[0]
+		}
+		if price, _, err := a.auction.CurrentPrice(item.ID); err == nil {
+			o.Price = &price
+		}
+		if hasReserve, met := a.auction.ReserveStatus(item.ID); hasReserve {
+			o.ReserveMet = &met
+		}
+		_, o.BuyNowAvailable = a.auction.BuyNowPrice(&item)
+		openItems = append(openItems, o)
+	}
+	response := map[string]interface{}{
+		"status": "ok",
+		"items": openItems,
+	}
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		httpError(w, fmt.Sprintf("encoding JSON failed: %v", err), http.StatusInternalServerError)
 	}
 }
 
 func (a *APIServer) handleItem(w http.ResponseWriter, r *http.Request) {
 	itemId := mux.Vars(r)["itemId"]
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut, http.MethodPatch:
+		a.handleUpdateItem(w, r, itemId)
+		return
+	case http.MethodDelete:
+		a.handleDeleteItem(w, r, itemId)
+		return
+	default:
+		httpError(w, "bad method", http.StatusMethodNotAllowed)
+		return
+	}
 	item, err := a.auction.GetItem(itemId)
 	if err != nil {
-		if err == redis.Nil {
-			http.Error(w, fmt.Sprintf("no such item: %q", itemId), http.StatusNotFound)
+		auctionError(w, "couldn't get item", err, http.StatusInternalServerError)
+		return
+	}
+	redactItem(r, item)
+	response := map[string]interface{}{
+		"status": "ok",
+		"item": item,
+	}
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		httpError(w, fmt.Sprintf("encoding JSON failed: %v", err), http.StatusInternalServerError)
+	}
+}
+
+func (a *APIServer) handleUpdateItem(w http.ResponseWriter, r *http.Request, itemId string) {
+	var item auction.Item
+	if r.Method == http.MethodPatch {
+		// Decoding over the existing item leaves any fields missing from the body untouched.
+		existing, err := a.auction.GetItem(itemId)
+		if err != nil {
+			auctionError(w, "couldn't get item", err, http.StatusInternalServerError)
 			return
 		}
-		http.Error(w, fmt.Sprintf("couldn't get item: %v", err), http.StatusInternalServerError)
+		item = *existing
+	}
+	if err := json.NewDecoder(r.Body).Decode(&item); err != nil {
+		httpError(w, fmt.Sprintf("couldn't decode item: %v", err), http.StatusBadRequest)
+		return
+	}
+	if !isAdmin(r) {
+		// Only admins can see the reserve, so only they can change it.
+		existing, err := a.auction.GetItem(itemId)
+		if err != nil {
+			auctionError(w, "couldn't get item", err, http.StatusInternalServerError)
+			return
+		}
+		item.Reserve = existing.Reserve
+	}
+	updated, err := a.auction.UpdateItem(itemId, item)
+	if err != nil {
+		auctionError(w, "couldn't update item", err, http.StatusBadRequest)
 		return
 	}
+	redactItem(r, updated)
 	response := map[string]interface{}{
 		"status": "ok",
-		"item": item,
+		"item": updated,
 	}
 	if err := json.NewEncoder(w).Encode(response); err != nil {
-		http.Error(w, fmt.Sprintf("encoding JSON failed: %v", err), http.StatusInternalServerError)
+		httpError(w, fmt.Sprintf("encoding JSON failed: %v", err), http.StatusInternalServerError)
 	}
 }
 
+func (a *APIServer) handleDeleteItem(w http.ResponseWriter, r *http.Request, itemId string) {
+	if err := a.auction.DeleteItem(itemId); err != nil {
+		auctionError(w, "couldn't delete item", err, http.StatusBadRequest)
+		return
+	}
+	_, _ = w.Write([]byte(`{"status": "ok"}`))
+}
+
+func (a *APIServer) handleItems(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		a.handleGetItems(w, r)
+	case http.MethodPost:
+		a.handleCreateItem(w, r)
+	default:
+		httpError(w, "bad method", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleGetItems lists the items, or only those in the comma-separated list of
+// states given as "state".
 func (a *APIServer) handleGetItems(w http.ResponseWriter, r *http.Request) {
-	items, err := a.auction.GetItems()
+	var items []auction.Item
+	var err error
+	if state := r.FormValue("state"); state != "" {
+		states := strings.Split(state, ",")
+		for _, s := range states {
+			if !auction.ValidState(s) {
+				httpError(w, fmt.Sprintf("unknown item state %q", s), http.StatusBadRequest)
+				return
+			}
+		}
+		items, err = a.auction.GetItemsInState(states...)
+	} else {
+		items, err = a.auction.GetItems()
+	}
 	if err != nil {
-		http.Error(w, fmt.Sprintf("couldn't get items: %v", err), http.StatusInternalServerError)
+		httpError(w, fmt.Sprintf("couldn't get items: %v", err), http.StatusInternalServerError)
 		return
 	}
+	redactItems(r, items)
 	response := map[string]interface{}{
 		"status": "ok",
 		"items": items,
 	}
 	if err := json.NewEncoder(w).Encode(response); err != nil {
-		http.Error(w, fmt.Sprintf("encoding JSON failed: %v", err), http.StatusInternalServerError)
+		httpError(w, fmt.Sprintf("encoding JSON failed: %v", err), http.StatusInternalServerError)
+	}
+}
+
+func (a *APIServer) handleCreateItem(w http.ResponseWriter, r *http.Request) {
+	var item auction.Item
+	if err := json.NewDecoder(r.Body).Decode(&item); err != nil {
+		httpError(w, fmt.Sprintf("couldn't decode item: %v", err), http.StatusBadRequest)
+		return
+	}
+	if !isAdmin(r) {
+		// Only admins can see the reserve, so only they can set it.
+		item.Reserve = 0
+	}
+	created, err := a.auction.CreateItem(item)
+	if err != nil {
+		auctionError(w, "couldn't create item", err, http.StatusBadRequest)
+		return
+	}
+	redactItem(r, created)
+	w.WriteHeader(http.StatusCreated)
+	response := map[string]interface{}{
+		"status": "ok",
+		"item": created,
+	}
+	_ = json.NewEncoder(w).Encode(response)
+}
+
+// catalogFormat picks the catalog format from the format query parameter,
+// falling back to the given content type.
+func catalogFormat(r *http.Request, contentType string) (catalog.Format, error) {
+	if f := r.URL.Query().Get("format"); f != "" {
+		return catalog.ParseFormat(f)
+	}
+	if contentType != "" {
+		return catalog.ParseFormat(strings.TrimSpace(strings.Split(contentType, ";")[0]))
+	}
+	return catalog.JSON, nil
+}
+
+func (a *APIServer) handleImportItems(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		httpError(w, "bad method", http.StatusMethodNotAllowed)
+		return
+	}
+	format, err := catalogFormat(r, r.Header.Get("Content-Type"))
+	if err != nil {
+		httpError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	rows, rowErrors, err := catalog.Read(r.Body, format)
+	if err != nil {
+		httpError(w, fmt.Sprintf("couldn't read catalog: %v", err), http.StatusBadRequest)
+		return
+	}
+	if !isAdmin(r) {
+		// Only admins can see the reserve, so only they can change it.
+		for i := range rows {
+			rows[i].Ignore("reserve")
+		}
+	}
+	dryRun, _ := strconv.ParseBool(r.URL.Query().Get("dryRun"))
+	result := catalog.Import(a.auction, rows, rowErrors, dryRun)
+	response := map[string]interface{}{
+		"status": "ok",
+		"result": result,
+	}
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		httpError(w, fmt.Sprintf("encoding JSON failed: %v", err), http.StatusInternalServerError)
+	}
+}
+
+func (a *APIServer) handleExportItems(w http.ResponseWriter, r *http.Request) {
+	format, err := catalogFormat(r, "")
+	if err != nil {
+		httpError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	items, err := a.auction.GetItems()
+	if err != nil {
+		httpError(w, fmt.Sprintf("couldn't get items: %v", err), http.StatusInternalServerError)
+		return
+	}
+	switch format {
+	case catalog.CSV:
+		w.Header().Set("Content-Type", "text/csv")
+	case catalog.JSON:
+		w.Header().Set("Content-Type", "application/json")
+	}
+	redactItems(r, items)
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="items.%s"`, format))
+	if err := catalog.Write(w, format, items); err != nil {
+		log.Printf("writing catalog export failed: %v", err)
 	}
 }
 
 func (a *APIServer) handleItemBids(w http.ResponseWriter, r *http.Request) {
 	itemId := mux.Vars(r)["itemId"]
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		a.handlePlaceBid(w, r, itemId)
+		return
+	default:
+		httpError(w, "bad method", http.StatusMethodNotAllowed)
+		return
+	}
 	bids, err := a.auction.GetTopBids(itemId, 0)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("couldn't look up bids: %v", err), http.StatusInternalServerError)
+		httpError(w, fmt.Sprintf("couldn't look up bids: %v", err), http.StatusInternalServerError)
 		return
 	}
 	output := map[string]interface{}{
 		"status": "ok",
 		"bids": bids,
 	}
+	if isAdmin(r) {
+		maxBids, err := a.auction.GetMaxBids(itemId)
+		if err != nil {
+			httpError(w, fmt.Sprintf("couldn't look up maximum bids: %v", err), http.StatusInternalServerError)
+			return
+		}
+		output["maxBids"] = maxBids
+		sealedBids, err := a.auction.GetSealedBids(itemId)
+		if err != nil {
+			httpError(w, fmt.Sprintf("couldn't look up sealed bids: %v", err), http.StatusInternalServerError)
+			return
+		}
+		output["sealedBids"] = sealedBids
+	}
 	if err := json.NewEncoder(w).Encode(output); err != nil {
-		http.Error(w, fmt.Sprintf("couldn't encode bids: %v", err), http.StatusInternalServerError)
+		httpError(w, fmt.Sprintf("couldn't encode bids: %v", err), http.StatusInternalServerError)
 	}
 }
 
+func (a *APIServer) handlePlaceBid(w http.ResponseWriter, r *http.Request, itemId string) {
+	bidder := r.FormValue("bidder")
+	if bidder == "" {
+		httpError(w, "no bidder specified", http.StatusBadRequest)
+		return
+	}
+	displayName := r.FormValue("bidderDisplayName")
+	if displayName == "" {
+		displayName = bidder
+	}
+	cents, err := strconv.Atoi(r.FormValue("bid"))
+	if err != nil || cents <= 0 {
+		httpError(w, "bid must be a positive number of cents", http.StatusBadRequest)
+		return
+	}
+	if err := a.auction.Bid(itemId, cents, bidder, displayName); err != nil {
+		auctionError(w, "bid failed", err, http.StatusBadRequest)
+		return
+	}
+	_, _ = w.Write([]byte(`{"status": "ok"}`))
+}
+
+func (a *APIServer) handleBuyNow(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		httpError(w, "bad method", http.StatusMethodNotAllowed)
+		return
+	}
+	itemId := mux.Vars(r)["itemId"]
+	bidder := r.FormValue("bidder")
+	if bidder == "" {
+		httpError(w, "no bidder specified", http.StatusBadRequest)
+		return
+	}
+	displayName := r.FormValue("bidderDisplayName")
+	if displayName == "" {
+		displayName = bidder
+	}
+	if err := a.auction.BuyNow(itemId, bidder, displayName); err != nil {
+		auctionError(w, "buy now failed", err, http.StatusBadRequest)
+		return
+	}
+	_, _ = w.Write([]byte(`{"status": "ok"}`))
+}
+
 func (a *APIServer) handleOpenItem(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
-		http.Error(w, "bad method", http.StatusMethodNotAllowed)
+		httpError(w, "bad method", http.StatusMethodNotAllowed)
 		return
 	}
 	item := r.FormValue("itemId")
 	if item == "" {
-		http.Error(w, "no item specified", http.StatusBadRequest)
+		httpError(w, "no item specified", http.StatusBadRequest)
+		return
+	}
+	deadline, err := parseDeadline(r)
+	if err != nil {
+		httpError(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	if err := a.auction.OpenItem(item); err != nil {
-		http.Error(w, fmt.Sprintf("could not open item: %v", err), http.StatusBadRequest)
+	silent, _ := strconv.ParseBool(r.FormValue("silent"))
+	if err := a.auction.OpenItem(item, auction.OpenOptions{Deadline: deadline, Silent: silent}); err != nil {
+		auctionError(w, "could not open item", err, http.StatusBadRequest)
 		return
 	}
 	_, _ = w.Write([]byte(`{"status": "ok"}`))
@@ -155,26 +565,107 @@ func (a *APIServer) handleOpenItem(w http.ResponseWriter, r *http.Request) {
 
 func (a *APIServer) handleCloseItem(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
-		http.Error(w, "bad method", http.StatusMethodNotAllowed)
+		httpError(w, "bad method", http.StatusMethodNotAllowed)
+		return
+	}
+	itemId, err := a.targetItem(r)
+	if err != nil {
+		auctionError(w, "closing item failed", err, http.StatusBadRequest)
+		return
+	}
+	if err := a.auction.CloseItem(itemId); err != nil {
+		auctionError(w, "closing item failed", err, http.StatusInternalServerError)
+		return
+	}
+	_, _ = w.Write([]byte(`{"status": "ok"}`))
+}
+
+// handlePauseItem stops bidding on an item, and its deadline, without closing
+// it.
+func (a *APIServer) handlePauseItem(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		httpError(w, "bad method", http.StatusMethodNotAllowed)
+		return
+	}
+	itemId, err := a.targetItem(r)
+	if err != nil {
+		auctionError(w, "pausing item failed", err, http.StatusBadRequest)
 		return
 	}
-	if err := a.auction.CloseItem(); err != nil {
-		http.Error(w, fmt.Sprintf("closing item failed: %v.", err), http.StatusInternalServerError)
+	if err := a.auction.PauseItem(itemId); err != nil {
+		auctionError(w, "pausing item failed", err, http.StatusBadRequest)
 		return
 	}
 	_, _ = w.Write([]byte(`{"status": "ok"}`))
 }
 
+func (a *APIServer) handleResumeItem(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		httpError(w, "bad method", http.StatusMethodNotAllowed)
+		return
+	}
+	itemId, err := a.targetItem(r)
+	if err != nil {
+		auctionError(w, "resuming item failed", err, http.StatusBadRequest)
+		return
+	}
+	if err := a.auction.ResumeItem(itemId); err != nil {
+		auctionError(w, "resuming item failed", err, http.StatusBadRequest)
+		return
+	}
+	response := map[string]interface{}{
+		"status": "ok",
+	}
+	if deadline, ok := a.auction.Deadline(itemId); ok {
+		response["deadline"] = deadlineMillis(deadline)
+	}
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		httpError(w, fmt.Sprintf("encoding JSON failed: %v", err), http.StatusInternalServerError)
+	}
+}
+
+// handleItemState moves an item to the state given as "state", such as "paid".
+func (a *APIServer) handleItemState(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		httpError(w, "bad method", http.StatusMethodNotAllowed)
+		return
+	}
+	itemId := mux.Vars(r)["itemId"]
+	state := r.FormValue("state")
+	if state == "" {
+		httpError(w, "no state specified", http.StatusBadRequest)
+		return
+	}
+	if err := a.auction.SetItemState(itemId, state); err != nil {
+		auctionError(w, "changing the item's state failed", err, http.StatusBadRequest)
+		return
+	}
+	item, err := a.auction.GetItem(itemId)
+	if err != nil {
+		auctionError(w, "couldn't get item", err, http.StatusInternalServerError)
+		return
+	}
+	redactItem(r, item)
+	response := map[string]interface{}{
+		"status": "ok",
+		"item": item,
+	}
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		httpError(w, fmt.Sprintf("encoding JSON failed: %v", err), http.StatusInternalServerError)
+	}
+}
+
 func (a *APIServer) handleSpecificBid(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodDelete {
-		http.Error(w, "bad method", http.StatusMethodNotAllowed)
+		httpError(w, "bad method", http.StatusMethodNotAllowed)
 		return
 	}
 	vars := mux.Vars(r)
 	itemId := vars["itemId"]
 	bidId := vars["bidId"]
 	if err := a.auction.DeleteBid(itemId, bidId); err != nil {
-		http.Error(w, fmt.Sprintf("couldn't delete bid: %v", err), http.StatusInternalServerError)
+		auctionError(w, "couldn't delete bid", err, http.StatusInternalServerError)
+		return
 	}
 	_, _ = w.Write([]byte(`{"status": "ok"}`))
 }
@@ -184,6 +675,60 @@ func (a *APIServer) handleTotal(w http.ResponseWriter, r *http.Request) {
 	_ = json.NewEncoder(w).Encode(map[string]interface{}{"status": "ok", "totalCents": total})
 }
 
+// handleReconcile compares the total raised with the winning bids on every sold
+// item. A GET only reports on it, and a POST also corrects the total.
+func (a *APIServer) handleReconcile(w http.ResponseWriter, r *http.Request) {
+	fix := false
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		fix = true
+	default:
+		httpError(w, "bad method", http.StatusMethodNotAllowed)
+		return
+	}
+	reconciliation, err := a.auction.ReconcileTotal(fix)
+	if err != nil {
+		auctionError(w, "couldn't reconcile the total", err, http.StatusInternalServerError)
+		return
+	}
+	response := map[string]interface{}{
+		"status": "ok",
+		"reconciliation": reconciliation,
+		"discrepancy": reconciliation.Discrepancy(),
+	}
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		httpError(w, fmt.Sprintf("encoding JSON failed: %v", err), http.StatusInternalServerError)
+	}
+}
+
+// targetItem returns the item named in the request, or the current item if none
+// was named.
+func (a *APIServer) targetItem(r *http.Request) (string, error) {
+	if itemId := r.FormValue("itemId"); itemId != "" {
+		return itemId, nil
+	}
+	item, err := a.auction.GetCurrentItem()
+	if err != nil {
+		return "", err
+	}
+	return item.ID, nil
+}
+
+// redactItem hides the item's reserve price unless the request was made by an
+// admin.
+func redactItem(r *http.Request, item *auction.Item) {
+	if item != nil && !isAdmin(r) {
+		item.Reserve = 0
+	}
+}
+
+func redactItems(r *http.Request, items []auction.Item) {
+	for i := range items {
+		redactItem(r, &items[i])
+	}
+}
+
 func (a *APIServer) ListenAndServe(addr string) error {
 	a.server.Addr = addr
 	return a.server.ListenAndServe()
@@ -0,0 +1,93 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+
+	"github.com/gorilla/mux"
+
+	"github.com/PonyFest/auction-bot/auction"
+)
+
+// AddAuction serves another auction's API under /api/auctions/{id}, with the
+// same routes as the main auction's under /api. The main auction can be added
+// too, if it has an ID. Auctions must be added before the server starts.
+func (a *APIServer) AddAuction(id string, auc *auction.Auction) {
+	h := mux.NewRouter()
+	scoped := &APIServer{auction: auc, router: h}
+	scoped.routes(h.PathPrefix("/api/auctions/{auctionId}").Subrouter())
+	a.auctions[id] = scoped
+}
+
+// serveAuction passes a request under /api/auctions/{auctionId} on to that
+// auction's routes.
+func (a *APIServer) serveAuction(w http.ResponseWriter, r *http.Request) {
+	scoped, ok := a.auctions[mux.Vars(r)["auctionId"]]
+	if !ok {
+		httpError(w, "no such auction", http.StatusNotFound)
+		return
+	}
+	scoped.router.ServeHTTP(w, r)
+}
+
+// handleAuctions lists the auctions served under /api/auctions, and whether
+// each has been archived.
+func (a *APIServer) handleAuctions(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		httpError(w, "bad method", http.StatusMethodNotAllowed)
+		return
+	}
+	type auctionInfo struct {
+		ID       string `json:"id"`
+		Archived bool   `json:"archived"`
+	}
+	auctions := make([]auctionInfo, 0, len(a.auctions))
+	for id, scoped := range a.auctions {
+		archived, err := scoped.auction.Archived()
+		if err != nil {
+			auctionError(w, fmt.Sprintf("couldn't look up auction %q", id), err, http.StatusInternalServerError)
+			return
+		}
+		auctions = append(auctions, auctionInfo{ID: id, Archived: archived})
+	}
+	sort.Slice(auctions, func(i, j int) bool {
+		return auctions[i].ID < auctions[j].ID
+	})
+	response := map[string]interface{}{
+		"status":   "ok",
+		"auctions": auctions,
+	}
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		httpError(w, fmt.Sprintf("encoding JSON failed: %v", err), http.StatusInternalServerError)
+	}
+}
+
+// handleArchive reports whether the auction has been archived on a GET, and
+// archives it on a POST.
+func (a *APIServer) handleArchive(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		if err := a.auction.Archive(); err != nil {
+			auctionError(w, "archiving the auction failed", err, http.StatusBadRequest)
+			return
+		}
+	default:
+		httpError(w, "bad method", http.StatusMethodNotAllowed)
+		return
+	}
+	archived, err := a.auction.Archived()
+	if err != nil {
+		auctionError(w, "couldn't look up the auction", err, http.StatusInternalServerError)
+		return
+	}
+	response := map[string]interface{}{
+		"status":   "ok",
+		"archived": archived,
+	}
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		httpError(w, fmt.Sprintf("encoding JSON failed: %v", err), http.StatusInternalServerError)
+	}
+}
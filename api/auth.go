@@ -1,30 +1,47 @@
 package api
 
 import (
+	"context"
 	"crypto/subtle"
 	"net/http"
 )
 
+type adminContextKey struct{}
+
 type authedHandler struct {
-	password string
-	handler  http.Handler
+	password      string
+	adminPassword string
+	handler       http.Handler
 }
 
 func (ah *authedHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	if subtle.ConstantTimeCompare([]byte(r.URL.Query().Get("password")), []byte(ah.password)) != 1 {
-		http.Error(w, "Unauthorized.", http.StatusUnauthorized)
+	given := []byte(r.URL.Query().Get("password"))
+	if ah.adminPassword != "" && subtle.ConstantTimeCompare(given, []byte(ah.adminPassword)) == 1 {
+		ah.handler.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), adminContextKey{}, true)))
+		return
+	}
+	if ah.password != "" && subtle.ConstantTimeCompare(given, []byte(ah.password)) != 1 {
+		httpError(w, "Unauthorized.", http.StatusUnauthorized)
 		return
 	}
 
 	ah.handler.ServeHTTP(w, r)
 }
 
-func basicAuth(handler http.Handler, password string) http.Handler {
-	if password == "" {
+// isAdmin reports whether the request was made with the admin password, and so
+// may see secret information such as maximum bids.
+func isAdmin(r *http.Request) bool {
+	admin, _ := r.Context().Value(adminContextKey{}).(bool)
+	return admin
+}
+
+func basicAuth(handler http.Handler, password, adminPassword string) http.Handler {
+	if password == "" && adminPassword == "" {
 		return handler
 	}
 	return &authedHandler{
-		password: password,
-		handler:  handler,
+		password:      password,
+		adminPassword: adminPassword,
+		handler:       handler,
 	}
 }
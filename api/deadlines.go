@@ -0,0 +1,93 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// parseDeadline reads an optional deadline from the request, given either as
+// an RFC 3339 "deadline" or as a "duration" from now such as "5m".
+func parseDeadline(r *http.Request) (time.Time, error) {
+	if d := r.FormValue("deadline"); d != "" {
+		deadline, err := time.Parse(time.RFC3339, d)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid deadline %q: %v", d, err)
+		}
+		return deadline, nil
+	}
+	if d := r.FormValue("duration"); d != "" {
+		duration, err := time.ParseDuration(d)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid duration %q: %v", d, err)
+		}
+		if duration <= 0 {
+			return time.Time{}, fmt.Errorf("invalid duration %q: must be positive", d)
+		}
+		return time.Now().Add(duration), nil
+	}
+	return time.Time{}, nil
+}
+
+// deadlineMillis formats deadlines the same way auction events do.
+func deadlineMillis(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Millisecond)
+}
+
+func (a *APIServer) handleExtendDeadline(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		httpError(w, "bad method", http.StatusMethodNotAllowed)
+		return
+	}
+	itemId, err := a.targetItem(r)
+	if err != nil {
+		auctionError(w, "couldn't extend deadline", err, http.StatusBadRequest)
+		return
+	}
+	var deadline time.Time
+	if by := r.FormValue("by"); by != "" {
+		duration, err := time.ParseDuration(by)
+		if err != nil {
+			httpError(w, fmt.Sprintf("invalid duration %q: %v", by, err), http.StatusBadRequest)
+			return
+		}
+		deadline, err = a.auction.ExtendDeadline(itemId, duration)
+		if err != nil {
+			auctionError(w, "couldn't extend deadline", err, http.StatusBadRequest)
+			return
+		}
+	} else {
+		deadline, err = parseDeadline(r)
+		if err != nil {
+			httpError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if deadline.IsZero() {
+			httpError(w, "one of by, duration or deadline is required", http.StatusBadRequest)
+			return
+		}
+		if err := a.auction.SetDeadline(itemId, deadline); err != nil {
+			auctionError(w, "couldn't set deadline", err, http.StatusBadRequest)
+			return
+		}
+	}
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{"status": "ok", "deadline": deadlineMillis(deadline)})
+}
+
+func (a *APIServer) handleCancelDeadline(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		httpError(w, "bad method", http.StatusMethodNotAllowed)
+		return
+	}
+	itemId, err := a.targetItem(r)
+	if err != nil {
+		auctionError(w, "couldn't cancel deadline", err, http.StatusBadRequest)
+		return
+	}
+	if err := a.auction.CancelDeadline(itemId); err != nil {
+		auctionError(w, "couldn't cancel deadline", err, http.StatusBadRequest)
+		return
+	}
+	_, _ = w.Write([]byte(`{"status": "ok"}`))
+}
@@ -0,0 +1,73 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/PonyFest/auction-bot/auction"
+)
+
+// httpError is like http.Error, but replies with a JSON body of the form
+// {"status": "error", "error": message}.
+func httpError(w http.ResponseWriter, message string, status int) {
+	writeError(w, status, map[string]interface{}{"error": message})
+}
+
+// auctionError replies with the status code that suits an error returned by
+// the auction package, or fallback if it isn't one of the auction's errors.
+// The JSON body includes a "code" naming the error, the minimum bid for
+// ErrBidTooLow, and the item's current state for a TransitionError.
+func auctionError(w http.ResponseWriter, prefix string, err error, fallback int) {
+	body := map[string]interface{}{"error": prefix + ": " + err.Error()}
+	status := fallback
+	var tooLow *auction.ErrBidTooLow
+	var rejected *auction.RejectedError
+	var transition *auction.TransitionError
+	switch {
+	case errors.Is(err, auction.ErrNoCurrentItem):
+		status = http.StatusNotFound
+		body["code"] = "noCurrentItem"
+	case errors.Is(err, auction.ErrItemNotFound):
+		status = http.StatusNotFound
+		body["code"] = "itemNotFound"
+	case errors.Is(err, auction.ErrBidNotFound):
+		status = http.StatusNotFound
+		body["code"] = "bidNotFound"
+	case errors.Is(err, auction.ErrItemClosed):
+		status = http.StatusConflict
+		body["code"] = "itemClosed"
+	case errors.Is(err, auction.ErrItemPaused):
+		status = http.StatusConflict
+		body["code"] = "itemPaused"
+	case errors.Is(err, auction.ErrQueueEmpty):
+		status = http.StatusNotFound
+		body["code"] = "queueEmpty"
+	case errors.Is(err, auction.ErrAuctionArchived):
+		status = http.StatusConflict
+		body["code"] = "auctionArchived"
+	case errors.Is(err, auction.ErrBadEventID):
+		status = http.StatusBadRequest
+		body["code"] = "badEventId"
+	case errors.As(err, &tooLow):
+		status = http.StatusUnprocessableEntity
+		body["code"] = "bidTooLow"
+		body["minimum"] = tooLow.Minimum
+	case errors.As(err, &transition):
+		status = http.StatusConflict
+		body["code"] = "illegalTransition"
+		body["state"] = transition.From
+	case errors.As(err, &rejected):
+		status = http.StatusUnprocessableEntity
+		body["code"] = "rejected"
+	}
+	writeError(w, status, body)
+}
+
+func writeError(w http.ResponseWriter, status int, body map[string]interface{}) {
+	body["status"] = "error"
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(body)
+}
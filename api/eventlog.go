@@ -0,0 +1,82 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	defaultEventLogLimit = 100
+	maxEventLogLimit     = 1000
+)
+
+// handleEventLog returns the events logged after the ID given as "after", or
+// from the start of the auction, up to "limit" at a time. Each event has the
+// same form as on the event stream, with its ID alongside, so the last ID can
+// be passed as "after" to read the next page.
+func (a *APIServer) handleEventLog(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		httpError(w, "bad method", http.StatusMethodNotAllowed)
+		return
+	}
+	a.writeEventLog(w, r, "")
+}
+
+// handleItemEventLog is like handleEventLog, but only returns events about one
+// item.
+func (a *APIServer) handleItemEventLog(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		httpError(w, "bad method", http.StatusMethodNotAllowed)
+		return
+	}
+	itemId := mux.Vars(r)["itemId"]
+	if _, err := a.auction.GetItem(itemId); err != nil {
+		auctionError(w, "couldn't read event log", err, http.StatusInternalServerError)
+		return
+	}
+	a.writeEventLog(w, r, itemId)
+}
+
+func (a *APIServer) writeEventLog(w http.ResponseWriter, r *http.Request, itemId string) {
+	limit := defaultEventLogLimit
+	if l := r.FormValue("limit"); l != "" {
+		var err error
+		limit, err = strconv.Atoi(l)
+		if err != nil || limit <= 0 || limit > maxEventLogLimit {
+			httpError(w, fmt.Sprintf("limit must be between 1 and %d", maxEventLogLimit), http.StatusBadRequest)
+			return
+		}
+	}
+	events, err := a.auction.EventLog(r.FormValue("after"), itemId, limit)
+	if err != nil {
+		auctionError(w, "couldn't read event log", err, http.StatusInternalServerError)
+		return
+	}
+	response := map[string]interface{}{
+		"status": "ok",
+		"events": events,
+	}
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		httpError(w, fmt.Sprintf("encoding JSON failed: %v", err), http.StatusInternalServerError)
+	}
+}
+
+// handleEventStats reports how many clients are subscribed to events, and how
+// many have been dropped for falling behind.
+func (a *APIServer) handleEventStats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		httpError(w, "bad method", http.StatusMethodNotAllowed)
+		return
+	}
+	response := map[string]interface{}{
+		"status": "ok",
+		"stats":  a.auction.EventStats(),
+	}
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		httpError(w, fmt.Sprintf("encoding JSON failed: %v", err), http.StatusInternalServerError)
+	}
+}
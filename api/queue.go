@@ -0,0 +1,203 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// parsePosition reads an optional "position" in the run queue from the
+// request, counting from zero. It is -1, meaning the end, if there isn't one.
+func parsePosition(r *http.Request) (int, error) {
+	p := r.FormValue("position")
+	if p == "" {
+		return -1, nil
+	}
+	position, err := strconv.Atoi(p)
+	if err != nil || position < 0 {
+		return 0, fmt.Errorf("invalid position %q", p)
+	}
+	return position, nil
+}
+
+// handleQueue returns the run queue on a GET, and adds the item given as
+// "itemId" to it on a POST, at "position" if there is one and otherwise at the
+// end.
+func (a *APIServer) handleQueue(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		itemId := r.FormValue("itemId")
+		if itemId == "" {
+			httpError(w, "no item specified", http.StatusBadRequest)
+			return
+		}
+		position, err := parsePosition(r)
+		if err != nil {
+			httpError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := a.auction.QueueItem(itemId, position); err != nil {
+			auctionError(w, "queueing item failed", err, http.StatusBadRequest)
+			return
+		}
+	default:
+		httpError(w, "bad method", http.StatusMethodNotAllowed)
+		return
+	}
+	items, err := a.auction.Queue()
+	if err != nil {
+		auctionError(w, "couldn't get the queue", err, http.StatusInternalServerError)
+		return
+	}
+	redactItems(r, items)
+	response := map[string]interface{}{
+		"status":      "ok",
+		"items":       items,
+		"autoAdvance": a.auction.AutoAdvance().Seconds(),
+	}
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		httpError(w, fmt.Sprintf("encoding JSON failed: %v", err), http.StatusInternalServerError)
+	}
+}
+
+// handleQueuedItem takes an item off the run queue on a DELETE.
+func (a *APIServer) handleQueuedItem(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		httpError(w, "bad method", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := a.auction.UnqueueItem(mux.Vars(r)["itemId"]); err != nil {
+		auctionError(w, "unqueueing item failed", err, http.StatusBadRequest)
+		return
+	}
+	_, _ = w.Write([]byte(`{"status": "ok"}`))
+}
+
+// handleMoveQueuedItem moves an item in the run queue to "position".
+func (a *APIServer) handleMoveQueuedItem(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		httpError(w, "bad method", http.StatusMethodNotAllowed)
+		return
+	}
+	if r.FormValue("position") == "" {
+		httpError(w, "no position specified", http.StatusBadRequest)
+		return
+	}
+	position, err := parsePosition(r)
+	if err != nil {
+		httpError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := a.auction.MoveQueuedItem(mux.Vars(r)["itemId"], position); err != nil {
+		auctionError(w, "moving item failed", err, http.StatusBadRequest)
+		return
+	}
+	_, _ = w.Write([]byte(`{"status": "ok"}`))
+}
+
+// handleSkipQueuedItem moves an item to the back of the run queue.
+func (a *APIServer) handleSkipQueuedItem(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		httpError(w, "bad method", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := a.auction.SkipQueuedItem(mux.Vars(r)["itemId"]); err != nil {
+		auctionError(w, "skipping item failed", err, http.StatusBadRequest)
+		return
+	}
+	_, _ = w.Write([]byte(`{"status": "ok"}`))
+}
+
+// handleNext closes the current item and opens the next one in the run queue,
+// with a deadline if one is given as for /api/openItem.
+func (a *APIServer) handleNext(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		httpError(w, "bad method", http.StatusMethodNotAllowed)
+		return
+	}
+	deadline, err := parseDeadline(r)
+	if err != nil {
+		httpError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	item, err := a.auction.NextItem(deadline)
+	if err != nil {
+		auctionError(w, "opening the next item failed", err, http.StatusBadRequest)
+		return
+	}
+	redactItem(r, item)
+	response := map[string]interface{}{
+		"status": "ok",
+		"item":   item,
+	}
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		httpError(w, fmt.Sprintf("encoding JSON failed: %v", err), http.StatusInternalServerError)
+	}
+}
+
+// handleUpcoming lists the items coming up in the run queue, for overlays. A
+// "limit" gives how many to list, and otherwise the whole queue is.
+func (a *APIServer) handleUpcoming(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		httpError(w, "bad method", http.StatusMethodNotAllowed)
+		return
+	}
+	limit := 0
+	if l := r.FormValue("limit"); l != "" {
+		var err error
+		if limit, err = strconv.Atoi(l); err != nil || limit < 0 {
+			httpError(w, fmt.Sprintf("invalid limit %q", l), http.StatusBadRequest)
+			return
+		}
+	}
+	items, err := a.auction.Queue()
+	if err != nil {
+		auctionError(w, "couldn't get the queue", err, http.StatusInternalServerError)
+		return
+	}
+	if limit > 0 && len(items) > limit {
+		items = items[:limit]
+	}
+	redactItems(r, items)
+	response := map[string]interface{}{
+		"status": "ok",
+		"items":  items,
+	}
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		httpError(w, fmt.Sprintf("encoding JSON failed: %v", err), http.StatusInternalServerError)
+	}
+}
+
+// handleAutoAdvance reports the auto-advance delay on a GET, and sets it to
+// "delay", such as "30s", on a POST. A delay of zero turns auto-advance off.
+func (a *APIServer) handleAutoAdvance(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		d := r.FormValue("delay")
+		delay, err := time.ParseDuration(d)
+		if err != nil {
+			httpError(w, fmt.Sprintf("invalid delay %q: %v", d, err), http.StatusBadRequest)
+			return
+		}
+		if err := a.auction.SetAutoAdvance(delay); err != nil {
+			httpError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	default:
+		httpError(w, "bad method", http.StatusMethodNotAllowed)
+		return
+	}
+	response := map[string]interface{}{
+		"status":      "ok",
+		"autoAdvance": a.auction.AutoAdvance().Seconds(),
+	}
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		httpError(w, fmt.Sprintf("encoding JSON failed: %v", err), http.StatusInternalServerError)
+	}
+}
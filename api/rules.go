@@ -0,0 +1,40 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/PonyFest/auction-bot/auction"
+)
+
+func (a *APIServer) handleBidRules(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut:
+		var rules auction.BidRules
+		if err := json.NewDecoder(r.Body).Decode(&rules); err != nil {
+			httpError(w, fmt.Sprintf("couldn't decode bid rules: %v", err), http.StatusBadRequest)
+			return
+		}
+		if err := a.auction.SetBidRules(rules); err != nil {
+			auctionError(w, "couldn't set bid rules", err, http.StatusBadRequest)
+			return
+		}
+	default:
+		httpError(w, "bad method", http.StatusMethodNotAllowed)
+		return
+	}
+	rules, err := a.auction.BidRules()
+	if err != nil {
+		auctionError(w, "couldn't get bid rules", err, http.StatusInternalServerError)
+		return
+	}
+	response := map[string]interface{}{
+		"status": "ok",
+		"rules":  rules,
+	}
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		httpError(w, fmt.Sprintf("encoding JSON failed: %v", err), http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,191 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gorilla/mux"
+
+	"github.com/PonyFest/auction-bot/auction"
+)
+
+// localTimeLayouts are the layouts accepted for opening times that don't give
+// their own time zone.
+var localTimeLayouts = []string{"2006-01-02T15:04", "2006-01-02T15:04:05", "2006-01-02 15:04", "2006-01-02 15:04:05"}
+
+// parseOpeningTime reads an opening time from the request's "at", given either
+// in RFC 3339 or as a local time such as "2020-09-19T14:30". Local times are in
+// the IANA "timezone" if there is one, such as "America/New_York", and in the
+// auction's time zone otherwise.
+func (a *APIServer) parseOpeningTime(r *http.Request) (time.Time, error) {
+	at := r.FormValue("at")
+	if at == "" {
+		return time.Time{}, fmt.Errorf("no opening time specified")
+	}
+	if t, err := time.Parse(time.RFC3339, at); err == nil {
+		return t, nil
+	}
+	zone := a.auction.TimeZone()
+	if name := r.FormValue("timezone"); name != "" {
+		var err error
+		if zone, err = time.LoadLocation(name); err != nil {
+			return time.Time{}, fmt.Errorf("invalid timezone %q: %v", name, err)
+		}
+	}
+	for _, layout := range localTimeLayouts {
+		if t, err := time.ParseInLocation(layout, at, zone); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid opening time %q", at)
+}
+
+// scheduledItem is an item on the schedule, with when it opens.
+type scheduledItem struct {
+	Item      auction.Item `json:"item"`
+	OpensAt   int64        `json:"opensAt"`
+	Previewed bool         `json:"previewed"`
+}
+
+// schedule returns the items that are scheduled to open, soonest first.
+func (a *APIServer) schedule(r *http.Request) ([]scheduledItem, error) {
+	openings, err := a.auction.Openings()
+	if err != nil {
+		return nil, err
+	}
+	items := make([]scheduledItem, 0, len(openings))
+	for _, opening := range openings {
+		item, err := a.auction.GetItem(opening.ItemID)
+		if err == auction.ErrItemNotFound {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		redactItem(r, item)
+		items = append(items, scheduledItem{Item: *item, OpensAt: deadlineMillis(opening.At), Previewed: opening.Previewed})
+	}
+	return items, nil
+}
+
+// handleSchedule lists the items that are scheduled to open, soonest first.
+func (a *APIServer) handleSchedule(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		httpError(w, "bad method", http.StatusMethodNotAllowed)
+		return
+	}
+	items, err := a.schedule(r)
+	if err != nil {
+		auctionError(w, "couldn't get the schedule", err, http.StatusInternalServerError)
+		return
+	}
+	response := map[string]interface{}{
+		"status":   "ok",
+		"items":    items,
+		"timeZone": a.auction.TimeZone().String(),
+	}
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		httpError(w, fmt.Sprintf("encoding JSON failed: %v", err), http.StatusInternalServerError)
+	}
+}
+
+// handleScheduleCalendar exports the schedule as an iCalendar file, with an
+// event for each item at the time it opens.
+func (a *APIServer) handleScheduleCalendar(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		httpError(w, "bad method", http.StatusMethodNotAllowed)
+		return
+	}
+	items, err := a.schedule(r)
+	if err != nil {
+		auctionError(w, "couldn't get the schedule", err, http.StatusInternalServerError)
+		return
+	}
+	now := time.Now()
+	var b strings.Builder
+	writeICalLine(&b, "BEGIN:VCALENDAR")
+	writeICalLine(&b, "VERSION:2.0")
+	writeICalLine(&b, "PRODID:-//PonyFest//auction-bot//EN")
+	writeICalLine(&b, "CALSCALE:GREGORIAN")
+	for _, item := range items {
+		writeICalLine(&b, "BEGIN:VEVENT")
+		writeICalLine(&b, "UID:"+escapeICalText(item.Item.ID)+"@auction-bot")
+		writeICalLine(&b, "DTSTAMP:"+iCalTime(now))
+		writeICalLine(&b, "DTSTART:"+iCalTime(time.Unix(0, item.OpensAt*int64(time.Millisecond))))
+		writeICalLine(&b, "SUMMARY:"+escapeICalText(item.Item.Title))
+		if item.Item.Description != "" {
+			writeICalLine(&b, "DESCRIPTION:"+escapeICalText(item.Item.Description))
+		}
+		writeICalLine(&b, "END:VEVENT")
+	}
+	writeICalLine(&b, "END:VCALENDAR")
+	w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="schedule.ics"`)
+	_, _ = w.Write([]byte(b.String()))
+}
+
+// iCalTime formats a time as an iCalendar UTC date-time.
+func iCalTime(t time.Time) string {
+	return t.UTC().Format("20060102T150405Z")
+}
+
+// escapeICalText escapes a value for an iCalendar TEXT property.
+func escapeICalText(s string) string {
+	return strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`, "\r", `\n`).Replace(s)
+}
+
+// writeICalLine writes an iCalendar content line, folded so that no line is
+// longer than 75 octets without splitting a UTF-8 character.
+func writeICalLine(b *strings.Builder, line string) {
+	limit := 75
+	for len(line) > limit {
+		cut := limit
+		for cut > 0 && !utf8.RuneStart(line[cut]) {
+			cut--
+		}
+		b.WriteString(line[:cut])
+		b.WriteString("\r\n ")
+		line = line[cut:]
+		// Continuation lines start with a space, which counts.
+		limit = 74
+	}
+	b.WriteString(line)
+	b.WriteString("\r\n")
+}
+
+// handleItemOpening schedules an item to open at "at" on a POST, as read by
+// parseOpeningTime, and cancels its opening on a DELETE.
+func (a *APIServer) handleItemOpening(w http.ResponseWriter, r *http.Request) {
+	itemId := mux.Vars(r)["itemId"]
+	switch r.Method {
+	case http.MethodPost:
+		at, err := a.parseOpeningTime(r)
+		if err != nil {
+			httpError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := a.auction.ScheduleOpening(itemId, at); err != nil {
+			auctionError(w, "scheduling the opening failed", err, http.StatusBadRequest)
+			return
+		}
+		response := map[string]interface{}{
+			"status":  "ok",
+			"opensAt": deadlineMillis(at),
+		}
+		if err := json.NewEncoder(w).Encode(response); err != nil {
+			httpError(w, fmt.Sprintf("encoding JSON failed: %v", err), http.StatusInternalServerError)
+		}
+	case http.MethodDelete:
+		if err := a.auction.CancelOpening(itemId); err != nil {
+			auctionError(w, "cancelling the opening failed", err, http.StatusBadRequest)
+			return
+		}
+		_, _ = w.Write([]byte(`{"status": "ok"}`))
+	default:
+		httpError(w, "bad method", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,42 @@
+package auction
+
+// Archive marks a finished auction as archived, so that it is kept as a record
+// but can no longer change. Everything has to have been closed first, and
+// nothing can be queued or scheduled to open.
+func (a *Auction) Archive() error {
+	return a.store.Archive()
+}
+
+// Archived reports whether the auction has been archived.
+func (a *Auction) Archived() (bool, error) {
+	return a.store.Archived()
+}
+
+// checkLive returns ErrAuctionArchived if the auction has been archived. The
+// store refuses changes to an archived auction itself, so this is only needed
+// by methods that don't go through it, such as CheckUpsert.
+func (a *Auction) checkLive() error {
+	archived, err := a.store.Archived()
+	if err != nil {
+		return err
+	}
+	if archived {
+		return ErrAuctionArchived
+	}
+	return nil
+}
+
+// checkArchivable returns a RejectedError explaining why an auction with the
+// given numbers of open, queued and scheduled items can't be archived yet, if
+// it can't.
+func checkArchivable(open, queued, scheduled int) error {
+	switch {
+	case open > 0:
+		return &RejectedError{Reason: "the auction can't be archived while items are open"}
+	case queued > 0:
+		return &RejectedError{Reason: "the auction can't be archived while items are queued"}
+	case scheduled > 0:
+		return &RejectedError{Reason: "the auction can't be archived while items are scheduled to open"}
+	}
+	return nil
+}
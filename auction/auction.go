@@ -1,23 +1,58 @@
 package auction
 
 import (
-	"encoding/json"
+	"context"
+	"errors"
 	"fmt"
 	"github.com/google/uuid"
 	"log"
-	"strconv"
+	"net/url"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Auction modes, which decide how bidding on an item works.
+const (
+	// ModeEnglish items take open bids, each higher than the last. Items with
+	// no mode are English auction items.
+	ModeEnglish = "english"
+	// ModeSealed items take secret bids, which are only revealed when bidding
+	// closes.
+	ModeSealed = "sealed"
+	// ModeDutch items start at a high price that drops on a schedule, and are
+	// sold to the first person to accept the current price.
+	ModeDutch = "dutch"
+)
 
-	"github.com/go-redis/redis/v7"
+// Outcomes of closing an item.
+const (
+	OutcomeSold   = "sold"
+	OutcomeUnsold = "unsold"
+	// OutcomeReserveNotMet items had bids, but none reached the reserve price,
+	// so they aren't sold and don't count towards the total raised.
+	OutcomeReserveNotMet = "reserveNotMet"
 )
 
-const auctionUpdatesKey = "auction-updates"
-const currentItemKey = "current-item"
-const allItemsKey = "all-items"
-const totalRaisedKey = "total-raised"
+// Pricing rules for sealed-bid items.
+const (
+	// PricingFirst sealed-bid items cost the winner what they bid. Items with no
+	// pricing rule are first-price items.
+	PricingFirst = "first"
+	// PricingSecond sealed-bid items cost the winner the second-highest bid, or
+	// the starting bid if nobody else bid.
+	PricingSecond = "second"
+)
 
 type Auction struct {
-	redis *redis.Client
-	pubsubs []*redis.PubSub
+	// autoAdvance is the auto-advance delay, in nanoseconds. It comes first so
+	// that it can be used atomically on 32-bit platforms.
+	autoAdvance int64
+	store Store
+	buyNowCutoff float64
+	timeZone *time.Location
+	events *eventHub
 }
 
 type Item struct {
@@ -29,6 +64,33 @@ type Item struct {
 	ID string `json:"id"`
 	Donator string `json:"donator"`
 	Country string `json:"country"`
+	ExternalID string `json:"externalId,omitempty"`
+	SoftClose *SoftClose `json:"softClose,omitempty"`
+	Mode string `json:"mode,omitempty"`
+	Pricing string `json:"pricing,omitempty"`
+	Dutch *DutchSchedule `json:"dutch,omitempty"`
+	// Reserve is the secret lowest price the item can be sold for.
+	Reserve int `json:"reserve,omitempty"`
+	// BuyNow is a price at which the item can be bought outright, closing it
+	// immediately, until bidding gets close to it.
+	BuyNow int `json:"buyNow,omitempty"`
+	// Rules override the auction's global bid rules for this item.
+	Rules *BidRules `json:"rules,omitempty"`
+	// Outcome is how bidding on the item ended, if it has closed.
+	Outcome string `json:"outcome,omitempty"`
+	// State is where the item is in its lifecycle, such as StateOpen. Closed
+	// and Outcome are kept in step with it.
+	State string `json:"state,omitempty"`
+}
+
+// SoftClose protects an item with a deadline from sniping: a bid placed within
+// Window seconds of the deadline pushes it back by Extension seconds. If
+// MaxExtension is set, the deadline won't be pushed back by more than that many
+// seconds in total.
+type SoftClose struct {
+	Window int `json:"window"`
+	Extension int `json:"extension"`
+	MaxExtension int `json:"maxExtension"`
 }
 
 type Bid struct {
@@ -37,248 +99,535 @@ type Bid struct {
 	BidderDisplayName string `json:"bidderDisplayName"`
 	ID string `json:"id"`
 	ItemID string `json:"itemId"`
+	Proxy bool `json:"proxy,omitempty"`
+	// BuyNow is set if the bid bought the item at its buy-now price.
+	BuyNow bool `json:"buyNow,omitempty"`
+	// SealedBidCents is what the winner of a sealed-bid item actually bid, which
+	// may be more than the price they pay.
+	SealedBidCents int `json:"sealedBid,omitempty"`
 }
 
-func New(redis *redis.Client) *Auction {
+// New returns an auction kept in the given store, such as NewRedisStore or
+// NewMemoryStore.
+func New(store Store) *Auction {
 	return &Auction{
-		redis: redis,
+		store: store,
+		buyNowCutoff: DefaultBuyNowCutoff,
+		timeZone: time.Local,
+		events: newEventHub(store),
 	}
 }
 
 func (a *Auction) Close() {
-	for _, pubsub := range a.pubsubs {
-		_ = pubsub.Close()
+	a.events.close()
+	_ = a.store.Close()
+}
+
+// Validate checks that the item is fit to be stored.
+func (i *Item) Validate() error {
+	if strings.TrimSpace(i.Title) == "" {
+		return errors.New("items must have a title")
+	}
+	if i.StartBid < 0 {
+		return errors.New("the starting bid cannot be negative")
 	}
+	switch i.Mode {
+	case "", ModeEnglish:
+		if i.Pricing != "" {
+			return errors.New("pricing rules only apply to sealed-bid items")
+		}
+	case ModeSealed:
+		switch i.Pricing {
+		case "", PricingFirst:
+		case PricingSecond:
+			if i.StartBid <= 0 {
+				return errors.New("second-price sealed-bid items must have a starting bid")
+			}
+		default:
+			return fmt.Errorf("unknown pricing rule %q", i.Pricing)
+		}
+	case ModeDutch:
+		if i.Pricing != "" {
+			return errors.New("pricing rules only apply to sealed-bid items")
+		}
+		if i.Dutch == nil {
+			return errors.New("dutch auction items must have a price schedule")
+		}
+		if err := i.Dutch.validate(); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unknown auction mode %q", i.Mode)
+	}
+	if i.Reserve < 0 {
+		return errors.New("the reserve price cannot be negative")
+	}
+	if i.Reserve > 0 && i.Mode == ModeDutch {
+		return errors.New("dutch auction items use a price floor instead of a reserve")
+	}
+	if i.BuyNow < 0 {
+		return errors.New("the buy-now price cannot be negative")
+	}
+	if i.BuyNow > 0 {
+		if i.Mode != "" && i.Mode != ModeEnglish {
+			return errors.New("buy-now prices only apply to english auction items")
+		}
+		if i.BuyNow <= i.StartBid || i.BuyNow < i.Reserve {
+			return errors.New("the buy-now price must be more than the starting bid, and at least the reserve")
+		}
+	}
+	if i.Rules != nil {
+		if err := i.Rules.Validate(); err != nil {
+			return err
+		}
+		if i.Rules.Cap > 0 && (i.StartBid > i.Rules.Cap || i.Reserve > i.Rules.Cap) {
+			return errors.New("the starting bid and reserve cannot be more than the bid cap")
+		}
+	}
+	if i.Dutch != nil && i.Mode != ModeDutch {
+		return errors.New("price schedules only apply to dutch auction items")
+	}
+	if i.SoftClose != nil {
+		if i.SoftClose.Window <= 0 || i.SoftClose.Extension <= 0 {
+			return errors.New("soft close windows and extensions must be positive")
+		}
+		if i.SoftClose.MaxExtension < 0 {
+			return errors.New("the maximum soft close extension cannot be negative")
+		}
+	}
+	for _, image := range i.Images {
+		u, err := url.Parse(image)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("%q is not a valid image URL", image)
+		}
+	}
+	return nil
 }
 
+// normaliseImages stores an empty image list as null, because Lua's cjson
+// re-encodes empty arrays as objects when scripts rewrite the item.
+func normaliseImages(item *Item) {
+	if len(item.Images) == 0 {
+		item.Images = nil
+	}
+}
+
+// CurrentItem returns the item that is up for live auction, or nil if there
+// isn't one.
 func (a *Auction) CurrentItem() *Item {
-	itemID, err := a.redis.Get(currentItemKey).Result()
+	item, err := a.GetCurrentItem()
 	if err != nil {
 		return nil
 	}
-	if itemID == "" {
-		return nil
+	return item
+}
+
+// GetCurrentItem returns the item that is up for live auction, or
+// ErrNoCurrentItem if there isn't one.
+func (a *Auction) GetCurrentItem() (*Item, error) {
+	itemID, err := a.store.CurrentItemID()
+	if err != nil {
+		return nil, err
 	}
 	item, err := a.GetItem(itemID)
-	if err != nil {
-		return nil
+	if err == ErrItemNotFound {
+		return nil, ErrNoCurrentItem
 	}
-	return item
+	return item, err
 }
 
-func (a *Auction) GetItems() ([]Item, error) {
-	itemIDs := a.redis.SMembers(allItemsKey).Val()
-	itemBlobs := a.redis.MGet(itemIDs...).Val()
-	items := make([]Item, 0, len(itemBlobs))
-	for i, b := range itemBlobs {
-		var item Item
-		if err := json.Unmarshal([]byte(b.(string)), &item); err != nil {
+// OpenItems returns every item that is currently open for bidding.
+func (a *Auction) OpenItems() ([]Item, error) {
+	itemIDs, err := a.store.OpenItemIDs()
+	if err != nil {
+		return nil, err
+	}
+	items := make([]Item, 0, len(itemIDs))
+	for _, itemID := range itemIDs {
+		item, err := a.GetItem(itemID)
+		if err != nil {
 			continue
 		}
-		item.ID = itemIDs[i]
-		items = append(items, item)
+		items = append(items, *item)
 	}
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].Title < items[j].Title
+	})
 	return items, nil
 }
 
-func (a *Auction) GetItem(itemID string) (*Item, error) {
-	itemJSON, err := a.redis.Get(itemID).Result()
+// IsOpen reports whether the item is open for bidding.
+func (a *Auction) IsOpen(itemID string) bool {
+	return a.store.IsOpen(itemID)
+}
+
+// FindItem looks up an item by either its ID or its external ID, which is
+// usually easier for people to type.
+func (a *Auction) FindItem(ref string) (*Item, error) {
+	item, err := a.GetItem(ref)
+	if err == ErrItemNotFound {
+		return a.GetItemByExternalID(ref)
+	}
+	return item, err
+}
+
+func (a *Auction) GetItems() ([]Item, error) {
+	items, err := a.store.GetItems()
 	if err != nil {
 		return nil, err
 	}
-	var item Item
-	if err := json.Unmarshal([]byte(itemJSON), &item); err != nil {
+	if err := a.fillStates(items); err != nil {
 		return nil, err
 	}
-	item.ID = itemID
-	return &item, nil
+	return items, nil
 }
 
-// GetTopBids returns the top bids.
-// if bids is positive, it returns that many bids
-// if bids is zero, is returns all bids
-// if bids is negative, the behaviour is undefined
-func (a *Auction) GetTopBids(itemID string, bids int) ([]Bid, error) {
-	bids *= -1
-	result, err := a.redis.LRange("bids-" + itemID, int64(bids), -1).Result()
+// GetItemsInState returns the items in any of the given states.
+func (a *Auction) GetItemsInState(states ...string) ([]Item, error) {
+	items, err := a.GetItems()
 	if err != nil {
 		return nil, err
 	}
-	ret := make([]Bid, 0, len(result))
-	for _, bidThing := range result {
-		var bid Bid
-		if err := json.Unmarshal([]byte(bidThing), &bid); err != nil {
-			continue
+	wanted := map[string]bool{}
+	for _, state := range states {
+		wanted[state] = true
+	}
+	matching := make([]Item, 0, len(items))
+	for _, item := range items {
+		if wanted[item.State] {
+			matching = append(matching, item)
 		}
-		ret = append(ret, bid)
 	}
-	return ret, nil
+	return matching, nil
 }
 
-func (a *Auction) Bid(cents int, bidder string, displayName string) error {
-	itemID, err := a.redis.Get(currentItemKey).Result()
+// GetItem returns the item with the given ID, or ErrItemNotFound.
+func (a *Auction) GetItem(itemID string) (*Item, error) {
+	item, err := a.store.GetItem(itemID)
 	if err != nil {
-		return nil
+		return nil, err
+	}
+	if item.State == "" {
+		item.State = stateOf(*item, a.store.IsOpen(itemID))
 	}
-	s := `
-local bid = tonumber(ARGV[1])
-local bidKey = KEYS[1]
-local auctionUpdatesKey = KEYS[2]
-local bidder = ARGV[2]
-local bidderDisplayName = ARGV[3]
-local bidId = ARGV[4]
-local itemId = ARGV[5]
-local currentBidInfo = redis.call("LRANGE", bidKey, -1, -1)
-if table.getn(currentBidInfo) > 0 then
-	local currentBid = cjson.decode(currentBidInfo[1])["bid"]
-	if currentBid + 100 > bid then
-		return redis.error_reply(string.format("you must bid at least $1 more than the previous high bid of $%d.%02d", currentBid / 100, currentBid % 100))
-	end
-end
-redis.call("RPUSH", bidKey, cjson.encode({bid=bid, bidder=bidder, bidderDisplayName=bidderDisplayName, id=bidId, itemId=itemId}))
-redis.call("PUBLISH", auctionUpdatesKey, cjson.encode({event="bid", bid=bid, bidder=bidder, bidderDisplayName=bidderDisplayName, id=bidId, itemId=itemId}))
-return redis.status_reply("ok")`
-	script := redis.NewScript(s)
-	bidId := uuid.New()
-	if err := script.Run(a.redis, []string{"bids-" + itemID, auctionUpdatesKey}, strconv.Itoa(cents), bidder, displayName, bidId.String(), itemID).Err(); err != nil {
-		return err
+	return item, nil
+}
+
+// fillStates fills in the state of items stored before items had states.
+func (a *Auction) fillStates(items []Item) error {
+	var open map[string]bool
+	for i := range items {
+		if items[i].State != "" {
+			continue
+		}
+		if open == nil {
+			itemIDs, err := a.store.OpenItemIDs()
+			if err != nil {
+				return err
+			}
+			open = map[string]bool{}
+			for _, itemID := range itemIDs {
+				open[itemID] = true
+			}
+		}
+		items[i].State = stateOf(items[i], open[items[i].ID])
 	}
 	return nil
 }
 
-func (a *Auction) OpenItem(itemId string) error {
-	item, err := a.GetItem(itemId)
+// CreateItem stores a new item, generating an ID for it. The item's ID and
+// closed status are ignored. New items are approved unless they are drafts.
+func (a *Auction) CreateItem(item Item) (*Item, error) {
+	if err := item.Validate(); err != nil {
+		return nil, err
+	}
+	switch item.State {
+	case "":
+		item.State = StateApproved
+	case StateDraft, StateApproved:
+	default:
+		return nil, errors.New("new items must be drafts or approved")
+	}
+	item.ID = uuid.New().String()
+	setState(&item, item.State)
+	normaliseImages(&item)
+	if err := a.store.CreateItem(item); err != nil {
+		return nil, err
+	}
+	return &item, nil
+}
+
+// UpdateItem replaces the item with the given ID. The state of the item cannot
+// be changed this way, and the starting bid, reserve, buy-now price, auction
+// mode, bid rules and soft close cannot be changed while the item is open or
+// once it has received bids.
+func (a *Auction) UpdateItem(itemID string, item Item) (*Item, error) {
+	return a.updateItem(itemID, func(old Item) Item {
+		return item
+	})
+}
+
+// updateItem replaces an item with the result of calling update on it, as
+// UpdateItem does. update may be called more than once.
+func (a *Auction) updateItem(itemID string, update func(old Item) Item) (*Item, error) {
+	return a.store.UpdateItem(itemID, func(old Item) (Item, bool, error) {
+		return updatedItem(old, update(old))
+	})
+}
+
+// updatedItem returns item as an update to old, keeping the fields that can't
+// be changed by updating an item, and reports whether the update is destructive.
+func updatedItem(old, item Item) (Item, bool, error) {
+	item.ID = old.ID
+	item.Closed = old.Closed
+	item.Outcome = old.Outcome
+	item.State = old.State
+	normaliseImages(&item)
+	if err := item.Validate(); err != nil {
+		return Item{}, false, err
+	}
+	destructive := item.StartBid != old.StartBid || item.Reserve != old.Reserve || item.BuyNow != old.BuyNow || item.Mode != old.Mode || item.Pricing != old.Pricing || !reflect.DeepEqual(item.Dutch, old.Dutch) || !reflect.DeepEqual(item.Rules, old.Rules) || !reflect.DeepEqual(item.SoftClose, old.SoftClose)
+	return item, destructive, nil
+}
+
+// DeleteItem removes an item from the auction. Items that are open or have
+// received bids cannot be deleted.
+func (a *Auction) DeleteItem(itemID string) error {
+	return a.store.DeleteItem(itemID)
+}
+
+// GetItemByExternalID returns the item that was imported with the given
+// external ID, or ErrItemNotFound.
+func (a *Auction) GetItemByExternalID(externalID string) (*Item, error) {
+	item, err := a.store.GetItemByExternalID(externalID)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	if item == nil {
-		return fmt.Errorf("no item with ID %q", itemId)
+	if item.State == "" {
+		item.State = stateOf(*item, a.store.IsOpen(item.ID))
 	}
-	if item.Closed {
-		bids, _ := a.GetTopBids(itemId, 1)
-		if len(bids) == 1 {
-			a.redis.DecrBy(totalRaisedKey, int64(bids[0].BidCents))
-		}
+	return item, nil
+}
+
+// UpsertItem creates or updates the item with the given external ID, and
+// reports whether a new item was created. apply sets the fields being upserted,
+// on the existing item or on an empty one, so that an existing item keeps any
+// fields it doesn't set.
+func (a *Auction) UpsertItem(externalID string, apply func(item *Item)) (*Item, bool, error) {
+	if externalID == "" {
+		return nil, false, errors.New("items must have an external ID to be upserted")
+	}
+	existing, err := a.GetItemByExternalID(externalID)
+	if err == ErrItemNotFound {
+		item := Item{ExternalID: externalID}
+		apply(&item)
+		created, err := a.CreateItem(item)
+		return created, true, err
 	}
-	if err := a.redis.Set(currentItemKey, itemId, 0).Err(); err != nil {
-		return fmt.Errorf("couldn't set current-item: %v", err)
+	if err != nil {
+		return nil, false, err
 	}
-	return a.redis.Publish(auctionUpdatesKey, `{"event": "openItem", "itemId": "` + itemId + `"}`).Err()
+	updated, err := a.updateItem(existing.ID, func(old Item) Item {
+		apply(&old)
+		old.ExternalID = externalID
+		return old
+	})
+	return updated, false, err
 }
 
-func (a *Auction) CloseItem() error {
-	currentItem, err := a.redis.Get(currentItemKey).Result()
+// CheckUpsert reports whether UpsertItem would create a new item, and returns
+// the error it would fail with, without changing anything.
+func (a *Auction) CheckUpsert(externalID string, apply func(item *Item)) (bool, error) {
+	if externalID == "" {
+		return false, errors.New("items must have an external ID to be upserted")
+	}
+	if err := a.checkLive(); err != nil {
+		return false, err
+	}
+	existing, err := a.GetItemByExternalID(externalID)
+	if err == ErrItemNotFound {
+		item := Item{ExternalID: externalID}
+		apply(&item)
+		return true, item.Validate()
+	}
 	if err != nil {
-		return err
-	}
-	s := `
-local key = KEYS[1]
-local json = cjson.decode(redis.call("GET", key))
-json.closed = true
-redis.call("SET", key, cjson.encode(json))
-return redis.status_reply("OK")
-`
-	script := redis.NewScript(s)
-	if err := script.Run(a.redis, []string{currentItem}).Err(); err != nil {
-		return fmt.Errorf("failed to update closed status: %v", err)
-	}
-	if err := a.redis.Set(currentItemKey, "", 0).Err(); err != nil {
-		return err
-	}
-	highestBids, _ := a.GetTopBids(currentItem, 1)
-	if len(highestBids) == 1 {
-		if err := a.redis.IncrBy(totalRaisedKey, int64(highestBids[0].BidCents)).Err(); err != nil {
-			// failing here would be confusing?
-			// really this should all be an atomic operation.
-			//return err
-		}
+		return false, err
 	}
-	return a.redis.Publish(auctionUpdatesKey, `{"event": "closeItem", "itemId": "` + currentItem + `"}`).Err()
+	item := *existing
+	apply(&item)
+	item.ExternalID = externalID
+	if _, destructive, err := updatedItem(*existing, item); err != nil || !destructive {
+		return false, err
+	}
+	if a.store.IsOpen(existing.ID) {
+		return false, &RejectedError{Reason: "the starting bid and bidding rules cannot be changed while the item is open"}
+	}
+	bids, err := a.store.GetBids(existing.ID, 1)
+	if err != nil {
+		return false, err
+	}
+	sealedBids, err := a.store.GetSealedBids(existing.ID)
+	if err != nil {
+		return false, err
+	}
+	if len(bids) > 0 || len(sealedBids) > 0 {
+		return false, &RejectedError{Reason: "the starting bid and bidding rules cannot be changed once the item has bids"}
+	}
+	return false, nil
 }
 
-func (a *Auction) DeleteBid(itemId, bidId string) error {
-	s := `
-local bidsKey = KEYS[1]
-local currentItemKey = KEYS[2]
-local totalRaisedKey = KEYS[3]
-local auctionUpdatesKey = KEYS[4]
-local itemId = ARGV[1]
-local bidId = ARGV[2]
-local bids = redis.call("LRANGE", bidsKey, 0, -1)
-for i, bid in ipairs(bids) do
-	local bidInfo = cjson.decode(bid)
-	if bidInfo.id == bidId then
-		redis.call("LREM", bidsKey, 0, bid)
-		-- if i == table.getn(bids) then
-		-- 	if redis.call("GET", currentItemKey) ~= itemId then
-		--		redis.call("DECRBY", totalRaisedKey, bidInfo.bid)
-		--	end
-		-- end
-		redis.call("PUBLISH", auctionUpdatesKey, cjson.encode({event="deleteBid", itemId=itemId, bidId=bidId, bid=bidInfo.bid, bidder=bidInfo.bidder, bidderDisplayName=bidInfo.bidderDisplayName}))
-		return redis.status_reply("ok")
-	end
-end
-return redis.error_reply("no such bid exists")
-`
-	script := redis.NewScript(s)
-	if err := script.Run(a.redis, []string{"bids-" + itemId, currentItemKey, totalRaisedKey, auctionUpdatesKey}, itemId, bidId).Err(); err != nil {
-		return err
+// GetTopBids returns the top bids.
+// if bids is positive, it returns that many bids
+// if bids is zero, is returns all bids
+// if bids is negative, the behaviour is undefined
+func (a *Auction) GetTopBids(itemID string, bids int) ([]Bid, error) {
+	return a.store.GetBids(itemID, bids)
+}
+
+// OpenOptions control how an item is opened.
+type OpenOptions struct {
+	// Deadline is when bidding on the item will automatically close. If it is
+	// zero, the item stays open until it is explicitly closed.
+	Deadline time.Time
+	// Silent opens the item alongside any others that are already open, for
+	// silent auctions. Otherwise, the item replaces the current item.
+	Silent bool
+}
+
+func (a *Auction) OpenItem(itemId string, options OpenOptions) error {
+	return a.store.OpenItem(itemId, options, time.Now())
+}
+
+// CloseItem closes bidding on an open item.
+func (a *Auction) CloseItem(itemID string) error {
+	_, err := a.store.CloseItem(itemID, time.Time{})
+	return err
+}
+
+// SetItemState moves an item to another state, if it can go there from the
+// state it is in, and otherwise returns a TransitionError. Items are opened and
+// closed with OpenItem and CloseItem instead.
+func (a *Auction) SetItemState(itemID, state string) error {
+	if !ValidState(state) {
+		return fmt.Errorf("unknown item state %q", state)
 	}
-	return nil
+	if !manualStates[state] {
+		return &RejectedError{Reason: fmt.Sprintf("items can't be %s by changing their state", stateActions[state])}
+	}
+	return a.store.SetItemState(itemID, state)
+}
+
+func (a *Auction) DeleteBid(itemId, bidId string) error {
+	return a.store.DeleteBid(itemId, bidId)
 }
 
 func (a *Auction) TotalRaisedCents() int {
-	raisedString := a.redis.Get(totalRaisedKey).Val()
-	if raisedString == "" {
+	total, err := a.store.TotalRaised()
+	if err != nil {
 		return 0
 	}
-	raisedCents, err := strconv.Atoi(raisedString)
+	return total
+}
+
+// Reconciliation compares the recorded total raised with the total of the
+// winning bids on every item that was sold. Amounts are in cents.
+type Reconciliation struct {
+	Recorded int `json:"recorded"`
+	Computed int `json:"computed"`
+	// Fixed is set if the recorded total was replaced by the computed one.
+	Fixed bool `json:"fixed"`
+}
+
+// Discrepancy is how much more the recorded total is than it should be.
+func (r *Reconciliation) Discrepancy() int {
+	return r.Recorded - r.Computed
+}
+
+// ReconcileTotal recomputes the total raised from the winning bids on every
+// item that was sold, and reports whether it matches the recorded total. If
+// fix is set and it doesn't, the recorded total is corrected.
+func (a *Auction) ReconcileTotal(fix bool) (*Reconciliation, error) {
+	recorded, computed, err := a.store.ReconcileTotal(fix)
 	if err != nil {
-		return 0
+		return nil, err
 	}
-	return raisedCents
+	return &Reconciliation{
+		Recorded: recorded,
+		Computed: computed,
+		Fixed: fix && recorded != computed,
+	}, nil
 }
 
 // Events returns a channel that will receive auction event updates.
 // If called repeatedly, it will return a new channel each time. Each channel will
-// receive every event after it is created.
+// receive every event after it is created, until the auction is closed or it
+// falls more than DefaultEventBuffer events behind.
 func (a *Auction) Events() <-chan Event {
-	pubsub := a.redis.Subscribe(auctionUpdatesKey)
-	a.pubsubs = append(a.pubsubs, pubsub)
-	ch := pubsub.Channel()
 	out := make(chan Event)
+	ch, err := a.EventsAfter(context.Background(), "")
+	if err != nil {
+		log.Printf("Subscribing to auction events failed: %v.\n", err)
+		close(out)
+		return out
+	}
 	go func() {
-		for {
-			message, ok := <-ch
-			log.Println(message, ok)
-			if !ok {
-				break
-			}
-			var e genericEvent
-			if err := json.Unmarshal([]byte(message.Payload), &e); err != nil {
-				continue
-			}
-			var what Event
-			switch e.Event() {
-			case "openItem":
-				what = &OpenItemEvent{}
-			case "closeItem":
-				what = &CloseItemEvent{}
-			case "bid":
-				what = &BidEvent{}
-			case "deleteBid":
-				what = &DeleteBidEvent{}
-			}
-			if what == nil {
-				continue
-			}
-			if err := json.Unmarshal([]byte(message.Payload), what); err != nil {
-				log.Printf("Couldn't unmarshal event: %v.\n", err)
-				continue
-			}
-			out <- what
+		for e := range ch {
+			out <- e.Event
 		}
 		close(out)
 	}()
 	return out
 }
+
+// EventsAfter returns a channel that will receive every event logged after the
+// given ID, and then every new event, so that a subscriber that went away can
+// resume where it left off. If the ID is empty, only new events are received.
+//
+// The channel is closed when ctx is done. It is also closed if the subscriber
+// falls more than DefaultEventBuffer events behind, or resumes from further
+// back than that, in which case it can subscribe again from the last event it
+// received. An ID later than any in the log, such as one from before the log
+// was reset, is treated as empty.
+func (a *Auction) EventsAfter(ctx context.Context, after string) (<-chan LoggedEvent, error) {
+	return a.events.subscribe(ctx, after)
+}
+
+// EventStats reports on the subscribers to the auction's events.
+func (a *Auction) EventStats() EventStats {
+	return a.events.stats()
+}
+
+// EventLog returns up to limit events logged after the given ID, or from the
+// start if it is empty, oldest first. If itemID isn't empty, only events about
+// that item are returned. A limit of zero or less returns every event.
+func (a *Auction) EventLog(after, itemID string, limit int) ([]LoggedEvent, error) {
+	entries, err := a.store.EventLog(after, itemID, limit)
+	if err != nil {
+		return nil, err
+	}
+	events := make([]LoggedEvent, 0, len(entries))
+	for _, entry := range entries {
+		e, err := decodeLogEntry(entry)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't unmarshal event %s: %v", entry.ID, err)
+		}
+		if e != nil {
+			events = append(events, *e)
+		}
+	}
+	return events, nil
+}
+
+// ReserveStatus reports whether the item has a reserve price, and if so,
+// whether its high bid meets it, without revealing the reserve itself.
+func (a *Auction) ReserveStatus(itemID string) (hasReserve bool, met bool) {
+	item, err := a.GetItem(itemID)
+	if err != nil || item.Reserve == 0 {
+		return false, false
+	}
+	bids, err := a.GetTopBids(itemID, 1)
+	if err != nil || len(bids) == 0 {
+		return true, false
+	}
+	return true, bids[0].BidCents >= item.Reserve
+}
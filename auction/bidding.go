@@ -0,0 +1,64 @@
+package auction
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// MaxBid is the most a bidder has asked to automatically bid on an item. It is
+// secret: only the bids placed on the bidder's behalf are public.
+type MaxBid struct {
+	MaxCents int `json:"max"`
+	Bidder string `json:"bidder"`
+	BidderDisplayName string `json:"bidderDisplayName"`
+	// SetAt is when the maximum was set, in milliseconds since the Unix epoch.
+	// If two bidders have the same maximum, the earlier one wins.
+	SetAt int64 `json:"setAt"`
+}
+
+// Bid places a bid on an open item. A bid on a dutch auction item claims it at
+// the current price, as long as the bid is at least that much, and closes it.
+func (a *Auction) Bid(itemID string, cents int, bidder string, displayName string) error {
+	return a.runBidScript("bid", itemID, cents, bidder, displayName)
+}
+
+// SetMaxBid sets the most the bidder is willing to pay for an open item.
+// Whenever someone else holds the high bid, the auction will automatically bid
+// on the bidder's behalf, by the smallest amount it can, until the maximum is
+// reached.
+func (a *Auction) SetMaxBid(itemID string, cents int, bidder string, displayName string) error {
+	return a.runBidScript("max", itemID, cents, bidder, displayName)
+}
+
+func (a *Auction) runBidScript(mode string, itemID string, cents int, bidder string, displayName string) error {
+	return a.store.PlaceBid(BidRequest{
+		ItemID: itemID,
+		Cents: cents,
+		Bidder: bidder,
+		DisplayName: displayName,
+		BidID: uuid.New().String(),
+		Max: mode == "max",
+		Take: mode == "take",
+		Now: time.Now(),
+	})
+}
+
+// GetMaxBids returns every maximum bid set on the item, which should only be
+// shown to auction administrators.
+func (a *Auction) GetMaxBids(itemID string) ([]MaxBid, error) {
+	return a.store.GetMaxBids(itemID)
+}
+
+// MaxBid returns the bidder's maximum bid on the item, or nil if they don't
+// have one.
+func (a *Auction) MaxBid(itemID, bidder string) (*MaxBid, error) {
+	return a.store.GetMaxBid(itemID, bidder)
+}
+
+// GetSealedBids returns the secret bids placed on a sealed-bid item that is
+// still open, which should only be shown to auction administrators. Once the
+// item closes, its bids are revealed and returned by GetTopBids instead.
+func (a *Auction) GetSealedBids(itemID string) ([]Bid, error) {
+	return a.store.GetSealedBids(itemID)
+}
@@ -0,0 +1,393 @@
+package auction
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Stores that can't run the redis scripts share the auction logic here
+// instead, so that they all make the same decisions. A store loads the state
+// of the item, lets these functions decide what happens, and saves the result,
+// all inside one lock or transaction.
+
+// bidState is everything that placing a bid on an open item depends on.
+type bidState struct {
+	item  Item
+	rules BidRules
+	// top is the high bid, if there is one.
+	top     *Bid
+	maxBids map[string]MaxBid
+	// sealedBids is how many sealed bids the item has, and hasSealedBid whether
+	// the bidder has one of them.
+	sealedBids   int
+	hasSealedBid bool
+	// deadline is when bidding closes, in milliseconds since the Unix epoch,
+	// or zero if it doesn't. extended is how far late bids have already pushed
+	// it back.
+	deadline int64
+	extended int64
+	// dutchPrice is the current price of a dutch auction item, if it has one.
+	dutchPrice    int
+	hasDutchPrice bool
+}
+
+// bidResult is what placing a bid changes. The store saves it, then publishes
+// the events.
+type bidResult struct {
+	// bids are new bids, to go on top of the item's bids in order.
+	bids      []Bid
+	maxBid    *MaxBid
+	sealedBid *sealedBid
+	// deadline is the new deadline, if the bid extended it, and extension is
+	// how far it was extended.
+	deadline  int64
+	extension int64
+	// claimed is set if the bid claimed a dutch auction item, which the store
+	// closes along with placing the bid.
+	claimed bool
+	events  []Event
+}
+
+// sealedBid is a bid on a sealed-bid item that hasn't been revealed yet.
+type sealedBid struct {
+	Bid
+	// PlacedAt is when the bid was placed, in milliseconds since the Unix
+	// epoch. Of equal bids, the earliest wins.
+	PlacedAt int64
+}
+
+// mergeRules lays the global rules over the defaults, and the item's own rules
+// over those.
+func mergeRules(global *BidRules, item *BidRules) BidRules {
+	rules := DefaultBidRules
+	for _, r := range []*BidRules{global, item} {
+		if r == nil {
+			continue
+		}
+		if len(r.Increments) > 0 {
+			rules.Increments = r.Increments
+		}
+		if r.MaxJump > 0 {
+			rules.MaxJump = r.MaxJump
+		}
+		if r.Cap > 0 {
+			rules.Cap = r.Cap
+		}
+	}
+	return rules
+}
+
+func (r BidRules) incrementAbove(amount int) int {
+	for _, step := range r.Increments {
+		if step.Below == 0 || amount < step.Below {
+			return step.Increment
+		}
+	}
+	return r.Increments[len(r.Increments)-1].Increment
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+func dollars(cents int) string {
+	return fmt.Sprintf("$%d.%02d", cents/100, cents%100)
+}
+
+// placeBid decides what a bid on an open item does, as the redis bid script
+// does.
+func placeBid(st bidState, req BidRequest) (*bidResult, error) {
+	now := unixMillis(req.Now)
+	if st.deadline != 0 && st.deadline <= now {
+		return nil, ErrItemClosed
+	}
+	if st.item.State == StatePaused {
+		return nil, ErrItemPaused
+	}
+	item, rules, itemID := st.item, st.rules, st.item.ID
+	result := &bidResult{}
+	capProblem := func(amount int) error {
+		if rules.Cap > 0 && amount > rules.Cap {
+			return &RejectedError{Reason: fmt.Sprintf("bids on this item can't be more than %s", dollars(rules.Cap))}
+		}
+		return nil
+	}
+
+	switch item.Mode {
+	case ModeSealed:
+		// Sealed bids skip the usual rules, because bidders can't see each
+		// other's bids. Bidders may replace their bid until bidding closes.
+		if req.Max || req.Take {
+			return nil, &RejectedError{Reason: "maximum bids can't be used on sealed-bid items"}
+		}
+		minimum := maxInt(item.StartBid, 1)
+		if req.Cents < minimum {
+			return nil, &ErrBidTooLow{Minimum: minimum, reason: "you must bid at least " + dollars(minimum)}
+		}
+		if err := capProblem(req.Cents); err != nil {
+			return nil, err
+		}
+		result.sealedBid = &sealedBid{
+			Bid:      Bid{BidCents: req.Cents, Bidder: req.Bidder, BidderDisplayName: req.DisplayName, ID: req.BidID, ItemID: itemID},
+			PlacedAt: now,
+		}
+		count := st.sealedBids
+		if !st.hasSealedBid {
+			count++
+		}
+		result.events = append(result.events, SealedBidEvent{ItemID: itemID, BidCount: count})
+		return result, nil
+
+	case ModeDutch:
+		// The first bid at or above the current price claims the item at that
+		// price; taking it accepts whatever the current price is.
+		if req.Max {
+			return nil, &RejectedError{Reason: "maximum bids can't be used on dutch auction items"}
+		}
+		if st.top != nil {
+			return nil, ErrItemClosed
+		}
+		if !st.hasDutchPrice {
+			return nil, &RejectedError{Reason: "this item has no current price"}
+		}
+		price := st.dutchPrice
+		if !req.Take && req.Cents < price {
+			return nil, &ErrBidTooLow{Minimum: price, reason: "the current price is " + dollars(price)}
+		}
+		bid := Bid{BidCents: price, Bidder: req.Bidder, BidderDisplayName: req.DisplayName, ID: req.BidID, ItemID: itemID}
+		result.bids = append(result.bids, bid)
+		result.events = append(result.events, BidEvent{Bid: bid})
+		result.claimed = true
+		return result, nil
+	}
+
+	top := st.top
+	minimumBid := func(top *Bid) int {
+		if top != nil {
+			return top.BidCents + rules.incrementAbove(top.BidCents)
+		}
+		return maxInt(item.StartBid, 1)
+	}
+
+	// Bids on items with a reserve say whether the reserve has been met,
+	// without revealing what it is.
+	pushBid := func(amount int, bidder, displayName string, proxy bool) {
+		id := req.BidID
+		if len(result.bids) > 0 {
+			id = fmt.Sprintf("%s-%d", req.BidID, len(result.bids)+1)
+		}
+		bid := Bid{BidCents: amount, Bidder: bidder, BidderDisplayName: displayName, ID: id, ItemID: itemID, Proxy: proxy}
+		result.bids = append(result.bids, bid)
+		event := BidEvent{Bid: bid}
+		if item.Reserve > 0 {
+			met := amount >= item.Reserve
+			event.ReserveMet = &met
+		}
+		result.events = append(result.events, event)
+		top = &result.bids[len(result.bids)-1]
+	}
+
+	if err := capProblem(req.Cents); err != nil {
+		return nil, err
+	}
+	maxBids := map[string]MaxBid{}
+	for bidder, m := range st.maxBids {
+		maxBids[bidder] = m
+	}
+	if !req.Max {
+		minimum := minimumBid(top)
+		if top == nil && req.Cents < minimum {
+			return nil, &ErrBidTooLow{Minimum: minimum, reason: "the first bid must be at least the starting bid of " + dollars(minimum)}
+		}
+		if top != nil && req.Cents < minimum {
+			return nil, &ErrBidTooLow{Minimum: minimum, reason: fmt.Sprintf("you must bid at least %s more than the previous high bid of %s", dollars(minimum-top.BidCents), dollars(top.BidCents))}
+		}
+		if rules.MaxJump > 0 {
+			base := item.StartBid
+			if top != nil {
+				base = top.BidCents
+			}
+			if req.Cents-base > rules.MaxJump {
+				return nil, &RejectedError{Reason: fmt.Sprintf("you can't raise the bid by more than %s at once, so you can bid at most %s", dollars(rules.MaxJump), dollars(base+rules.MaxJump))}
+			}
+		}
+		pushBid(req.Cents, req.Bidder, req.DisplayName, false)
+	} else {
+		if top != nil && top.Bidder == req.Bidder {
+			if req.Cents < top.BidCents {
+				return nil, &ErrBidTooLow{Minimum: top.BidCents, reason: "your maximum bid can't be less than your current high bid of " + dollars(top.BidCents)}
+			}
+		} else {
+			minimum := minimumBid(top)
+			if req.Cents < minimum {
+				return nil, &ErrBidTooLow{Minimum: minimum, reason: "your maximum bid must be at least " + dollars(minimum)}
+			}
+		}
+		result.maxBid = &MaxBid{MaxCents: req.Cents, Bidder: req.Bidder, BidderDisplayName: req.DisplayName, SetAt: now}
+		maxBids[req.Bidder] = *result.maxBid
+	}
+
+	// Each round, the strongest maximum bid that isn't already winning outbids
+	// the leader by as little as it can, unless the leader's own maximum is
+	// stronger, in which case the leader's bid goes up just enough to stay
+	// ahead. A maximum that reaches the reserve price bids at least the
+	// reserve, and maximums set before the bid cap was lowered only count up
+	// to the cap.
+	for m := range maxBids {
+		if capped := maxBids[m]; rules.Cap > 0 && capped.MaxCents > rules.Cap {
+			capped.MaxCents = rules.Cap
+			maxBids[m] = capped
+		}
+	}
+	for round := 0; round < 20; round++ {
+		minimum := minimumBid(top)
+		var challenger *MaxBid
+		rival := 0
+		for _, m := range maxBids {
+			m := m
+			if (top == nil || m.Bidder != top.Bidder) && m.MaxCents >= minimum {
+				if challenger == nil || m.MaxCents > challenger.MaxCents || (m.MaxCents == challenger.MaxCents && m.SetAt < challenger.SetAt) {
+					if challenger != nil {
+						rival = maxInt(rival, challenger.MaxCents)
+					}
+					challenger = &m
+				} else {
+					rival = maxInt(rival, m.MaxCents)
+				}
+			}
+		}
+		if challenger == nil {
+			break
+		}
+		var leader *MaxBid
+		if top != nil {
+			if m, ok := maxBids[top.Bidder]; ok {
+				leader = &m
+			}
+		}
+		if leader != nil && (leader.MaxCents > challenger.MaxCents || (leader.MaxCents == challenger.MaxCents && leader.SetAt <= challenger.SetAt)) {
+			// The leader's own maximum holds off the challenger.
+			amount := minInt(leader.MaxCents, maxInt(minimum, challenger.MaxCents+rules.incrementAbove(challenger.MaxCents)))
+			if leader.MaxCents >= item.Reserve {
+				amount = maxInt(amount, item.Reserve)
+			}
+			pushBid(amount, leader.Bidder, leader.BidderDisplayName, true)
+		} else {
+			opponent := rival
+			if top != nil {
+				opponent = maxInt(opponent, top.BidCents)
+			}
+			if leader != nil {
+				opponent = maxInt(opponent, leader.MaxCents)
+			}
+			amount := minInt(challenger.MaxCents, maxInt(minimum, opponent+rules.incrementAbove(opponent)))
+			if challenger.MaxCents >= item.Reserve {
+				amount = maxInt(amount, item.Reserve)
+			}
+			pushBid(amount, challenger.Bidder, challenger.BidderDisplayName, true)
+		}
+	}
+
+	if st.deadline != 0 && len(result.bids) > 0 && item.SoftClose != nil && st.deadline-now <= int64(item.SoftClose.Window)*1000 {
+		extension := int64(item.SoftClose.Extension) * 1000
+		if maxExtension := int64(item.SoftClose.MaxExtension) * 1000; maxExtension > 0 && extension > maxExtension-st.extended {
+			extension = maxExtension - st.extended
+		}
+		if extension > 0 {
+			result.deadline = st.deadline + extension
+			result.extension = extension
+			lastBidID := result.bids[len(result.bids)-1].ID
+			result.events = append(result.events, DeadlineExtendedEvent{ItemID: itemID, Deadline: result.deadline, ExtendedBy: extension, BidID: lastBidID})
+		}
+	}
+	return result, nil
+}
+
+// buyNow decides whether an open item can be bought now, and returns the bid
+// that buys it.
+func buyNow(item Item, top *Bid, req BidRequest, deadline int64, cutoff float64) (Bid, error) {
+	if deadline != 0 && deadline <= unixMillis(req.Now) {
+		return Bid{}, ErrItemClosed
+	}
+	if item.State == StatePaused {
+		return Bid{}, ErrItemPaused
+	}
+	if item.BuyNow <= 0 {
+		return Bid{}, &RejectedError{Reason: "this item can't be bought now"}
+	}
+	if top != nil && float64(top.BidCents) > float64(item.BuyNow)*cutoff {
+		return Bid{}, &RejectedError{Reason: "bidding has gone too high to buy this item now"}
+	}
+	return Bid{BidCents: item.BuyNow, Bidder: req.Bidder, BidderDisplayName: req.DisplayName, ID: req.BidID, ItemID: item.ID, BuyNow: true}, nil
+}
+
+// revealSealedBids returns the sealed bids in the order they go on top of the
+// item's bids: lowest first, so that the winner ends up on top at the price
+// they pay.
+func revealSealedBids(item Item, sealed []sealedBid) []Bid {
+	if len(sealed) == 0 {
+		return nil
+	}
+	sort.Slice(sealed, func(i, j int) bool {
+		if sealed[i].BidCents != sealed[j].BidCents {
+			return sealed[i].BidCents < sealed[j].BidCents
+		}
+		return sealed[i].PlacedAt > sealed[j].PlacedAt
+	})
+	bids := make([]Bid, 0, len(sealed))
+	for _, bid := range sealed {
+		bids = append(bids, bid.Bid)
+	}
+	winner := &bids[len(bids)-1]
+	price := winner.BidCents
+	if item.Pricing == PricingSecond {
+		if len(bids) > 1 {
+			price = bids[len(bids)-2].BidCents
+		} else {
+			price = item.StartBid
+		}
+		// A winner who bid at least the reserve pays no less than it.
+		if winner.BidCents >= item.Reserve && price < item.Reserve {
+			price = item.Reserve
+		}
+	}
+	winner.SealedBidCents = winner.BidCents
+	winner.BidCents = price
+	return bids
+}
+
+// outcome decides how bidding on an item ended, given its high bid.
+func outcome(item Item, top *Bid) string {
+	if top == nil {
+		return OutcomeUnsold
+	}
+	if top.BidCents >= item.Reserve {
+		return OutcomeSold
+	}
+	return OutcomeReserveNotMet
+}
+
+// dropPrice works out a dutch auction item's price once it has dropped as many
+// times as it should have by now, catching up on any drops that were missed
+// while nothing was running. The next drop is zero once the price reaches the
+// floor.
+func dropPrice(schedule DutchSchedule, price int, nextDrop int64, now int64) (int, int64) {
+	interval := int64(schedule.Interval) * 1000
+	steps := (now-nextDrop)/interval + 1
+	price = maxInt(schedule.Floor, price-int(steps)*schedule.Step)
+	nextDrop += steps * interval
+	if price <= schedule.Floor {
+		nextDrop = 0
+	}
+	return price, nextDrop
+}
@@ -0,0 +1,47 @@
+package auction
+
+import (
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// DefaultBuyNowCutoff is the fraction of an item's buy-now price that bidding
+// can reach before the buy-now offer is withdrawn.
+const DefaultBuyNowCutoff = 0.75
+
+// SetBuyNowCutoff sets the fraction of an item's buy-now price that bidding
+// can reach before the buy-now offer is withdrawn. It must be between 0 and 1.
+func (a *Auction) SetBuyNowCutoff(cutoff float64) error {
+	if cutoff <= 0 || cutoff > 1 {
+		return errors.New("the buy-now cutoff must be more than 0 and at most 1")
+	}
+	a.buyNowCutoff = cutoff
+	return nil
+}
+
+// BuyNow buys an open item at its buy-now price, closing it. This fails once
+// the high bid has passed the buy-now cutoff.
+func (a *Auction) BuyNow(itemID string, bidder string, displayName string) error {
+	return a.store.BuyNow(BidRequest{
+		ItemID: itemID,
+		Bidder: bidder,
+		DisplayName: displayName,
+		BidID: uuid.New().String(),
+		Now: time.Now(),
+	}, a.buyNowCutoff)
+}
+
+// BuyNowPrice returns the item's buy-now price, and whether it is still on
+// offer.
+func (a *Auction) BuyNowPrice(item *Item) (int, bool) {
+	if item.BuyNow == 0 || !a.IsOpen(item.ID) {
+		return item.BuyNow, false
+	}
+	bids, err := a.GetTopBids(item.ID, 1)
+	if err != nil {
+		return item.BuyNow, false
+	}
+	return item.BuyNow, len(bids) == 0 || float64(bids[0].BidCents) <= float64(item.BuyNow)*a.buyNowCutoff
+}
@@ -0,0 +1,87 @@
+package auction
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+)
+
+// schedulerInterval is how often RunScheduler checks for scheduled work.
+const schedulerInterval = time.Second
+
+func unixMillis(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Millisecond)
+}
+
+func fromUnixMillis(ms int64) time.Time {
+	return time.Unix(0, ms*int64(time.Millisecond))
+}
+
+// Deadline returns the time at which bidding on the item will automatically
+// close, and whether there is one at all.
+func (a *Auction) Deadline(itemID string) (time.Time, bool) {
+	return a.store.Deadline(itemID)
+}
+
+// SetDeadline sets or replaces the deadline on an open item.
+func (a *Auction) SetDeadline(itemID string, deadline time.Time) error {
+	return a.store.SetDeadline(itemID, deadline)
+}
+
+// ExtendDeadline pushes back the deadline on an open item that already has one,
+// returning the new deadline.
+func (a *Auction) ExtendDeadline(itemID string, by time.Duration) (time.Time, error) {
+	if by <= 0 {
+		return time.Time{}, errors.New("deadlines can only be extended by a positive duration")
+	}
+	return a.store.ExtendDeadline(itemID, by)
+}
+
+// CancelDeadline removes the deadline from an open item, so it stays open until
+// it is explicitly closed.
+func (a *Auction) CancelDeadline(itemID string) error {
+	return a.store.CancelDeadline(itemID)
+}
+
+// RunScheduler closes items whose deadlines have passed, drops the prices of
+// dutch auction items, opens items at their scheduled times, and opens the
+// next item in the run queue if auto-advance is on. It never returns.
+//
+// The schedules are kept in the store, so with a shared store it's safe to run
+// this in several processes at once, and anything that falls due while nothing
+// is running is handled as soon as it starts. Auto-advance is the exception: it
+// is timed by the process, so an item that closes while nothing is running
+// isn't followed automatically.
+func (a *Auction) RunScheduler() {
+	ticker := time.NewTicker(schedulerInterval)
+	defer ticker.Stop()
+	var adv advancer
+	for now := range ticker.C {
+		if err := a.closeExpiredItems(now); err != nil {
+			log.Printf("Closing expired items failed: %v.\n", err)
+		}
+		if err := a.dropPrices(now); err != nil {
+			log.Printf("Dropping prices failed: %v.\n", err)
+		}
+		if err := a.openScheduledItems(now); err != nil {
+			log.Printf("Opening scheduled items failed: %v.\n", err)
+		}
+		if err := a.advance(&adv, now); err != nil {
+			log.Printf("Opening the next item failed: %v.\n", err)
+		}
+	}
+}
+
+func (a *Auction) closeExpiredItems(now time.Time) error {
+	expired, err := a.store.ExpiredItems(now)
+	if err != nil {
+		return fmt.Errorf("couldn't look up deadlines: %v", err)
+	}
+	for _, itemID := range expired {
+		if _, err := a.store.CloseItem(itemID, now); err != nil && err != ErrItemClosed {
+			log.Printf("Couldn't close expired item %q: %v.\n", itemID, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,56 @@
+package auction
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+)
+
+// DutchSchedule sets how the price of a dutch auction item falls: it starts at
+// StartPrice, and drops by Step every Interval seconds until it reaches Floor.
+// Prices are in cents.
+type DutchSchedule struct {
+	StartPrice int `json:"startPrice"`
+	Step int `json:"step"`
+	Interval int `json:"interval"`
+	Floor int `json:"floor"`
+}
+
+func (d *DutchSchedule) validate() error {
+	if d.StartPrice <= 0 || d.Step <= 0 || d.Interval <= 0 {
+		return errors.New("dutch auction start prices, steps and intervals must be positive")
+	}
+	if d.Floor < 0 || d.Floor > d.StartPrice {
+		return errors.New("the dutch auction floor must be between zero and the start price")
+	}
+	return nil
+}
+
+// Take claims an open dutch auction item at its current price, and closes it.
+func (a *Auction) Take(itemID string, bidder string, displayName string) error {
+	return a.runBidScript("take", itemID, 0, bidder, displayName)
+}
+
+// errNoPrice is returned by stores when asked for the price of an item that
+// isn't an open dutch auction item.
+var errNoPrice = errors.New("that item has no current price")
+
+// CurrentPrice returns the current price of an open dutch auction item, and
+// when it will next drop. The time is zero if the price won't drop again.
+func (a *Auction) CurrentPrice(itemID string) (int, time.Time, error) {
+	return a.store.CurrentPrice(itemID)
+}
+
+func (a *Auction) dropPrices(now time.Time) error {
+	due, err := a.store.DuePriceDrops(now)
+	if err != nil {
+		return fmt.Errorf("couldn't look up price drops: %v", err)
+	}
+	for _, itemID := range due {
+		if err := a.store.DropPrice(itemID, now); err != nil {
+			log.Printf("Couldn't drop the price of %q: %v.\n", itemID, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,116 @@
+package auction
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/go-redis/redis/v7"
+)
+
+var (
+	// ErrNoCurrentItem is returned when an operation needs the current item,
+	// but nothing is up for auction.
+	ErrNoCurrentItem = errors.New("nothing is up for auction right now")
+	// ErrItemNotFound is returned when an item doesn't exist.
+	ErrItemNotFound = errors.New("no such item exists")
+	// ErrBidNotFound is returned when a bid doesn't exist.
+	ErrBidNotFound = errors.New("no such bid exists")
+	// ErrItemClosed is returned when an item has to be open for bidding, but
+	// isn't.
+	ErrItemClosed = errors.New("that item isn't open for bidding")
+	// ErrItemPaused is returned when a bid is placed on an item that is paused.
+	ErrItemPaused = errors.New("bidding is paused")
+	// ErrQueueEmpty is returned when the next item is wanted from the run
+	// queue, but there is nothing in it.
+	ErrQueueEmpty = errors.New("there are no items in the queue")
+	// ErrAuctionArchived is returned when something would change an auction
+	// that has been archived.
+	ErrAuctionArchived = errors.New("the auction has been archived")
+	// ErrBadEventID is returned when an event ID isn't one the event log could
+	// have produced.
+	ErrBadEventID = errors.New("that isn't a valid event ID")
+)
+
+// ErrBidTooLow is returned when a bid, or a maximum bid, is less than the
+// auction will accept.
+type ErrBidTooLow struct {
+	// Minimum is the lowest amount that would have been accepted, in cents.
+	Minimum int
+	reason string
+}
+
+func (e *ErrBidTooLow) Error() string {
+	if e.reason != "" {
+		return e.reason
+	}
+	return fmt.Sprintf("you must bid at least $%d.%02d", e.Minimum/100, e.Minimum%100)
+}
+
+// RejectedError is returned when the auction refuses a request for a reason
+// that has no more specific error, such as a bid breaking the bid rules. Its
+// message is meant to be shown to whoever made the request.
+type RejectedError struct {
+	Reason string
+}
+
+func (e *RejectedError) Error() string {
+	return e.Reason
+}
+
+// Lua scripts report errors as redis does, with an error code as the first
+// word of the reply:
+//
+//	NOITEM <message>
+//	NOBID <message>
+//	CLOSED <message>
+//	PAUSED <message>
+//	EMPTY <message>
+//	TOOLOW <minimum cents> <message>
+//	REJECTED <message>
+//	STATE <from> <to>
+//	ARCHIVED <message>
+//
+// scriptError turns these into the errors above. Anything else is returned
+// unchanged.
+func scriptError(err error) error {
+	if _, ok := err.(redis.Error); !ok || err == redis.Nil {
+		return err
+	}
+	parts := strings.SplitN(err.Error(), " ", 2)
+	message := ""
+	if len(parts) == 2 {
+		message = parts[1]
+	}
+	switch parts[0] {
+	case "NOITEM":
+		return ErrItemNotFound
+	case "NOBID":
+		return ErrBidNotFound
+	case "CLOSED":
+		return ErrItemClosed
+	case "TOOLOW":
+		details := strings.SplitN(message, " ", 2)
+		minimum, convErr := strconv.Atoi(details[0])
+		if convErr != nil || len(details) != 2 {
+			return err
+		}
+		return &ErrBidTooLow{Minimum: minimum, reason: details[1]}
+	case "PAUSED":
+		return ErrItemPaused
+	case "EMPTY":
+		return ErrQueueEmpty
+	case "REJECTED":
+		return &RejectedError{Reason: message}
+	case "ARCHIVED":
+		return ErrAuctionArchived
+	case "STATE":
+		states := strings.SplitN(message, " ", 2)
+		if len(states) != 2 {
+			return err
+		}
+		return &TransitionError{From: states[0], To: states[1]}
+	}
+	return err
+}
@@ -1,9 +1,96 @@
 package auction
 
+import (
+	"encoding/json"
+)
+
 type Event interface {
 	Event() string
 }
 
+// eventJSON encodes an event in the form it is published in, with its name in
+// the "event" field.
+func eventJSON(e Event) (string, error) {
+	j, err := json.Marshal(e)
+	if err != nil {
+		return "", err
+	}
+	var fields map[string]interface{}
+	if err := json.Unmarshal(j, &fields); err != nil {
+		return "", err
+	}
+	fields["event"] = e.Event()
+	j, err = json.Marshal(fields)
+	if err != nil {
+		return "", err
+	}
+	return string(j), nil
+}
+
+// ConnectionLostEvent is sent to subscribers when the subscription to the
+// store's events fails. Events carry on once a ConnectionRestoredEvent is sent,
+// starting with any that were missed. It isn't logged, and has no ID.
+type ConnectionLostEvent struct{}
+
+func (ConnectionLostEvent) Event() string {
+	return "connectionLost"
+}
+
+// ConnectionRestoredEvent is sent to subscribers when the subscription to the
+// store's events has been restored. Downtime is in milliseconds. Since the
+// state of the auction may have changed in the meantime, subscribers that keep
+// any should read it again. It isn't logged, and has no ID.
+type ConnectionRestoredEvent struct {
+	Downtime int64 `json:"downtime"`
+}
+
+func (ConnectionRestoredEvent) Event() string {
+	return "connectionRestored"
+}
+
+// LoggedEvent is an event from the event log, with the ID it was logged under.
+// Events that aren't logged have no ID.
+type LoggedEvent struct {
+	ID     string
+	ItemID string
+	Event  Event
+}
+
+// MarshalJSON encodes the event in the same form as the event stream, with its
+// log ID alongside it.
+func (e LoggedEvent) MarshalJSON() ([]byte, error) {
+	fields := map[string]interface{}{"type": e.Event.Event(), "event": e.Event}
+	if e.ID != "" {
+		fields["id"] = e.ID
+	}
+	return json.Marshal(fields)
+}
+
+// decodeLogEntry decodes an event from the event log. Events it doesn't know
+// are ignored, and decoded as nil.
+func decodeLogEntry(entry LogEntry) (*LoggedEvent, error) {
+	what, err := decodeEvent(entry.Payload)
+	if err != nil || what == nil {
+		return nil, err
+	}
+	return &LoggedEvent{ID: entry.ID, ItemID: entry.ItemID, Event: what}, nil
+}
+
+// newLogEntry encodes an event for the event log. The entry has no ID yet.
+func newLogEntry(e Event) (LogEntry, error) {
+	payload, err := eventJSON(e)
+	if err != nil {
+		return LogEntry{}, err
+	}
+	var about struct {
+		ItemID string `json:"itemId"`
+	}
+	if err := json.Unmarshal([]byte(payload), &about); err != nil {
+		return LogEntry{}, err
+	}
+	return LogEntry{ItemID: about.ItemID, Payload: payload}, nil
+}
+
 type genericEvent struct {
 	EventName string `json:"event"`
 }
@@ -14,21 +101,35 @@ func (e genericEvent) Event() string {
 
 type CloseItemEvent struct {
 	ItemID string `json:"itemId"`
+	Outcome string `json:"outcome,omitempty"`
+	// BuyNow is set if the item closed because it was bought at its buy-now
+	// price.
+	BuyNow bool `json:"buyNow,omitempty"`
 }
 
 func (CloseItemEvent) Event() string {
 	return "closeItem"
 }
 
+// Deadlines in events are in milliseconds since the Unix epoch, so that they
+// can be produced by scripts running inside redis. Zero means no deadline.
+
 type OpenItemEvent struct {
 	ItemID string `json:"itemId"`
+	Deadline int64 `json:"deadline,omitempty"`
+	Silent bool `json:"silent,omitempty"`
 }
 
 func (OpenItemEvent) Event() string {
 	return "openItem"
 }
 
-type BidEvent Bid
+// BidEvent is sent when a bid is placed. ReserveMet is only set if the item
+// has a reserve price.
+type BidEvent struct {
+	Bid
+	ReserveMet *bool `json:"reserveMet,omitempty"`
+}
 
 func (BidEvent) Event() string {
 	return "bid"
@@ -44,4 +145,168 @@ type DeleteBidEvent struct {
 
 func (DeleteBidEvent) Event() string {
 	return "deleteBid"
-}
\ No newline at end of file
+}
+
+// DeadlineChangedEvent is sent when an item's deadline is set, extended or
+// cleared by hand. Deadline is in milliseconds since the epoch, or 0 if the item
+// no longer has one.
+type DeadlineChangedEvent struct {
+	ItemID string `json:"itemId"`
+	Deadline int64 `json:"deadline"`
+}
+
+func (DeadlineChangedEvent) Event() string {
+	return "deadlineChanged"
+}
+
+// DeadlineExtendedEvent is sent when a late bid pushes back an item's deadline.
+// ExtendedBy is in milliseconds.
+type DeadlineExtendedEvent struct {
+	ItemID string `json:"itemId"`
+	Deadline int64 `json:"deadline"`
+	ExtendedBy int64 `json:"extendedBy"`
+	BidID string `json:"bidId"`
+}
+
+func (DeadlineExtendedEvent) Event() string {
+	return "deadlineExtended"
+}
+
+// SealedBidEvent is sent when a bid is placed on a sealed-bid item. It doesn't
+// say who bid or how much.
+type SealedBidEvent struct {
+	ItemID string `json:"itemId"`
+	BidCount int `json:"bidCount"`
+}
+
+func (SealedBidEvent) Event() string {
+	return "sealedBid"
+}
+
+// PriceDroppedEvent is sent when the price of a dutch auction item drops.
+// NextDrop is when it will drop again, or zero if it has reached its floor.
+type PriceDroppedEvent struct {
+	ItemID string `json:"itemId"`
+	PriceCents int `json:"price"`
+	NextDrop int64 `json:"nextDrop"`
+}
+
+func (PriceDroppedEvent) Event() string {
+	return "priceDropped"
+}
+
+// PauseItemEvent is sent when bidding on an item is paused. Remaining is how
+// long was left until its deadline, in milliseconds, which stays the same until
+// it is resumed. It is zero if the item has no deadline.
+type PauseItemEvent struct {
+	ItemID string `json:"itemId"`
+	Remaining int64 `json:"remaining,omitempty"`
+}
+
+func (PauseItemEvent) Event() string {
+	return "pauseItem"
+}
+
+// ResumeItemEvent is sent when bidding on a paused item resumes. Deadline is
+// the item's new deadline, if it has one.
+type ResumeItemEvent struct {
+	ItemID string `json:"itemId"`
+	Deadline int64 `json:"deadline,omitempty"`
+}
+
+func (ResumeItemEvent) Event() string {
+	return "resumeItem"
+}
+
+// ItemScheduledEvent is sent when an item is scheduled to open at OpensAt, in
+// milliseconds since the Unix epoch, or when its opening is cancelled, in which
+// case OpensAt is zero. It is sent again as a Reminder shortly before the item
+// opens, to preview what is coming up.
+type ItemScheduledEvent struct {
+	ItemID string `json:"itemId"`
+	OpensAt int64 `json:"opensAt,omitempty"`
+	Reminder bool `json:"reminder,omitempty"`
+}
+
+func (ItemScheduledEvent) Event() string {
+	return "scheduled"
+}
+
+// AuctionArchivedEvent is sent when the auction is archived, after which
+// nothing about it can change.
+type AuctionArchivedEvent struct{}
+
+func (AuctionArchivedEvent) Event() string {
+	return "archived"
+}
+
+// QueueChangedEvent is sent whenever the run queue changes. ItemID is the item
+// that was added, moved or taken off it, and Queue is the whole queue
+// afterwards, next first.
+type QueueChangedEvent struct {
+	ItemID string `json:"itemId"`
+	Queue []string `json:"queue,omitempty"`
+}
+
+func (QueueChangedEvent) Event() string {
+	return "queueChanged"
+}
+
+// ItemStateChangedEvent is sent whenever an item moves from one state to
+// another, alongside any other event about the change, such as an
+// OpenItemEvent.
+type ItemStateChangedEvent struct {
+	ItemID string `json:"itemId"`
+	From string `json:"from"`
+	To string `json:"to"`
+}
+
+func (ItemStateChangedEvent) Event() string {
+	return "stateChanged"
+}
+
+// decodeEvent decodes a published event. Events it doesn't know are ignored, and
+// decoded as nil.
+func decodeEvent(payload string) (Event, error) {
+	var e genericEvent
+	if err := json.Unmarshal([]byte(payload), &e); err != nil {
+		return nil, err
+	}
+	var what Event
+	switch e.Event() {
+	case "openItem":
+		what = &OpenItemEvent{}
+	case "closeItem":
+		what = &CloseItemEvent{}
+	case "bid":
+		what = &BidEvent{}
+	case "deleteBid":
+		what = &DeleteBidEvent{}
+	case "deadlineChanged":
+		what = &DeadlineChangedEvent{}
+	case "deadlineExtended":
+		what = &DeadlineExtendedEvent{}
+	case "sealedBid":
+		what = &SealedBidEvent{}
+	case "priceDropped":
+		what = &PriceDroppedEvent{}
+	case "pauseItem":
+		what = &PauseItemEvent{}
+	case "resumeItem":
+		what = &ResumeItemEvent{}
+	case "stateChanged":
+		what = &ItemStateChangedEvent{}
+	case "queueChanged":
+		what = &QueueChangedEvent{}
+	case "scheduled":
+		what = &ItemScheduledEvent{}
+	case "archived":
+		what = &AuctionArchivedEvent{}
+	default:
+		return nil, nil
+	}
+	if err := json.Unmarshal([]byte(payload), what); err != nil {
+		return nil, err
+	}
+	return what, nil
+}
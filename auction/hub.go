@@ -0,0 +1,345 @@
+package auction
+
+import (
+	"context"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultEventBuffer is how many events a subscriber can fall behind by before
+// it is dropped.
+const DefaultEventBuffer = 256
+
+// How long the hub waits between attempts to restore a failed store
+// subscription. The delay doubles after every failed attempt.
+const (
+	minReconnectDelay = 500 * time.Millisecond
+	maxReconnectDelay = 30 * time.Second
+)
+
+// EventStats describes the subscribers to an auction's events.
+type EventStats struct {
+	// Subscribers is how many subscribers there are right now.
+	Subscribers int `json:"subscribers"`
+	// Dropped is how many subscribers have been dropped for falling too far
+	// behind.
+	Dropped int64 `json:"dropped"`
+}
+
+// eventHub shares a single subscription to the store's events between every
+// subscriber in the process. Each subscriber has a bounded buffer, and one
+// that lets it fill up is dropped, by closing its channel, rather than holding
+// up everyone else. A dropped subscriber can resume from the last event it
+// received.
+//
+// If the store subscription fails, the hub tells subscribers with a
+// ConnectionLostEvent and keeps trying to resubscribe from the last event it
+// broadcast, so that nothing is missed once it sends a ConnectionRestoredEvent.
+type eventHub struct {
+	store  Store
+	buffer int
+
+	mu          sync.Mutex
+	subscribers map[*hubSubscriber]bool
+	// unsubscribe stops the store subscription, which is made when the first
+	// subscriber arrives.
+	unsubscribe func()
+	// stopped is closed when the store subscription is stopped on purpose,
+	// rather than failing.
+	stopped chan struct{}
+	// last is the ID of the last event that was broadcast, or that was logged
+	// before the store subscription began.
+	last    string
+	dropped int64
+}
+
+type hubSubscriber struct {
+	ch chan LoggedEvent
+	// skipUntil is set when a subscriber resumes from an event the hub hasn't
+	// broadcast yet, and it skips events until the ones after that.
+	skipUntil string
+	gone      chan struct{}
+}
+
+func newEventHub(store Store) *eventHub {
+	return &eventHub{
+		store:       store,
+		buffer:      DefaultEventBuffer,
+		subscribers: map[*hubSubscriber]bool{},
+	}
+}
+
+// subscribe returns a channel of the events logged after the given ID, or of
+// new events if it is empty. The channel is closed when ctx is done, when the
+// subscriber falls too far behind, or when the hub is closed. A subscriber
+// that resumes from more than a buffer's worth of events ago is sent a
+// buffer's worth, and its channel is closed so that it resumes again from
+// there.
+func (h *eventHub) subscribe(ctx context.Context, after string) (<-chan LoggedEvent, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if err := h.start(); err != nil {
+		return nil, err
+	}
+	sub := &hubSubscriber{gone: make(chan struct{})}
+	var replay []LoggedEvent
+	if after != "" && after != h.last {
+		entries, err := h.store.EventLog(after, "", h.buffer)
+		if err != nil {
+			return nil, err
+		}
+		// The log may already have events the hub hasn't broadcast yet,
+		// which the subscriber will get along with everyone else. If the
+		// hub hasn't broadcast anything, none of them have been.
+		var caughtUp bool
+		for _, entry := range entries {
+			if caughtUp {
+				break
+			}
+			caughtUp = entry.ID == h.last
+			e, err := decodeLogEntry(entry)
+			if err != nil {
+				log.Printf("Couldn't unmarshal event %s: %v.\n", entry.ID, err)
+				continue
+			}
+			if e != nil {
+				replay = append(replay, *e)
+			}
+		}
+		switch {
+		case caughtUp:
+		case len(entries) == h.buffer:
+			// The subscriber is too far behind to catch up in one go.
+			ch := make(chan LoggedEvent, len(replay))
+			for _, e := range replay {
+				ch <- e
+			}
+			close(ch)
+			return ch, nil
+		default:
+			// The subscriber is ahead of the hub, or resuming from an event
+			// that was never logged.
+			replay = nil
+			last, err := h.store.LastEventID()
+			if err != nil {
+				return nil, err
+			}
+			if compareEventIDs(after, last) > 0 {
+				log.Printf("Event %s is later than any in the log, which may have been reset. Sending new events only.\n", after)
+			} else {
+				sub.skipUntil = after
+			}
+		}
+	}
+	sub.ch = make(chan LoggedEvent, h.buffer+len(replay))
+	for _, e := range replay {
+		sub.ch <- e
+	}
+	h.subscribers[sub] = true
+	go func() {
+		select {
+		case <-ctx.Done():
+			h.mu.Lock()
+			h.remove(sub)
+			h.mu.Unlock()
+		case <-sub.gone:
+		}
+	}()
+	return sub.ch, nil
+}
+
+// start subscribes to the store if the hub isn't subscribed already. It must
+// be called with h.mu held.
+func (h *eventHub) start() error {
+	if h.unsubscribe != nil {
+		return nil
+	}
+	last, err := h.store.LastEventID()
+	if err != nil {
+		return err
+	}
+	from := last
+	if from == "" {
+		from = "0"
+	}
+	ch, unsubscribe, err := h.store.Subscribe(from)
+	if err != nil {
+		return err
+	}
+	h.last = last
+	h.unsubscribe = unsubscribe
+	h.stopped = make(chan struct{})
+	go h.run(ch, h.stopped)
+	return nil
+}
+
+func (h *eventHub) run(ch <-chan LogEntry, stopped chan struct{}) {
+	for {
+		for entry := range ch {
+			e, err := decodeLogEntry(entry)
+			if err != nil {
+				log.Printf("Couldn't unmarshal event %s: %v.\n", entry.ID, err)
+			}
+			h.mu.Lock()
+			if isClosed(stopped) {
+				h.mu.Unlock()
+				return
+			}
+			h.last = entry.ID
+			if e != nil {
+				h.broadcast(*e)
+			}
+			h.mu.Unlock()
+		}
+		var ok bool
+		if ch, ok = h.reconnect(stopped); !ok {
+			return
+		}
+	}
+}
+
+// reconnect restores a store subscription that failed, unless it was stopped
+// on purpose, in which case it reports false.
+func (h *eventHub) reconnect(stopped chan struct{}) (<-chan LogEntry, bool) {
+	h.mu.Lock()
+	if isClosed(stopped) {
+		h.mu.Unlock()
+		return nil, false
+	}
+	log.Printf("Lost the subscription to auction events. Reconnecting.\n")
+	h.broadcast(LoggedEvent{Event: &ConnectionLostEvent{}})
+	h.mu.Unlock()
+	lost := time.Now()
+	delay := minReconnectDelay
+	for {
+		select {
+		case <-time.After(delay):
+		case <-stopped:
+			return nil, false
+		}
+		// Check that the store is reachable before holding the lock, in case
+		// it takes a while to find out that it isn't.
+		_, err := h.store.LastEventID()
+		if err == nil {
+			h.mu.Lock()
+			if isClosed(stopped) {
+				h.mu.Unlock()
+				return nil, false
+			}
+			from := h.last
+			if from == "" {
+				from = "0"
+			}
+			var ch <-chan LogEntry
+			var unsubscribe func()
+			if ch, unsubscribe, err = h.store.Subscribe(from); err == nil {
+				h.unsubscribe()
+				h.unsubscribe = unsubscribe
+				downtime := time.Since(lost)
+				log.Printf("Restored the subscription to auction events after %v.\n", downtime.Round(time.Millisecond))
+				h.broadcast(LoggedEvent{Event: &ConnectionRestoredEvent{Downtime: int64(downtime / time.Millisecond)}})
+				h.mu.Unlock()
+				return ch, true
+			}
+			h.mu.Unlock()
+		}
+		if delay *= 2; delay > maxReconnectDelay {
+			delay = maxReconnectDelay
+		}
+		log.Printf("Reconnecting to auction events failed: %v. Trying again in %v.\n", err, delay)
+	}
+}
+
+func isClosed(ch chan struct{}) bool {
+	select {
+	case <-ch:
+		return true
+	default:
+		return false
+	}
+}
+
+// broadcast must be called with h.mu held.
+func (h *eventHub) broadcast(e LoggedEvent) {
+	for sub := range h.subscribers {
+		if sub.skipUntil != "" {
+			if e.ID == "" || compareEventIDs(e.ID, sub.skipUntil) <= 0 {
+				continue
+			}
+			sub.skipUntil = ""
+		}
+		select {
+		case sub.ch <- e:
+		default:
+			log.Printf("Dropping an event subscriber that is more than %d events behind.\n", h.buffer)
+			h.dropped++
+			h.remove(sub)
+		}
+	}
+}
+
+// compareEventIDs compares two event IDs in the order they were logged,
+// returning -1, 0 or 1. IDs are compared as runs of numbers separated by dashes,
+// which covers both redis stream IDs and the sequence numbers the other
+// stores use.
+func compareEventIDs(a, b string) int {
+	aParts, bParts := strings.Split(a, "-"), strings.Split(b, "-")
+	for i := 0; i < len(aParts) && i < len(bParts); i++ {
+		x, xErr := strconv.ParseUint(aParts[i], 10, 64)
+		y, yErr := strconv.ParseUint(bParts[i], 10, 64)
+		if xErr != nil || yErr != nil {
+			return strings.Compare(a, b)
+		}
+		if x != y {
+			if x < y {
+				return -1
+			}
+			return 1
+		}
+	}
+	switch {
+	case len(aParts) < len(bParts):
+		return -1
+	case len(aParts) > len(bParts):
+		return 1
+	}
+	return 0
+}
+
+// remove must be called with h.mu held.
+func (h *eventHub) remove(sub *hubSubscriber) {
+	if !h.subscribers[sub] {
+		return
+	}
+	delete(h.subscribers, sub)
+	close(sub.ch)
+	close(sub.gone)
+}
+
+// stop ends every subscription, and the store subscription. It must be
+// called with h.mu held.
+func (h *eventHub) stop() {
+	for sub := range h.subscribers {
+		h.remove(sub)
+	}
+	if h.unsubscribe != nil {
+		h.unsubscribe()
+		h.unsubscribe = nil
+		close(h.stopped)
+	}
+}
+
+func (h *eventHub) close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.stop()
+}
+
+func (h *eventHub) stats() EventStats {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return EventStats{Subscribers: len(h.subscribers), Dropped: h.dropped}
+}
@@ -0,0 +1,198 @@
+package auction
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+// hubStore is a memory store whose subscription is fed by hand, so that tests
+// can choose when the hub broadcasts each event that has been logged.
+type hubStore struct {
+	*memoryStore
+	entries chan LogEntry
+	once    sync.Once
+}
+
+func newHubStore() *hubStore {
+	return &hubStore{
+		memoryStore: NewMemoryStore().(*memoryStore),
+		entries:     make(chan LogEntry, 100),
+	}
+}
+
+func (s *hubStore) Subscribe(after string) (<-chan LogEntry, func(), error) {
+	return s.entries, func() { s.once.Do(func() { close(s.entries) }) }, nil
+}
+
+// logEvents logs n events without broadcasting them, and returns their log
+// entries.
+func (s *hubStore) logEvents(t *testing.T, n int) []LogEntry {
+	t.Helper()
+	last, err := s.LastEventID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.mu.Lock()
+	for i := 0; i < n; i++ {
+		s.publish(&PauseItemEvent{ItemID: "plush"})
+	}
+	s.mu.Unlock()
+	entries, err := s.EventLog(last, "", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return entries
+}
+
+// broadcast has the hub broadcast the given entries.
+func (s *hubStore) broadcast(entries ...LogEntry) {
+	for _, entry := range entries {
+		s.entries <- entry
+	}
+}
+
+// expectEvents checks that the next events on ch are the given entries.
+func expectEvents(t *testing.T, ch <-chan LoggedEvent, want ...LogEntry) {
+	t.Helper()
+	for _, entry := range want {
+		select {
+		case e, ok := <-ch:
+			if !ok {
+				t.Fatalf("channel closed, want event %s", entry.ID)
+			}
+			if e.ID != entry.ID {
+				t.Fatalf("got event %s, want %s", e.ID, entry.ID)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("timed out waiting for event %s", entry.ID)
+		}
+	}
+}
+
+// expectClosed checks that ch is closed once any events left on it are read.
+func expectClosed(t *testing.T, ch <-chan LoggedEvent) {
+	t.Helper()
+	select {
+	case e, ok := <-ch:
+		if ok {
+			t.Fatalf("got event %s, want the channel closed", e.ID)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for the channel to close")
+	}
+}
+
+func subscribeHub(t *testing.T, h *eventHub, after string) <-chan LoggedEvent {
+	t.Helper()
+	ch, err := h.subscribe(context.Background(), after)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ch
+}
+
+func TestHubResumeFromBroadcastEvent(t *testing.T) {
+	s := newHubStore()
+	h := newEventHub(s)
+	defer h.close()
+	live := subscribeHub(t, h, "")
+	sent := s.logEvents(t, 3)
+	s.broadcast(sent...)
+	expectEvents(t, live, sent...)
+
+	// The events the hub has already broadcast come from the log.
+	resumed := subscribeHub(t, h, sent[0].ID)
+	expectEvents(t, resumed, sent[1:]...)
+	next := s.logEvents(t, 1)
+	s.broadcast(next...)
+	expectEvents(t, live, next...)
+	expectEvents(t, resumed, next...)
+}
+
+func TestHubResumeFromEventNotBroadcast(t *testing.T) {
+	for _, broadcast := range []int{0, 1} {
+		s := newHubStore()
+		h := newEventHub(s)
+		live := subscribeHub(t, h, "")
+		sent := s.logEvents(t, broadcast)
+		s.broadcast(sent...)
+		expectEvents(t, live, sent...)
+
+		// The subscriber has an event the hub hasn't got to yet, so it
+		// skips the events up to that one as the hub broadcasts them.
+		pending := s.logEvents(t, 3)
+		resumed := subscribeHub(t, h, pending[1].ID)
+		s.broadcast(pending...)
+		expectEvents(t, live, pending...)
+		expectEvents(t, resumed, pending[2])
+		h.close()
+	}
+}
+
+func TestHubResumeFromFarBehind(t *testing.T) {
+	s := newHubStore()
+	h := newEventHub(s)
+	h.buffer = 3
+	defer h.close()
+	live := subscribeHub(t, h, "")
+	sent := s.logEvents(t, 5)
+	for _, entry := range sent {
+		s.broadcast(entry)
+		expectEvents(t, live, entry)
+	}
+
+	// A subscriber more than a buffer's worth behind gets that much, and has
+	// to resume again from there.
+	resumed := subscribeHub(t, h, sent[0].ID)
+	expectEvents(t, resumed, sent[1:4]...)
+	expectClosed(t, resumed)
+	resumed = subscribeHub(t, h, sent[3].ID)
+	expectEvents(t, resumed, sent[4])
+	next := s.logEvents(t, 1)
+	s.broadcast(next...)
+	expectEvents(t, resumed, next...)
+}
+
+func TestHubDropsSlowSubscriber(t *testing.T) {
+	s := newHubStore()
+	h := newEventHub(s)
+	h.buffer = 2
+	defer h.close()
+	live := subscribeHub(t, h, "")
+	slow := subscribeHub(t, h, "")
+	sent := s.logEvents(t, 3)
+	for _, entry := range sent {
+		s.broadcast(entry)
+		expectEvents(t, live, entry)
+	}
+	expectEvents(t, slow, sent[:2]...)
+	expectClosed(t, slow)
+	if stats := h.stats(); stats.Subscribers != 1 || stats.Dropped != 1 {
+		t.Fatalf("got %+v, want one subscriber left and one dropped", stats)
+	}
+}
+
+func TestCompareEventIDs(t *testing.T) {
+	for _, test := range []struct {
+		a, b string
+		want int
+	}{
+		{"1", "1", 0},
+		{"1", "2", -1},
+		{"2", "1", 1},
+		{"9", "10", -1},
+		{"100", "99", 1},
+		{"123-4", "123-4", 0},
+		{"123-4", "123-5", -1},
+		{"123-10", "123-9", 1},
+		{"123-4", "124-0", -1},
+		{"1000-0", "999-99", 1},
+		{"123", "123-0", -1},
+	} {
+		if got := compareEventIDs(test.a, test.b); got != test.want {
+			t.Errorf("compareEventIDs(%q, %q) = %d, want %d", test.a, test.b, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,196 @@
+package auction
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Item states. An item moves between them only along the transitions below,
+// and every move publishes an ItemStateChangedEvent.
+const (
+	// StateDraft items are still being written up, and can't be opened.
+	StateDraft = "draft"
+	// StateApproved items are ready to be auctioned. Items with no state that
+	// aren't open or closed are approved.
+	StateApproved = "approved"
+	// StateScheduled items are waiting their turn in the run queue, or for
+	// the time they are scheduled to open.
+	StateScheduled = "scheduled"
+	StateOpen      = "open"
+	// StatePaused items are still up for auction, but don't take bids.
+	StatePaused       = "paused"
+	StateClosedSold   = "closed-sold"
+	StateClosedUnsold = "closed-unsold"
+	StatePaid         = "paid"
+	StateShipped      = "shipped"
+	// StateVoided items were withdrawn, or their sale was cancelled. A voided
+	// sale doesn't count towards the total raised.
+	StateVoided = "voided"
+)
+
+// transitions lists the states that an item in each state can move to.
+var transitions = map[string][]string{
+	StateDraft:     {StateApproved, StateVoided},
+	StateApproved:  {StateDraft, StateScheduled, StateOpen, StateVoided},
+	StateScheduled: {StateApproved, StateOpen, StateVoided},
+	// Open and paused items go back to being approved if another item replaces
+	// them as the current item before they close.
+	StateOpen:         {StateApproved, StatePaused, StateClosedSold, StateClosedUnsold},
+	StatePaused:       {StateApproved, StateOpen, StateClosedSold, StateClosedUnsold},
+	StateClosedSold:   {StateOpen, StatePaid, StateVoided},
+	StateClosedUnsold: {StateApproved, StateOpen, StateVoided},
+	StatePaid:         {StateShipped, StateVoided},
+	StateShipped:      {},
+	StateVoided:       {},
+}
+
+// manualStates are the states that SetItemState can move an item to. Items are
+// scheduled, opened, paused and closed by the operations that do those things.
+var manualStates = map[string]bool{
+	StateDraft:    true,
+	StateApproved: true,
+	StatePaid:     true,
+	StateShipped:  true,
+	StateVoided:   true,
+}
+
+// countedStates are the states of items whose price counts towards the total
+// raised.
+var countedStates = map[string]bool{
+	StateClosedSold: true,
+	StatePaid:       true,
+	StateShipped:    true,
+}
+
+// stateActions describe moving to each state, for error messages.
+var stateActions = map[string]string{
+	StateDraft:        "made a draft",
+	StateApproved:     "approved",
+	StateScheduled:    "scheduled",
+	StateOpen:         "opened",
+	StatePaused:       "paused",
+	StateClosedSold:   "sold",
+	StateClosedUnsold: "closed unsold",
+	StatePaid:         "marked as paid",
+	StateShipped:      "marked as shipped",
+	StateVoided:       "voided",
+}
+
+// ValidState reports whether state is one of the item states.
+func ValidState(state string) bool {
+	_, ok := transitions[state]
+	return ok
+}
+
+// TransitionError is returned when an item can't move from the state it is in
+// to the one asked for.
+type TransitionError struct {
+	From string
+	To   string
+}
+
+func (e *TransitionError) Error() string {
+	return fmt.Sprintf("an item that is %s can't be %s", e.From, stateActions[e.To])
+}
+
+// checkTransition returns a TransitionError unless an item can move from one
+// state to the other.
+func checkTransition(from, to string) error {
+	for _, next := range transitions[from] {
+		if next == to {
+			return nil
+		}
+	}
+	return &TransitionError{From: from, To: to}
+}
+
+// checkManualTransition is checkTransition for SetItemState, which can't take
+// an item that is up for auction off it.
+func checkManualTransition(from, to string) error {
+	if from == StateOpen || from == StatePaused {
+		return &TransitionError{From: from, To: to}
+	}
+	return checkTransition(from, to)
+}
+
+// statesFrom returns the states that can move to the given one, separated by
+// spaces, for the redis scripts to check against.
+func statesFrom(to string) string {
+	var from []string
+	for state, next := range transitions {
+		for _, n := range next {
+			if n == to {
+				from = append(from, state)
+			}
+		}
+	}
+	return strings.Join(from, " ")
+}
+
+// stateOf returns the item's state. Items stored before items had states are
+// given the state they would have had, which depends on whether they are open.
+func stateOf(item Item, open bool) string {
+	switch {
+	case item.State != "":
+		return item.State
+	case open:
+		return StateOpen
+	case !item.Closed:
+		return StateApproved
+	case item.Outcome == OutcomeSold || item.Outcome == "":
+		// Items closed before outcomes were recorded were always sold.
+		return StateClosedSold
+	default:
+		return StateClosedUnsold
+	}
+}
+
+// setState moves the item to a state, keeping Closed and Outcome in step with
+// it. Closing an item unsold leaves the outcome to the caller, since there is
+// more than one way not to sell.
+func setState(item *Item, state string) {
+	item.State = state
+	switch state {
+	case StateDraft, StateApproved, StateScheduled, StateOpen, StatePaused:
+		item.Closed = false
+		item.Outcome = ""
+	case StateClosedSold:
+		item.Closed = true
+		item.Outcome = OutcomeSold
+	case StateClosedUnsold:
+		item.Closed = true
+	}
+}
+
+// closedState returns the state of an item closed with the given outcome.
+func closedState(outcome string) string {
+	if outcome == OutcomeSold {
+		return StateClosedSold
+	}
+	return StateClosedUnsold
+}
+
+// itemStateScript is the redis scripts' version of stateOf.
+const itemStateScript = `
+local function itemState(item, open)
+	if type(item.state) == "string" and item.state ~= "" then
+		return item.state
+	end
+	if open then
+		return "open"
+	end
+	if not item.closed then
+		return "approved"
+	end
+	if item.outcome == "sold" or item.outcome == nil then
+		return "closed-sold"
+	end
+	return "closed-unsold"
+end
+
+-- canMoveFrom reports whether state is one of the space-separated states in
+-- from.
+local function canMoveFrom(from, state)
+	return string.find(" " .. from .. " ", " " .. state .. " ", 1, true) ~= nil
+end
+`
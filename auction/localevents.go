@@ -0,0 +1,145 @@
+package auction
+
+import (
+	"strconv"
+	"sync"
+)
+
+// localEvents publishes logged events to subscribers in the same process, for
+// stores that have nothing like a redis stream to do it for them. Their event
+// IDs must be increasing integers.
+type localEvents struct {
+	mu          sync.Mutex
+	subscribers map[*localSubscriber]bool
+}
+
+// publish queues events for every subscriber, in order.
+func (l *localEvents) publish(entries ...LogEntry) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, entry := range entries {
+		for sub := range l.subscribers {
+			sub.push(entry)
+		}
+	}
+}
+
+// subscribe subscribes to the events logged after the given ID, which history
+// is used to look up. history is called before any more events can be
+// published, and anything it returns that is published again afterwards is
+// only delivered once.
+func (l *localEvents) subscribe(after string, history func(after string) ([]LogEntry, error)) (<-chan LogEntry, func(), error) {
+	sub := &localSubscriber{
+		wake: make(chan struct{}, 1),
+		done: make(chan struct{}),
+	}
+	l.mu.Lock()
+	if after != "" {
+		last, err := parseLocalEventID(after)
+		if err != nil {
+			l.mu.Unlock()
+			return nil, nil, err
+		}
+		sub.last = last
+		entries, err := history(after)
+		if err != nil {
+			l.mu.Unlock()
+			return nil, nil, err
+		}
+		for _, entry := range entries {
+			sub.push(entry)
+		}
+	}
+	if l.subscribers == nil {
+		l.subscribers = map[*localSubscriber]bool{}
+	}
+	l.subscribers[sub] = true
+	l.mu.Unlock()
+	out := make(chan LogEntry)
+	go sub.pump(out)
+	return out, func() {
+		l.mu.Lock()
+		delete(l.subscribers, sub)
+		l.mu.Unlock()
+		sub.stop()
+	}, nil
+}
+
+func (l *localEvents) close() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for sub := range l.subscribers {
+		sub.stop()
+	}
+	l.subscribers = nil
+}
+
+func parseLocalEventID(id string) (int64, error) {
+	n, err := strconv.ParseInt(id, 10, 64)
+	if err != nil || n < 0 {
+		return 0, ErrBadEventID
+	}
+	return n, nil
+}
+
+// localSubscriber queues events for one subscriber, so that publishing never
+// waits for a slow reader, just as redis doesn't.
+type localSubscriber struct {
+	mu    sync.Mutex
+	queue []LogEntry
+	// last is the ID of the last event queued, so that events replayed from
+	// the log aren't delivered again when they are published.
+	last     int64
+	wake     chan struct{}
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+func (sub *localSubscriber) stop() {
+	sub.stopOnce.Do(func() {
+		close(sub.done)
+	})
+}
+
+func (sub *localSubscriber) push(entry LogEntry) {
+	id, err := parseLocalEventID(entry.ID)
+	if err != nil {
+		return
+	}
+	sub.mu.Lock()
+	if id <= sub.last {
+		sub.mu.Unlock()
+		return
+	}
+	sub.last = id
+	sub.queue = append(sub.queue, entry)
+	sub.mu.Unlock()
+	select {
+	case sub.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (sub *localSubscriber) pump(out chan<- LogEntry) {
+	defer close(out)
+	for {
+		sub.mu.Lock()
+		if len(sub.queue) == 0 {
+			sub.mu.Unlock()
+			select {
+			case <-sub.wake:
+				continue
+			case <-sub.done:
+				return
+			}
+		}
+		entry := sub.queue[0]
+		sub.queue = sub.queue[1:]
+		sub.mu.Unlock()
+		select {
+		case out <- entry:
+		case <-sub.done:
+			return
+		}
+	}
+}
@@ -0,0 +1,1203 @@
+package auction
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// memoryStore keeps the auction in memory, for rehearsals and tests. It makes
+// the same checks and publishes the same events as redisStore, holding a
+// single lock where redisStore runs a script.
+type memoryStore struct {
+	mu sync.Mutex
+	// Items are kept as JSON, as redis keeps them, so that nobody can change a
+	// stored item through a pointer they were given.
+	items       map[string][]byte
+	externalIDs map[string]string
+	bids        map[string][]Bid
+	maxBids     map[string]map[string]MaxBid
+	sealedBids  map[string]map[string]sealedBid
+	openItems   map[string]bool
+	currentItem string
+	// queue is the run queue, next first.
+	queue []string
+	// openings are the times scheduled items open, in milliseconds, and
+	// previewed are the items whose openings have had their reminders.
+	openings    map[string]int64
+	previewed   map[string]bool
+	deadlines   map[string]int64
+	extensions  map[string]int64
+	dutchPrices map[string]int
+	priceDrops  map[string]int64
+	// pausedDeadlines and pausedPriceDrops are how long paused items had to
+	// go until their deadlines and next price drops, in milliseconds.
+	pausedDeadlines  map[string]int64
+	pausedPriceDrops map[string]int64
+	rules            *BidRules
+	totalRaised      int
+	archived         bool
+	events           localEvents
+	// logMu guards log, which the event log's IDs are indexes into, plus one.
+	logMu sync.Mutex
+	log   []LogEntry
+}
+
+// NewMemoryStore returns a Store that keeps the auction in memory, and loses it
+// when the process exits.
+func NewMemoryStore() Store {
+	return &memoryStore{
+		items:       map[string][]byte{},
+		externalIDs: map[string]string{},
+		bids:        map[string][]Bid{},
+		maxBids:     map[string]map[string]MaxBid{},
+		sealedBids:  map[string]map[string]sealedBid{},
+		openItems:   map[string]bool{},
+		deadlines:   map[string]int64{},
+		extensions:  map[string]int64{},
+		dutchPrices: map[string]int{},
+		priceDrops:  map[string]int64{},
+
+		pausedDeadlines:  map[string]int64{},
+		pausedPriceDrops: map[string]int64{},
+		openings:         map[string]int64{},
+		previewed:        map[string]bool{},
+	}
+}
+
+func (s *memoryStore) item(itemID string) (Item, bool) {
+	blob, ok := s.items[itemID]
+	if !ok {
+		return Item{}, false
+	}
+	var item Item
+	if err := json.Unmarshal(blob, &item); err != nil {
+		return Item{}, false
+	}
+	item.ID = itemID
+	return item, true
+}
+
+func (s *memoryStore) putItem(item Item) error {
+	blob, err := json.Marshal(item)
+	if err != nil {
+		return err
+	}
+	s.items[item.ID] = blob
+	return nil
+}
+
+// publish logs events and sends them to every subscriber. It must be called
+// with s.mu held, so that events are logged in the order their changes were
+// made.
+func (s *memoryStore) publish(events ...Event) {
+	entries := make([]LogEntry, 0, len(events))
+	s.logMu.Lock()
+	for _, e := range events {
+		entry, err := newLogEntry(e)
+		if err != nil {
+			continue
+		}
+		entry.ID = strconv.Itoa(len(s.log) + 1)
+		s.log = append(s.log, entry)
+		entries = append(entries, entry)
+	}
+	s.logMu.Unlock()
+	s.events.publish(entries...)
+}
+
+func (s *memoryStore) CreateItem(item Item) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.checkLive(); err != nil {
+		return err
+	}
+	if item.ExternalID != "" {
+		if _, ok := s.externalIDs[item.ExternalID]; ok {
+			return &RejectedError{Reason: fmt.Sprintf("an item with external ID %q already exists", item.ExternalID)}
+		}
+	}
+	if _, ok := s.items[item.ID]; ok {
+		return &RejectedError{Reason: "an item with that ID already exists"}
+	}
+	if err := s.putItem(item); err != nil {
+		return err
+	}
+	if item.ExternalID != "" {
+		s.externalIDs[item.ExternalID] = item.ID
+	}
+	return nil
+}
+
+func (s *memoryStore) hasBids(itemID string) bool {
+	return len(s.bids[itemID]) > 0 || len(s.sealedBids[itemID]) > 0
+}
+
+func (s *memoryStore) UpdateItem(itemID string, update func(old Item) (Item, bool, error)) (*Item, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.checkLive(); err != nil {
+		return nil, err
+	}
+	old, ok := s.item(itemID)
+	if !ok {
+		return nil, ErrItemNotFound
+	}
+	item, destructive, err := update(old)
+	if err != nil {
+		return nil, err
+	}
+	if item.ExternalID != old.ExternalID && item.ExternalID != "" {
+		if _, ok := s.externalIDs[item.ExternalID]; ok {
+			return nil, &RejectedError{Reason: fmt.Sprintf("an item with external ID %q already exists", item.ExternalID)}
+		}
+	}
+	if destructive {
+		if s.openItems[itemID] {
+			return nil, &RejectedError{Reason: "the starting bid and bidding rules cannot be changed while the item is open"}
+		}
+		if s.hasBids(itemID) {
+			return nil, &RejectedError{Reason: "the starting bid and bidding rules cannot be changed once the item has bids"}
+		}
+	}
+	if err := s.putItem(item); err != nil {
+		return nil, err
+	}
+	if item.ExternalID != old.ExternalID {
+		if old.ExternalID != "" {
+			delete(s.externalIDs, old.ExternalID)
+		}
+		if item.ExternalID != "" {
+			s.externalIDs[item.ExternalID] = itemID
+		}
+	}
+	return &item, nil
+}
+
+func (s *memoryStore) DeleteItem(itemID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.checkLive(); err != nil {
+		return err
+	}
+	item, ok := s.item(itemID)
+	if !ok {
+		return ErrItemNotFound
+	}
+	if s.openItems[itemID] {
+		return &RejectedError{Reason: "the item cannot be deleted while it is open"}
+	}
+	if s.hasBids(itemID) {
+		return &RejectedError{Reason: "the item cannot be deleted once it has bids"}
+	}
+	if s.queued(itemID) >= 0 {
+		return &RejectedError{Reason: "the item cannot be deleted while it is queued"}
+	}
+	if _, ok := s.openings[itemID]; ok {
+		return &RejectedError{Reason: "the item cannot be deleted while it is scheduled to open"}
+	}
+	if item.ExternalID != "" && s.externalIDs[item.ExternalID] == itemID {
+		delete(s.externalIDs, item.ExternalID)
+	}
+	delete(s.items, itemID)
+	return nil
+}
+
+func (s *memoryStore) GetItem(itemID string) (*Item, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	item, ok := s.item(itemID)
+	if !ok {
+		return nil, ErrItemNotFound
+	}
+	return &item, nil
+}
+
+func (s *memoryStore) GetItems() ([]Item, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	items := make([]Item, 0, len(s.items))
+	for itemID := range s.items {
+		if item, ok := s.item(itemID); ok {
+			items = append(items, item)
+		}
+	}
+	return items, nil
+}
+
+func (s *memoryStore) GetItemByExternalID(externalID string) (*Item, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	item, ok := s.item(s.externalIDs[externalID])
+	if !ok {
+		return nil, ErrItemNotFound
+	}
+	return &item, nil
+}
+
+// forget removes everything that only matters while an item is open.
+func (s *memoryStore) forget(itemID string) {
+	delete(s.openItems, itemID)
+	delete(s.deadlines, itemID)
+	delete(s.extensions, itemID)
+	delete(s.dutchPrices, itemID)
+	delete(s.priceDrops, itemID)
+	delete(s.pausedDeadlines, itemID)
+	delete(s.pausedPriceDrops, itemID)
+}
+
+func (s *memoryStore) OpenItem(itemID string, options OpenOptions, now time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.checkLive(); err != nil {
+		return err
+	}
+	events, err := s.openItem(itemID, options, now)
+	if err != nil {
+		return err
+	}
+	s.publish(events...)
+	return nil
+}
+
+// openable returns the item and its state if it can be opened.
+func (s *memoryStore) openable(itemID string) (Item, string, error) {
+	item, ok := s.item(itemID)
+	if !ok {
+		return Item{}, "", ErrItemNotFound
+	}
+	from := stateOf(item, s.openItems[itemID])
+	if from != StateOpen {
+		if err := checkTransition(from, StateOpen); err != nil {
+			return Item{}, "", err
+		}
+	}
+	return item, from, nil
+}
+
+// openItem opens an item and returns the events to publish. It must be called
+// with s.mu held.
+func (s *memoryStore) openItem(itemID string, options OpenOptions, now time.Time) ([]Event, error) {
+	item, from, err := s.openable(itemID)
+	if err != nil {
+		return nil, err
+	}
+	if item.Closed {
+		// Reopening a sold item takes its price back off the total. Items
+		// closed before outcomes were recorded were always counted.
+		if item.Outcome == OutcomeSold || item.Outcome == "" {
+			if bids := s.bids[itemID]; len(bids) > 0 {
+				s.totalRaised -= bids[len(bids)-1].BidCents
+			}
+		}
+	}
+	if item.State != StateOpen {
+		setState(&item, StateOpen)
+		if err := s.putItem(item); err != nil {
+			return nil, err
+		}
+	}
+	var events []Event
+	if !options.Silent {
+		if s.currentItem != "" && s.currentItem != itemID {
+			if previous, ok := s.item(s.currentItem); ok && s.openItems[s.currentItem] {
+				previousState := stateOf(previous, true)
+				setState(&previous, StateApproved)
+				if err := s.putItem(previous); err != nil {
+					return nil, err
+				}
+				events = append(events, ItemStateChangedEvent{ItemID: previous.ID, From: previousState, To: StateApproved})
+			}
+			s.forget(s.currentItem)
+		}
+		s.currentItem = itemID
+	}
+	// Reopening an open item mustn't put its price back up, and once an item
+	// has been claimed its price no longer drops.
+	if item.Mode == ModeDutch && item.Dutch != nil && from != StateOpen && len(s.bids[itemID]) == 0 {
+		s.dutchPrices[itemID] = item.Dutch.StartPrice
+		s.priceDrops[itemID] = unixMillis(now) + int64(item.Dutch.Interval)*1000
+	}
+	s.openItems[itemID] = true
+	delete(s.extensions, itemID)
+	delete(s.pausedDeadlines, itemID)
+	delete(s.pausedPriceDrops, itemID)
+	event := OpenItemEvent{ItemID: itemID, Silent: options.Silent}
+	if options.Deadline.IsZero() {
+		delete(s.deadlines, itemID)
+	} else {
+		event.Deadline = unixMillis(options.Deadline)
+		s.deadlines[itemID] = event.Deadline
+	}
+	events = append(events, event)
+	if from != StateOpen {
+		events = append(events, ItemStateChangedEvent{ItemID: itemID, From: from, To: StateOpen})
+	}
+	if s.unqueue(itemID) {
+		events = append(events, s.queueChanged(itemID))
+	}
+	delete(s.openings, itemID)
+	delete(s.previewed, itemID)
+	return events, nil
+}
+
+func (s *memoryStore) CloseItem(itemID string, expiredBy time.Time) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.checkLive(); err != nil {
+		return "", err
+	}
+	result, events, err := s.closeItem(itemID, expiredBy)
+	if err != nil {
+		return "", err
+	}
+	s.publish(events...)
+	return result, nil
+}
+
+// closeItem closes an item and returns its outcome, along with the events to
+// publish. It must be called with s.mu held.
+func (s *memoryStore) closeItem(itemID string, expiredBy time.Time) (string, []Event, error) {
+	if !s.openItems[itemID] {
+		delete(s.deadlines, itemID)
+		if _, ok := s.items[itemID]; !ok {
+			return "", nil, ErrItemNotFound
+		}
+		return "", nil, ErrItemClosed
+	}
+	if !expiredBy.IsZero() {
+		deadline, ok := s.deadlines[itemID]
+		if !ok || deadline > unixMillis(expiredBy) {
+			return "", nil, nil
+		}
+	}
+	item, _ := s.item(itemID)
+	if item.Mode == ModeSealed {
+		sealed := make([]sealedBid, 0, len(s.sealedBids[itemID]))
+		for _, bid := range s.sealedBids[itemID] {
+			sealed = append(sealed, bid)
+		}
+		delete(s.sealedBids, itemID)
+		s.bids[itemID] = append(s.bids[itemID], revealSealedBids(item, sealed)...)
+	}
+	result := outcome(item, s.top(itemID))
+	if result == OutcomeSold {
+		s.totalRaised += s.top(itemID).BidCents
+	}
+	from, to := stateOf(item, true), closedState(result)
+	setState(&item, to)
+	item.Outcome = result
+	if err := s.putItem(item); err != nil {
+		return "", nil, err
+	}
+	s.forget(itemID)
+	if s.currentItem == itemID {
+		s.currentItem = ""
+	}
+	return result, []Event{CloseItemEvent{ItemID: itemID, Outcome: result}, ItemStateChangedEvent{ItemID: itemID, From: from, To: to}}, nil
+}
+
+func (s *memoryStore) PauseItem(itemID string, now time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.checkLive(); err != nil {
+		return err
+	}
+	item, err := s.biddable(itemID)
+	if err != nil {
+		return err
+	}
+	from := stateOf(item, true)
+	if err := checkTransition(from, StatePaused); err != nil {
+		return err
+	}
+	event := PauseItemEvent{ItemID: itemID}
+	if deadline, ok := s.deadlines[itemID]; ok {
+		event.Remaining = remainingMillis(deadline, now)
+		s.pausedDeadlines[itemID] = event.Remaining
+		delete(s.deadlines, itemID)
+	}
+	if nextDrop, ok := s.priceDrops[itemID]; ok {
+		s.pausedPriceDrops[itemID] = remainingMillis(nextDrop, now)
+		delete(s.priceDrops, itemID)
+	}
+	setState(&item, StatePaused)
+	if err := s.putItem(item); err != nil {
+		return err
+	}
+	s.publish(event, ItemStateChangedEvent{ItemID: itemID, From: from, To: StatePaused})
+	return nil
+}
+
+func (s *memoryStore) ResumeItem(itemID string, now time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.checkLive(); err != nil {
+		return err
+	}
+	item, err := s.biddable(itemID)
+	if err != nil {
+		return err
+	}
+	if stateOf(item, true) != StatePaused {
+		return &RejectedError{Reason: "bidding on that item isn't paused"}
+	}
+	event := ResumeItemEvent{ItemID: itemID}
+	if remaining, ok := s.pausedDeadlines[itemID]; ok {
+		event.Deadline = unixMillis(now) + remaining
+		s.deadlines[itemID] = event.Deadline
+		delete(s.pausedDeadlines, itemID)
+	}
+	if remaining, ok := s.pausedPriceDrops[itemID]; ok {
+		s.priceDrops[itemID] = unixMillis(now) + remaining
+		delete(s.pausedPriceDrops, itemID)
+	}
+	setState(&item, StateOpen)
+	if err := s.putItem(item); err != nil {
+		return err
+	}
+	s.publish(event, ItemStateChangedEvent{ItemID: itemID, From: StatePaused, To: StateOpen})
+	return nil
+}
+
+func (s *memoryStore) SetItemState(itemID, state string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.checkLive(); err != nil {
+		return err
+	}
+	item, ok := s.item(itemID)
+	if !ok {
+		return ErrItemNotFound
+	}
+	from := stateOf(item, s.openItems[itemID])
+	if err := checkManualTransition(from, state); err != nil {
+		return err
+	}
+	if state == StateVoided && countedStates[from] {
+		if top := s.top(itemID); top != nil {
+			s.totalRaised -= top.BidCents
+		}
+	}
+	setState(&item, state)
+	if err := s.putItem(item); err != nil {
+		return err
+	}
+	events := []Event{ItemStateChangedEvent{ItemID: itemID, From: from, To: state}}
+	if s.unqueue(itemID) {
+		events = append(events, s.queueChanged(itemID))
+	}
+	if _, ok := s.openings[itemID]; ok {
+		delete(s.openings, itemID)
+		delete(s.previewed, itemID)
+		events = append(events, ItemScheduledEvent{ItemID: itemID})
+	}
+	s.publish(events...)
+	return nil
+}
+
+// queued returns the item's position in the run queue, or -1 if it isn't in
+// it.
+func (s *memoryStore) queued(itemID string) int {
+	for i, queued := range s.queue {
+		if queued == itemID {
+			return i
+		}
+	}
+	return -1
+}
+
+// enqueue puts an item into the run queue at the given position.
+func (s *memoryStore) enqueue(itemID string, position int) {
+	if position < 0 || position > len(s.queue) {
+		position = len(s.queue)
+	}
+	s.queue = append(s.queue, "")
+	copy(s.queue[position+1:], s.queue[position:])
+	s.queue[position] = itemID
+}
+
+// unqueue takes an item off the run queue, and reports whether it was in it.
+func (s *memoryStore) unqueue(itemID string) bool {
+	i := s.queued(itemID)
+	if i < 0 {
+		return false
+	}
+	s.queue = append(s.queue[:i], s.queue[i+1:]...)
+	return true
+}
+
+func (s *memoryStore) queueChanged(itemID string) Event {
+	queue := make([]string, len(s.queue))
+	copy(queue, s.queue)
+	return QueueChangedEvent{ItemID: itemID, Queue: queue}
+}
+
+func (s *memoryStore) Queue() ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	queue := make([]string, len(s.queue))
+	copy(queue, s.queue)
+	return queue, nil
+}
+
+func (s *memoryStore) QueueItem(itemID string, position int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.checkLive(); err != nil {
+		return err
+	}
+	item, ok := s.item(itemID)
+	if !ok {
+		return ErrItemNotFound
+	}
+	var events []Event
+	from := stateOf(item, s.openItems[itemID])
+	if _, ok := s.openings[itemID]; !ok || s.queued(itemID) >= 0 {
+		if err := checkTransition(from, StateScheduled); err != nil {
+			return err
+		}
+		setState(&item, StateScheduled)
+		if err := s.putItem(item); err != nil {
+			return err
+		}
+		events = append(events, ItemStateChangedEvent{ItemID: itemID, From: from, To: StateScheduled})
+	}
+	s.enqueue(itemID, position)
+	s.publish(append(events, s.queueChanged(itemID))...)
+	return nil
+}
+
+func (s *memoryStore) MoveQueuedItem(itemID string, position int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.checkLive(); err != nil {
+		return err
+	}
+	if _, ok := s.items[itemID]; !ok {
+		return ErrItemNotFound
+	}
+	if !s.unqueue(itemID) {
+		return &RejectedError{Reason: "that item isn't in the queue"}
+	}
+	s.enqueue(itemID, position)
+	s.publish(s.queueChanged(itemID))
+	return nil
+}
+
+func (s *memoryStore) UnqueueItem(itemID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.checkLive(); err != nil {
+		return err
+	}
+	item, ok := s.item(itemID)
+	if !ok {
+		return ErrItemNotFound
+	}
+	if !s.unqueue(itemID) {
+		return &RejectedError{Reason: "that item isn't in the queue"}
+	}
+	var events []Event
+	_, scheduled := s.openings[itemID]
+	if from := stateOf(item, s.openItems[itemID]); from == StateScheduled && !scheduled {
+		setState(&item, StateApproved)
+		if err := s.putItem(item); err != nil {
+			return err
+		}
+		events = append(events, ItemStateChangedEvent{ItemID: itemID, From: from, To: StateApproved})
+	}
+	s.publish(append(events, s.queueChanged(itemID))...)
+	return nil
+}
+
+func (s *memoryStore) ScheduleOpening(itemID string, at time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.checkLive(); err != nil {
+		return err
+	}
+	item, ok := s.item(itemID)
+	if !ok {
+		return ErrItemNotFound
+	}
+	var events []Event
+	from := stateOf(item, s.openItems[itemID])
+	if from != StateScheduled {
+		if err := checkTransition(from, StateScheduled); err != nil {
+			return err
+		}
+		setState(&item, StateScheduled)
+		if err := s.putItem(item); err != nil {
+			return err
+		}
+		events = append(events, ItemStateChangedEvent{ItemID: itemID, From: from, To: StateScheduled})
+	}
+	s.openings[itemID] = unixMillis(at)
+	delete(s.previewed, itemID)
+	s.publish(append(events, ItemScheduledEvent{ItemID: itemID, OpensAt: unixMillis(at)})...)
+	return nil
+}
+
+func (s *memoryStore) CancelOpening(itemID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.checkLive(); err != nil {
+		return err
+	}
+	item, ok := s.item(itemID)
+	if !ok {
+		return ErrItemNotFound
+	}
+	if _, ok := s.openings[itemID]; !ok {
+		return &RejectedError{Reason: "that item isn't scheduled to open"}
+	}
+	delete(s.openings, itemID)
+	delete(s.previewed, itemID)
+	events := []Event{ItemScheduledEvent{ItemID: itemID}}
+	if from := stateOf(item, s.openItems[itemID]); from == StateScheduled && s.queued(itemID) < 0 {
+		setState(&item, StateApproved)
+		if err := s.putItem(item); err != nil {
+			return err
+		}
+		events = append(events, ItemStateChangedEvent{ItemID: itemID, From: from, To: StateApproved})
+	}
+	s.publish(events...)
+	return nil
+}
+
+func (s *memoryStore) Openings() ([]Opening, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	openings := make([]Opening, 0, len(s.openings))
+	for itemID, at := range s.openings {
+		openings = append(openings, Opening{ItemID: itemID, At: fromUnixMillis(at), Previewed: s.previewed[itemID]})
+	}
+	sortOpenings(openings)
+	return openings, nil
+}
+
+func (s *memoryStore) PreviewOpening(itemID string, by time.Time) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.checkLive(); err != nil {
+		return false, err
+	}
+	at, ok := s.openings[itemID]
+	if !ok || s.previewed[itemID] || at > unixMillis(by) {
+		return false, nil
+	}
+	s.previewed[itemID] = true
+	s.publish(ItemScheduledEvent{ItemID: itemID, OpensAt: at, Reminder: true})
+	return true, nil
+}
+
+func (s *memoryStore) OpenScheduledItem(itemID string, now time.Time) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.checkLive(); err != nil {
+		return false, err
+	}
+	at, ok := s.openings[itemID]
+	if !ok || at > unixMillis(now) {
+		return false, nil
+	}
+	events, err := s.openItem(itemID, OpenOptions{}, now)
+	if err != nil {
+		return false, err
+	}
+	s.publish(events...)
+	return true, nil
+}
+
+func (s *memoryStore) NextItem(closeCurrent bool, deadline time.Time, now time.Time) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.checkLive(); err != nil {
+		return "", err
+	}
+	if len(s.queue) == 0 {
+		return "", ErrQueueEmpty
+	}
+	next := s.queue[0]
+	// Make sure the next item can be opened before closing anything.
+	if _, _, err := s.openable(next); err != nil {
+		return "", err
+	}
+	var events []Event
+	if s.currentItem != "" {
+		if !closeCurrent {
+			return "", &RejectedError{Reason: "another item is already up for auction"}
+		}
+		_, closed, err := s.closeItem(s.currentItem, time.Time{})
+		if err != nil {
+			return "", err
+		}
+		events = closed
+	}
+	opened, err := s.openItem(next, OpenOptions{Deadline: deadline}, now)
+	if err != nil {
+		return "", err
+	}
+	s.publish(append(events, opened...)...)
+	return next, nil
+}
+
+// top returns the item's high bid, or nil if it has no bids.
+func (s *memoryStore) top(itemID string) *Bid {
+	if bids := s.bids[itemID]; len(bids) > 0 {
+		top := bids[len(bids)-1]
+		return &top
+	}
+	return nil
+}
+
+func (s *memoryStore) Archive() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := checkArchivable(len(s.openItems), len(s.queue), len(s.openings)); err != nil {
+		return err
+	}
+	if !s.archived {
+		s.archived = true
+		s.publish(AuctionArchivedEvent{})
+	}
+	return nil
+}
+
+// checkLive returns ErrAuctionArchived if the auction has been archived. It
+// must be called with s.mu held.
+func (s *memoryStore) checkLive() error {
+	if s.archived {
+		return ErrAuctionArchived
+	}
+	return nil
+}
+
+func (s *memoryStore) Archived() (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.archived, nil
+}
+
+func (s *memoryStore) CurrentItemID() (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.currentItem == "" {
+		return "", ErrNoCurrentItem
+	}
+	return s.currentItem, nil
+}
+
+func (s *memoryStore) OpenItemIDs() ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	itemIDs := make([]string, 0, len(s.openItems))
+	for itemID := range s.openItems {
+		itemIDs = append(itemIDs, itemID)
+	}
+	return itemIDs, nil
+}
+
+func (s *memoryStore) IsOpen(itemID string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.openItems[itemID]
+}
+
+// biddable returns the item if it is open for bidding.
+func (s *memoryStore) biddable(itemID string) (Item, error) {
+	item, ok := s.item(itemID)
+	if !ok {
+		return Item{}, ErrItemNotFound
+	}
+	if !s.openItems[itemID] {
+		return Item{}, ErrItemClosed
+	}
+	return item, nil
+}
+
+func (s *memoryStore) PlaceBid(req BidRequest) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.checkLive(); err != nil {
+		return err
+	}
+	item, err := s.biddable(req.ItemID)
+	if err != nil {
+		return err
+	}
+	price, hasPrice := s.dutchPrices[item.ID]
+	_, hasSealedBid := s.sealedBids[item.ID][req.Bidder]
+	result, err := placeBid(bidState{
+		item:          item,
+		rules:         mergeRules(s.rules, item.Rules),
+		top:           s.top(item.ID),
+		maxBids:       s.maxBids[item.ID],
+		sealedBids:    len(s.sealedBids[item.ID]),
+		hasSealedBid:  hasSealedBid,
+		deadline:      s.deadlines[item.ID],
+		extended:      s.extensions[item.ID],
+		dutchPrice:    price,
+		hasDutchPrice: hasPrice,
+	}, req)
+	if err != nil {
+		return err
+	}
+	s.bids[item.ID] = append(s.bids[item.ID], result.bids...)
+	if result.maxBid != nil {
+		if s.maxBids[item.ID] == nil {
+			s.maxBids[item.ID] = map[string]MaxBid{}
+		}
+		s.maxBids[item.ID][req.Bidder] = *result.maxBid
+	}
+	if result.sealedBid != nil {
+		if s.sealedBids[item.ID] == nil {
+			s.sealedBids[item.ID] = map[string]sealedBid{}
+		}
+		s.sealedBids[item.ID][req.Bidder] = *result.sealedBid
+	}
+	if result.extension > 0 {
+		s.deadlines[item.ID] = result.deadline
+		s.extensions[item.ID] += result.extension
+	}
+	events := result.events
+	if result.claimed {
+		_, closed, err := s.closeItem(item.ID, time.Time{})
+		if err != nil {
+			return err
+		}
+		events = append(events, closed...)
+	}
+	s.publish(events...)
+	return nil
+}
+
+func (s *memoryStore) BuyNow(req BidRequest, cutoff float64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.checkLive(); err != nil {
+		return err
+	}
+	item, err := s.biddable(req.ItemID)
+	if err != nil {
+		return err
+	}
+	bid, err := buyNow(item, s.top(item.ID), req, s.deadlines[item.ID], cutoff)
+	if err != nil {
+		return err
+	}
+	s.bids[item.ID] = append(s.bids[item.ID], bid)
+	from := stateOf(item, true)
+	setState(&item, StateClosedSold)
+	if err := s.putItem(item); err != nil {
+		return err
+	}
+	delete(s.openItems, item.ID)
+	if s.currentItem == item.ID {
+		s.currentItem = ""
+	}
+	delete(s.deadlines, item.ID)
+	delete(s.extensions, item.ID)
+	s.totalRaised += bid.BidCents
+	s.publish(BidEvent{Bid: bid}, CloseItemEvent{ItemID: item.ID, Outcome: OutcomeSold, BuyNow: true}, ItemStateChangedEvent{ItemID: item.ID, From: from, To: StateClosedSold})
+	return nil
+}
+
+func (s *memoryStore) DeleteBid(itemID, bidID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.checkLive(); err != nil {
+		return err
+	}
+	if item, ok := s.item(itemID); ok && item.Closed {
+		return &RejectedError{Reason: "bids can't be deleted once the item has closed"}
+	}
+	bids := s.bids[itemID]
+	for i, bid := range bids {
+		if bid.ID == bidID {
+			s.bids[itemID] = append(bids[:i:i], bids[i+1:]...)
+			s.publish(DeleteBidEvent{ItemID: itemID, BidID: bidID, BidCents: bid.BidCents, Bidder: bid.Bidder, BidderDisplayName: bid.BidderDisplayName})
+			return nil
+		}
+	}
+	return ErrBidNotFound
+}
+
+func (s *memoryStore) GetBids(itemID string, n int) ([]Bid, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	bids := s.bids[itemID]
+	if n > 0 && n < len(bids) {
+		bids = bids[len(bids)-n:]
+	}
+	return append([]Bid{}, bids...), nil
+}
+
+func (s *memoryStore) GetMaxBids(itemID string) ([]MaxBid, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	maxBids := make([]MaxBid, 0, len(s.maxBids[itemID]))
+	for _, maxBid := range s.maxBids[itemID] {
+		maxBids = append(maxBids, maxBid)
+	}
+	return maxBids, nil
+}
+
+func (s *memoryStore) GetMaxBid(itemID, bidder string) (*MaxBid, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	maxBid, ok := s.maxBids[itemID][bidder]
+	if !ok {
+		return nil, nil
+	}
+	return &maxBid, nil
+}
+
+func (s *memoryStore) GetSealedBids(itemID string) ([]Bid, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	bids := make([]Bid, 0, len(s.sealedBids[itemID]))
+	for _, bid := range s.sealedBids[itemID] {
+		bids = append(bids, bid.Bid)
+	}
+	sort.Slice(bids, func(i, j int) bool {
+		return bids[i].BidCents < bids[j].BidCents
+	})
+	return bids, nil
+}
+
+func (s *memoryStore) Deadline(itemID string) (time.Time, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	deadline, ok := s.deadlines[itemID]
+	if !ok {
+		return time.Time{}, false
+	}
+	return fromUnixMillis(deadline), true
+}
+
+// checkNotPaused refuses to change the deadline of a paused item, whose
+// deadline is frozen.
+func (s *memoryStore) checkNotPaused(itemID string) error {
+	if item, ok := s.item(itemID); ok && item.State == StatePaused {
+		return &RejectedError{Reason: "the deadline can't be changed while bidding is paused"}
+	}
+	return nil
+}
+
+func (s *memoryStore) SetDeadline(itemID string, deadline time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.checkLive(); err != nil {
+		return err
+	}
+	if !s.openItems[itemID] {
+		return ErrItemClosed
+	}
+	if err := s.checkNotPaused(itemID); err != nil {
+		return err
+	}
+	s.deadlines[itemID] = unixMillis(deadline)
+	s.publish(DeadlineChangedEvent{ItemID: itemID, Deadline: s.deadlines[itemID]})
+	return nil
+}
+
+func (s *memoryStore) ExtendDeadline(itemID string, by time.Duration) (time.Time, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.checkLive(); err != nil {
+		return time.Time{}, err
+	}
+	if !s.openItems[itemID] {
+		return time.Time{}, ErrItemClosed
+	}
+	if err := s.checkNotPaused(itemID); err != nil {
+		return time.Time{}, err
+	}
+	deadline, ok := s.deadlines[itemID]
+	if !ok {
+		return time.Time{}, &RejectedError{Reason: "that item has no deadline to extend"}
+	}
+	deadline += int64(by / time.Millisecond)
+	s.deadlines[itemID] = deadline
+	s.publish(DeadlineChangedEvent{ItemID: itemID, Deadline: deadline})
+	return fromUnixMillis(deadline), nil
+}
+
+func (s *memoryStore) CancelDeadline(itemID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.checkLive(); err != nil {
+		return err
+	}
+	if !s.openItems[itemID] {
+		return ErrItemClosed
+	}
+	if err := s.checkNotPaused(itemID); err != nil {
+		return err
+	}
+	delete(s.deadlines, itemID)
+	s.publish(DeadlineChangedEvent{ItemID: itemID})
+	return nil
+}
+
+// due returns the keys of schedule that are no later than now, earliest first.
+func due(schedule map[string]int64, now time.Time) []string {
+	itemIDs := []string{}
+	for itemID, at := range schedule {
+		if at <= unixMillis(now) {
+			itemIDs = append(itemIDs, itemID)
+		}
+	}
+	sort.Slice(itemIDs, func(i, j int) bool {
+		return schedule[itemIDs[i]] < schedule[itemIDs[j]]
+	})
+	return itemIDs
+}
+
+func (s *memoryStore) ExpiredItems(now time.Time) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return due(s.deadlines, now), nil
+}
+
+func (s *memoryStore) CurrentPrice(itemID string) (int, time.Time, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	price, ok := s.dutchPrices[itemID]
+	if !ok {
+		return 0, time.Time{}, errNoPrice
+	}
+	nextDrop, ok := s.priceDrops[itemID]
+	if !ok {
+		return price, time.Time{}, nil
+	}
+	return price, fromUnixMillis(nextDrop), nil
+}
+
+func (s *memoryStore) DuePriceDrops(now time.Time) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return due(s.priceDrops, now), nil
+}
+
+func (s *memoryStore) DropPrice(itemID string, now time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.checkLive(); err != nil {
+		return err
+	}
+	nextDrop, ok := s.priceDrops[itemID]
+	price, hasPrice := s.dutchPrices[itemID]
+	item, hasItem := s.item(itemID)
+	if !ok || !hasPrice || !hasItem || item.Dutch == nil || nextDrop > unixMillis(now) {
+		return nil
+	}
+	price, nextDrop = dropPrice(*item.Dutch, price, nextDrop, unixMillis(now))
+	s.dutchPrices[itemID] = price
+	if nextDrop == 0 {
+		delete(s.priceDrops, itemID)
+	} else {
+		s.priceDrops[itemID] = nextDrop
+	}
+	s.publish(PriceDroppedEvent{ItemID: itemID, PriceCents: price, NextDrop: nextDrop})
+	return nil
+}
+
+func (s *memoryStore) BidRules() (BidRules, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.rules == nil {
+		return DefaultBidRules, nil
+	}
+	rules := *s.rules
+	rules.Increments = append([]IncrementStep{}, rules.Increments...)
+	return rules, nil
+}
+
+func (s *memoryStore) SetBidRules(rules BidRules) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.checkLive(); err != nil {
+		return err
+	}
+	rules.Increments = append([]IncrementStep{}, rules.Increments...)
+	s.rules = &rules
+	return nil
+}
+
+func (s *memoryStore) TotalRaised() (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.totalRaised, nil
+}
+
+func (s *memoryStore) ReconcileTotal(fix bool) (int, int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	computed := 0
+	for itemID := range s.items {
+		item, _ := s.item(itemID)
+		if !countedStates[stateOf(item, s.openItems[itemID])] {
+			continue
+		}
+		if top := s.top(itemID); top != nil {
+			computed += top.BidCents
+		}
+	}
+	recorded := s.totalRaised
+	if fix {
+		if err := s.checkLive(); err != nil {
+			return 0, 0, err
+		}
+		s.totalRaised = computed
+	}
+	return recorded, computed, nil
+}
+
+func (s *memoryStore) Subscribe(after string) (<-chan LogEntry, func(), error) {
+	return s.events.subscribe(after, func(after string) ([]LogEntry, error) {
+		return s.EventLog(after, "", 0)
+	})
+}
+
+func (s *memoryStore) EventLog(after, itemID string, limit int) ([]LogEntry, error) {
+	var start int64
+	if after != "" {
+		var err error
+		if start, err = parseLocalEventID(after); err != nil {
+			return nil, err
+		}
+	}
+	s.logMu.Lock()
+	defer s.logMu.Unlock()
+	entries := []LogEntry{}
+	for i := start; i < int64(len(s.log)); i++ {
+		if itemID != "" && s.log[i].ItemID != itemID {
+			continue
+		}
+		entries = append(entries, s.log[i])
+		if limit > 0 && len(entries) == limit {
+			break
+		}
+	}
+	return entries, nil
+}
+
+func (s *memoryStore) LastEventID() (string, error) {
+	s.logMu.Lock()
+	defer s.logMu.Unlock()
+	if len(s.log) == 0 {
+		return "", nil
+	}
+	return strconv.Itoa(len(s.log)), nil
+}
+
+func (s *memoryStore) Close() error {
+	s.events.close()
+	return nil
+}
@@ -0,0 +1,27 @@
+package auction
+
+import (
+	"time"
+)
+
+// PauseItem freezes bidding on an open item without closing it, for when the
+// auction has to stop for a while. Bids are refused with ErrItemPaused, and the
+// clock stops on the item's deadline until it is resumed.
+func (a *Auction) PauseItem(itemID string) error {
+	return a.store.PauseItem(itemID, time.Now())
+}
+
+// ResumeItem restarts bidding on a paused item. Its deadline is pushed back by
+// however long it was paused.
+func (a *Auction) ResumeItem(itemID string) error {
+	return a.store.ResumeItem(itemID, time.Now())
+}
+
+// remainingMillis returns how long there is to go from now until a time given
+// in milliseconds since the Unix epoch, or zero if it has passed.
+func remainingMillis(at int64, now time.Time) int64 {
+	if remaining := at - unixMillis(now); remaining > 0 {
+		return remaining
+	}
+	return 0
+}
@@ -0,0 +1,117 @@
+package auction
+
+import (
+	"errors"
+	"sync/atomic"
+	"time"
+)
+
+// Queue returns the items in the run queue, which are the items waiting to go
+// up for auction, next first.
+func (a *Auction) Queue() ([]Item, error) {
+	itemIDs, err := a.store.Queue()
+	if err != nil {
+		return nil, err
+	}
+	items := make([]Item, 0, len(itemIDs))
+	for _, itemID := range itemIDs {
+		item, err := a.store.GetItem(itemID)
+		if err == ErrItemNotFound {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, *item)
+	}
+	return items, nil
+}
+
+// QueueItem adds an approved item to the run queue at the given position,
+// counting from zero, or at the end if the position is negative.
+func (a *Auction) QueueItem(itemID string, position int) error {
+	return a.store.QueueItem(itemID, position)
+}
+
+// MoveQueuedItem moves an item in the run queue to another position.
+func (a *Auction) MoveQueuedItem(itemID string, position int) error {
+	return a.store.MoveQueuedItem(itemID, position)
+}
+
+// SkipQueuedItem moves an item in the run queue to the back of it, to come back
+// to once everything else has been auctioned.
+func (a *Auction) SkipQueuedItem(itemID string) error {
+	return a.store.MoveQueuedItem(itemID, -1)
+}
+
+// UnqueueItem takes an item off the run queue, and approves it again.
+func (a *Auction) UnqueueItem(itemID string) error {
+	return a.store.UnqueueItem(itemID)
+}
+
+// NextItem closes the current item, if there is one, and opens the next item in
+// the run queue in its place, in a single step. The new item closes
+// automatically at the deadline, unless it is zero. It returns ErrQueueEmpty,
+// and changes nothing, if there is no next item.
+func (a *Auction) NextItem(deadline time.Time) (*Item, error) {
+	itemID, err := a.store.NextItem(true, deadline, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	return a.GetItem(itemID)
+}
+
+// SetAutoAdvance makes RunScheduler open the next item in the run queue once
+// the current item has been closed for the given delay. A delay of zero turns
+// it off.
+func (a *Auction) SetAutoAdvance(delay time.Duration) error {
+	if delay < 0 {
+		return errors.New("the auto-advance delay can't be negative")
+	}
+	atomic.StoreInt64(&a.autoAdvance, int64(delay))
+	return nil
+}
+
+// AutoAdvance returns how long after the current item closes the next item in
+// the run queue is opened, or zero if it isn't.
+func (a *Auction) AutoAdvance() time.Duration {
+	return time.Duration(atomic.LoadInt64(&a.autoAdvance))
+}
+
+// advancer is what RunScheduler remembers between checks on whether to open
+// the next item.
+type advancer struct {
+	// current is the current item at the last check.
+	current string
+	// at is when the next item is due to be opened, if it is.
+	at time.Time
+}
+
+// advance opens the next item in the run queue if auto-advance is on and the
+// delay since the current item closed is up. Every process running the
+// scheduler does this, but the store only lets the first one open an item.
+func (a *Auction) advance(adv *advancer, now time.Time) error {
+	current, err := a.store.CurrentItemID()
+	if err != nil && err != ErrNoCurrentItem {
+		return err
+	}
+	delay := a.AutoAdvance()
+	switch {
+	case current != "" || delay == 0:
+		adv.at = time.Time{}
+	case adv.current != "":
+		adv.at = now.Add(delay)
+	}
+	adv.current = current
+	if adv.at.IsZero() || now.Before(adv.at) {
+		return nil
+	}
+	adv.at = time.Time{}
+	_, err = a.store.NextItem(false, time.Time{}, now)
+	var rejected *RejectedError
+	if err == ErrQueueEmpty || errors.As(err, &rejected) {
+		// There's nothing to open, or someone else got there first.
+		return nil
+	}
+	return err
+}
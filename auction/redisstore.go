@@ -0,0 +1,2053 @@
+package auction
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v7"
+)
+
+const eventLogKey = "auction-events"
+const currentItemKey = "current-item"
+const openItemsKey = "open-items"
+const allItemsKey = "all-items"
+const totalRaisedKey = "total-raised"
+const externalIDsKey = "external-ids"
+const deadlinesKey = "item-deadlines"
+const deadlineExtensionsKey = "deadline-extensions"
+const maxBidsKeyPrefix = "max-bids-"
+const sealedBidsKeyPrefix = "sealed-bids-"
+const dutchPricesKey = "dutch-prices"
+const priceDropsKey = "dutch-price-drops"
+const bidRulesKey = "bid-rules"
+const pausedDeadlinesKey = "paused-deadlines"
+const pausedPriceDropsKey = "paused-price-drops"
+const queueKey = "run-queue"
+const openingsKey = "scheduled-openings"
+const previewedOpeningsKey = "previewed-openings"
+const archivedKey = "archived"
+
+// redisStore keeps the auction in redis. Anything that has to be atomic is done
+// in a Lua script, and events are appended to the auction-events stream, whose
+// IDs redis generates from its clock. Scripts that log events therefore call
+// redis.replicate_commands, so that older versions of redis replicate what the
+// script did rather than running it again.
+type redisStore struct {
+	redis          *redis.Client
+	namespace      string
+	eventLogLength int64
+	mu             sync.Mutex
+	subscriptions  map[chan struct{}]bool
+}
+
+// DefaultEventLogLength is roughly how many events a redis store keeps in its
+// event log by default.
+const DefaultEventLogLength = 100000
+
+// NewRedisStore returns a Store that keeps the auction in redis. Every key is
+// prefixed with the namespace and a colon, so that several auctions can share
+// a database. The empty namespace has no prefix, and is where auctions were
+// kept before there were namespaces.
+//
+// The event log keeps roughly the last eventLogLength events, trimming older
+// ones as new ones are logged, or every event if eventLogLength is zero.
+// Events can only be replayed, and item histories only go back, as far as the
+// oldest event kept.
+func NewRedisStore(client *redis.Client, namespace string, eventLogLength int64) Store {
+	return &redisStore{
+		redis:          client,
+		namespace:      namespace,
+		eventLogLength: eventLogLength,
+		subscriptions:  map[chan struct{}]bool{},
+	}
+}
+
+// newScript returns a script that can log events with logEvent, which trims
+// the event log to the store's length.
+func (s *redisStore) newScript(src string) *redis.Script {
+	return redis.NewScript(fmt.Sprintf(`
+local eventLogLength = %d
+local function logEvent(eventLogKey, ...)
+	if eventLogLength > 0 then
+		return redis.call("XADD", eventLogKey, "MAXLEN", "~", eventLogLength, "*", ...)
+	end
+	return redis.call("XADD", eventLogKey, "*", ...)
+end
+`, s.eventLogLength) + src)
+}
+
+// key returns the name of a key in the store's namespace.
+func (s *redisStore) key(name string) string {
+	if s.namespace == "" {
+		return name
+	}
+	return s.namespace + ":" + name
+}
+
+// keys returns the names of several keys in the store's namespace, for a
+// script.
+func (s *redisStore) keys(names ...string) []string {
+	keys := make([]string, len(names))
+	for i, name := range names {
+		keys[i] = s.key(name)
+	}
+	return keys
+}
+
+func (s *redisStore) CreateItem(item Item) error {
+	itemJSON, err := json.Marshal(item)
+	if err != nil {
+		return err
+	}
+	script := s.newScript(`
+local itemKey = KEYS[1]
+local allItemsKey = KEYS[2]
+local externalIdsKey = KEYS[3]
+local archivedKey = KEYS[4]
+local itemJSON = ARGV[1]
+local itemId = ARGV[2]
+local externalId = ARGV[3]
+` + liveScript + `
+if externalId ~= "" and redis.call("HEXISTS", externalIdsKey, externalId) == 1 then
+	return redis.error_reply(string.format("REJECTED an item with external ID %q already exists", externalId))
+end
+if not redis.call("SET", itemKey, itemJSON, "NX") then
+	return redis.error_reply("REJECTED an item with that ID already exists")
+end
+redis.call("SADD", allItemsKey, itemId)
+if externalId ~= "" then
+	redis.call("HSET", externalIdsKey, externalId, itemId)
+end
+return redis.status_reply("ok")
+`)
+	return scriptError(script.Run(s.redis, s.keys(item.ID, allItemsKey, externalIDsKey, archivedKey), string(itemJSON), item.ID, item.ExternalID).Err())
+}
+
+// UpdateItem only writes the item if it hasn't changed since it was read, and
+// otherwise tries again.
+func (s *redisStore) UpdateItem(itemID string, update func(old Item) (Item, bool, error)) (*Item, error) {
+	script := s.newScript(`
+local itemKey = KEYS[1]
+local openItemsKey = KEYS[2]
+local bidsKey = KEYS[3]
+local externalIdsKey = KEYS[4]
+local sealedBidsKey = KEYS[5]
+local archivedKey = KEYS[6]
+local oldJSON = ARGV[1]
+local itemJSON = ARGV[2]
+local itemId = ARGV[3]
+local destructive = ARGV[4] == "1"
+local oldExternalId = ARGV[5]
+local externalId = ARGV[6]
+` + liveScript + `
+local stored = redis.call("GET", itemKey)
+if not stored then
+	return redis.error_reply("NOITEM no such item exists")
+end
+if stored ~= oldJSON then
+	return redis.error_reply("CHANGED")
+end
+if externalId ~= oldExternalId and externalId ~= "" and redis.call("HEXISTS", externalIdsKey, externalId) == 1 then
+	return redis.error_reply(string.format("REJECTED an item with external ID %q already exists", externalId))
+end
+if destructive then
+	if redis.call("SISMEMBER", openItemsKey, itemId) == 1 then
+		return redis.error_reply("REJECTED the starting bid and bidding rules cannot be changed while the item is open")
+	end
+	if redis.call("LLEN", bidsKey) > 0 or redis.call("HLEN", sealedBidsKey) > 0 then
+		return redis.error_reply("REJECTED the starting bid and bidding rules cannot be changed once the item has bids")
+	end
+end
+redis.call("SET", itemKey, itemJSON)
+if externalId ~= oldExternalId then
+	if oldExternalId ~= "" then
+		redis.call("HDEL", externalIdsKey, oldExternalId)
+	end
+	if externalId ~= "" then
+		redis.call("HSET", externalIdsKey, externalId, itemId)
+	end
+end
+return redis.status_reply("ok")
+`)
+	for {
+		oldJSON, err := s.redis.Get(s.key(itemID)).Result()
+		if err == redis.Nil {
+			return nil, ErrItemNotFound
+		}
+		if err != nil {
+			return nil, err
+		}
+		var old Item
+		if err := json.Unmarshal([]byte(oldJSON), &old); err != nil {
+			return nil, err
+		}
+		old.ID = itemID
+		item, destructive, err := update(old)
+		if err != nil {
+			return nil, err
+		}
+		itemJSON, err := json.Marshal(item)
+		if err != nil {
+			return nil, err
+		}
+		err = script.Run(s.redis, s.keys(itemID, openItemsKey, "bids-"+itemID, externalIDsKey, sealedBidsKeyPrefix+itemID, archivedKey), oldJSON, string(itemJSON), itemID, destructive, old.ExternalID, item.ExternalID).Err()
+		if err != nil && err.Error() == "CHANGED" {
+			continue
+		}
+		if err != nil {
+			return nil, scriptError(err)
+		}
+		return &item, nil
+	}
+}
+
+func (s *redisStore) DeleteItem(itemID string) error {
+	script := s.newScript(`
+local itemKey = KEYS[1]
+local allItemsKey = KEYS[2]
+local openItemsKey = KEYS[3]
+local bidsKey = KEYS[4]
+local externalIdsKey = KEYS[5]
+local sealedBidsKey = KEYS[6]
+local queueKey = KEYS[7]
+local openingsKey = KEYS[8]
+local archivedKey = KEYS[9]
+local itemId = ARGV[1]
+` + liveScript + `
+local stored = redis.call("GET", itemKey)
+if not stored then
+	return redis.error_reply("NOITEM no such item exists")
+end
+if redis.call("SISMEMBER", openItemsKey, itemId) == 1 then
+	return redis.error_reply("REJECTED the item cannot be deleted while it is open")
+end
+if redis.call("LLEN", bidsKey) > 0 or redis.call("HLEN", sealedBidsKey) > 0 then
+	return redis.error_reply("REJECTED the item cannot be deleted once it has bids")
+end
+for _, queued in ipairs(redis.call("LRANGE", queueKey, 0, -1)) do
+	if queued == itemId then
+		return redis.error_reply("REJECTED the item cannot be deleted while it is queued")
+	end
+end
+if redis.call("ZSCORE", openingsKey, itemId) then
+	return redis.error_reply("REJECTED the item cannot be deleted while it is scheduled to open")
+end
+local externalId = cjson.decode(stored).externalId
+if externalId and externalId ~= "" and redis.call("HGET", externalIdsKey, externalId) == itemId then
+	redis.call("HDEL", externalIdsKey, externalId)
+end
+redis.call("DEL", itemKey)
+redis.call("SREM", allItemsKey, itemId)
+return redis.status_reply("ok")
+`)
+	return scriptError(script.Run(s.redis, s.keys(itemID, allItemsKey, openItemsKey, "bids-"+itemID, externalIDsKey, sealedBidsKeyPrefix+itemID, queueKey, openingsKey, archivedKey), itemID).Err())
+}
+
+func (s *redisStore) GetItem(itemID string) (*Item, error) {
+	itemJSON, err := s.redis.Get(s.key(itemID)).Result()
+	if err == redis.Nil {
+		return nil, ErrItemNotFound
+	}
+	if err != nil {
+		// Other keys aren't items, even if someone asks for them by name.
+		if strings.HasPrefix(err.Error(), "WRONGTYPE") {
+			return nil, ErrItemNotFound
+		}
+		return nil, err
+	}
+	var item Item
+	if err := json.Unmarshal([]byte(itemJSON), &item); err != nil {
+		return nil, ErrItemNotFound
+	}
+	item.ID = itemID
+	return &item, nil
+}
+
+func (s *redisStore) GetItems() ([]Item, error) {
+	itemIDs, err := s.redis.SMembers(s.key(allItemsKey)).Result()
+	if err != nil {
+		return nil, err
+	}
+	if len(itemIDs) == 0 {
+		return []Item{}, nil
+	}
+	itemBlobs, err := s.redis.MGet(s.keys(itemIDs...)...).Result()
+	if err != nil {
+		return nil, err
+	}
+	items := make([]Item, 0, len(itemBlobs))
+	for i, b := range itemBlobs {
+		blob, ok := b.(string)
+		if !ok {
+			continue
+		}
+		var item Item
+		if err := json.Unmarshal([]byte(blob), &item); err != nil {
+			continue
+		}
+		item.ID = itemIDs[i]
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+func (s *redisStore) GetItemByExternalID(externalID string) (*Item, error) {
+	itemID, err := s.redis.HGet(s.key(externalIDsKey), externalID).Result()
+	if err == redis.Nil {
+		return nil, ErrItemNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return s.GetItem(itemID)
+}
+
+// openItemScript defines openItem, which opens an item for the scripts that do
+// so. Its keys are the ones OpenItem passes, in the same order.
+const openItemScript = `
+local function openItem(keys, itemId, deadline, event, silent, now, openableStates, expectedPreviousItemId)
+	local currentItemKey, deadlinesKey, eventLogKey, extensionsKey, openItemsKey, dutchPricesKey, priceDropsKey, itemKey, bidsKey, totalRaisedKey, previousItemKey, pausedDeadlinesKey, pausedPriceDropsKey, queueKey, openingsKey, previewedOpeningsKey = unpack(keys)
+	local stored = redis.call("GET", itemKey)
+	if not stored then
+		return redis.error_reply("NOITEM no such item exists")
+	end
+	local previousItemId = redis.call("GET", currentItemKey) or ""
+	if not silent and previousItemId ~= expectedPreviousItemId then
+		return redis.error_reply("CHANGED")
+	end
+	local item = cjson.decode(stored)
+	local from = itemState(item, redis.call("SISMEMBER", openItemsKey, itemId) == 1)
+	if from ~= "open" and not canMoveFrom(openableStates, from) then
+		return redis.error_reply("STATE " .. from .. " open")
+	end
+	if item.closed then
+		-- Reopening a sold item takes its price back off the total. Items closed
+		-- before outcomes were recorded were always counted.
+		if item.outcome == "sold" or item.outcome == nil then
+			local top = redis.call("LRANGE", bidsKey, -1, -1)
+			if table.getn(top) > 0 then
+				redis.call("DECRBY", totalRaisedKey, cjson.decode(top[1]).bid)
+			end
+		end
+	end
+	if item.state ~= "open" then
+		item.closed = false
+		item.outcome = nil
+		item.state = "open"
+		redis.call("SET", itemKey, cjson.encode(item))
+	end
+	if not silent then
+		if previousItemId ~= "" and previousItemId ~= itemId then
+			if redis.call("SISMEMBER", openItemsKey, previousItemId) == 1 then
+				local previousStored = redis.call("GET", previousItemKey)
+				if previousStored then
+					local previous = cjson.decode(previousStored)
+					local previousState = itemState(previous, true)
+					previous.closed = false
+					previous.outcome = nil
+					previous.state = "approved"
+					redis.call("SET", previousItemKey, cjson.encode(previous))
+					logEvent(eventLogKey, "itemId", previousItemId, "event", cjson.encode({event="stateChanged", itemId=previousItemId, from=previousState, to="approved"}))
+				end
+			end
+			redis.call("SREM", openItemsKey, previousItemId)
+			redis.call("ZREM", deadlinesKey, previousItemId)
+			redis.call("HDEL", extensionsKey, previousItemId)
+			redis.call("HDEL", dutchPricesKey, previousItemId)
+			redis.call("ZREM", priceDropsKey, previousItemId)
+			redis.call("HDEL", pausedDeadlinesKey, previousItemId)
+			redis.call("HDEL", pausedPriceDropsKey, previousItemId)
+		end
+		redis.call("SET", currentItemKey, itemId)
+	end
+	-- Reopening an open item mustn't put its price back up, and once an item
+	-- has been claimed its price no longer drops.
+	if item.mode == "dutch" and type(item.dutch) == "table" and from ~= "open" and redis.call("LLEN", bidsKey) == 0 then
+		redis.call("HSET", dutchPricesKey, itemId, item.dutch.startPrice)
+		redis.call("ZADD", priceDropsKey, now + item.dutch.interval * 1000, itemId)
+	end
+	redis.call("SADD", openItemsKey, itemId)
+	redis.call("HDEL", extensionsKey, itemId)
+	redis.call("HDEL", pausedDeadlinesKey, itemId)
+	redis.call("HDEL", pausedPriceDropsKey, itemId)
+	if deadline ~= "" then
+		redis.call("ZADD", deadlinesKey, deadline, itemId)
+	else
+		redis.call("ZREM", deadlinesKey, itemId)
+	end
+	logEvent(eventLogKey, "itemId", itemId, "event", event)
+	if from ~= "open" then
+		logEvent(eventLogKey, "itemId", itemId, "event", cjson.encode({event="stateChanged", itemId=itemId, from=from, to="open"}))
+	end
+	if redis.call("LREM", queueKey, 0, itemId) > 0 then
+		queueChanged(queueKey, eventLogKey, itemId)
+	end
+	redis.call("ZREM", openingsKey, itemId)
+	redis.call("SREM", previewedOpeningsKey, itemId)
+	return redis.status_reply("ok")
+end
+`
+
+// OpenItem is given the current item beforehand, so that the script can update
+// its state if the item being opened replaces it, and tries again if the
+// current item has changed since then.
+func (s *redisStore) OpenItem(itemID string, options OpenOptions, now time.Time) error {
+	event := OpenItemEvent{ItemID: itemID, Silent: options.Silent}
+	deadline := ""
+	if !options.Deadline.IsZero() {
+		event.Deadline = unixMillis(options.Deadline)
+		deadline = strconv.FormatInt(event.Deadline, 10)
+	}
+	eventJSON, err := eventJSON(event)
+	if err != nil {
+		return err
+	}
+	silent := "0"
+	if options.Silent {
+		silent = "1"
+	}
+	script := s.newScript(`
+redis.replicate_commands()
+local archivedKey = KEYS[17]
+` + liveScript + itemStateScript + queueScript + openItemScript + `
+return openItem(KEYS, ARGV[1], ARGV[2], ARGV[3], ARGV[4] == "1", tonumber(ARGV[5]), ARGV[6], ARGV[7])
+`)
+	for {
+		previousItemID, err := s.redis.Get(s.key(currentItemKey)).Result()
+		if err != nil && err != redis.Nil {
+			return err
+		}
+		previousItemKey := previousItemID
+		if previousItemKey == "" {
+			previousItemKey = itemID
+		}
+		keys := s.keys(currentItemKey, deadlinesKey, eventLogKey, deadlineExtensionsKey, openItemsKey, dutchPricesKey, priceDropsKey, itemID, "bids-"+itemID, totalRaisedKey, previousItemKey, pausedDeadlinesKey, pausedPriceDropsKey, queueKey, openingsKey, previewedOpeningsKey, archivedKey)
+		err = script.Run(s.redis, keys, itemID, deadline, eventJSON, silent, unixMillis(now), statesFrom(StateOpen), previousItemID).Err()
+		if err != nil && err.Error() == "CHANGED" {
+			continue
+		}
+		return scriptError(err)
+	}
+}
+
+// closeItemScript defines closeItem, which closes an item for the scripts that
+// do so. Its keys are the ones CloseItem passes, in the same order.
+const closeItemScript = `
+local function closeItem(keys, itemId, expiredBy)
+	local key, currentItemKey, deadlinesKey, extensionsKey, openItemsKey, bidsKey, sealedBidsKey, dutchPricesKey, priceDropsKey, totalRaisedKey, eventLogKey, pausedDeadlinesKey, pausedPriceDropsKey = unpack(keys)
+	if redis.call("SISMEMBER", openItemsKey, itemId) == 0 then
+		redis.call("ZREM", deadlinesKey, itemId)
+		if redis.call("EXISTS", key) == 0 then
+			return redis.error_reply("NOITEM no such item exists")
+		end
+		return redis.error_reply("CLOSED that item is not open")
+	end
+	if expiredBy ~= "" then
+		local deadline = redis.call("ZSCORE", deadlinesKey, itemId)
+		if not deadline or tonumber(deadline) > tonumber(expiredBy) then
+			return ""
+		end
+	end
+	local json = cjson.decode(redis.call("GET", key))
+	if json.mode == "sealed" then
+		-- Reveal the sealed bids, lowest first, so that the winner ends up on top
+		-- at the price they pay. Of equal bids, the earliest wins.
+		local sealed = {}
+		for _, blob in ipairs(redis.call("HVALS", sealedBidsKey)) do
+			table.insert(sealed, cjson.decode(blob))
+		end
+		table.sort(sealed, function(x, y)
+			if x.bid ~= y.bid then
+				return x.bid < y.bid
+			end
+			return x.placedAt > y.placedAt
+		end)
+		local count = table.getn(sealed)
+		if count > 0 then
+			local winner = sealed[count]
+			local price = winner.bid
+			if json.pricing == "second" then
+				if count > 1 then
+					price = sealed[count - 1].bid
+				else
+					price = tonumber(json.startBid) or 0
+				end
+				-- A winner who bid at least the reserve pays no less than it.
+				local reserve = tonumber(json.reserve) or 0
+				if winner.bid >= reserve then
+					price = math.max(price, reserve)
+				end
+			end
+			for i = 1, count - 1 do
+				sealed[i].placedAt = nil
+				redis.call("RPUSH", bidsKey, cjson.encode(sealed[i]))
+			end
+			winner.placedAt = nil
+			winner.sealedBid = winner.bid
+			winner.bid = price
+			redis.call("RPUSH", bidsKey, cjson.encode(winner))
+		end
+		redis.call("DEL", sealedBidsKey)
+	end
+	local outcome = "unsold"
+	local topBid = redis.call("LRANGE", bidsKey, -1, -1)
+	if table.getn(topBid) > 0 then
+		local price = cjson.decode(topBid[1]).bid
+		if price >= (tonumber(json.reserve) or 0) then
+			outcome = "sold"
+			redis.call("INCRBY", totalRaisedKey, price)
+		else
+			outcome = "reserveNotMet"
+		end
+	end
+	local from = itemState(json, true)
+	local to = "closed-unsold"
+	if outcome == "sold" then
+		to = "closed-sold"
+	end
+	json.closed = true
+	json.outcome = outcome
+	json.state = to
+	redis.call("SET", key, cjson.encode(json))
+	redis.call("SREM", openItemsKey, itemId)
+	if redis.call("GET", currentItemKey) == itemId then
+		redis.call("SET", currentItemKey, "")
+	end
+	redis.call("ZREM", deadlinesKey, itemId)
+	redis.call("HDEL", extensionsKey, itemId)
+	redis.call("HDEL", dutchPricesKey, itemId)
+	redis.call("ZREM", priceDropsKey, itemId)
+	redis.call("HDEL", pausedDeadlinesKey, itemId)
+	redis.call("HDEL", pausedPriceDropsKey, itemId)
+	logEvent(eventLogKey, "itemId", itemId, "event", cjson.encode({event="closeItem", itemId=itemId, outcome=outcome}))
+	logEvent(eventLogKey, "itemId", itemId, "event", cjson.encode({event="stateChanged", itemId=itemId, from=from, to=to}))
+	return outcome
+end
+`
+
+func (s *redisStore) CloseItem(itemID string, expiredBy time.Time) (string, error) {
+	expiry := ""
+	if !expiredBy.IsZero() {
+		expiry = strconv.FormatInt(unixMillis(expiredBy), 10)
+	}
+	script := s.newScript(`
+redis.replicate_commands()
+local archivedKey = KEYS[14]
+` + liveScript + itemStateScript + closeItemScript + `
+return closeItem(KEYS, ARGV[1], ARGV[2])
+`)
+	keys := s.keys(itemID, currentItemKey, deadlinesKey, deadlineExtensionsKey, openItemsKey, "bids-"+itemID, sealedBidsKeyPrefix+itemID, dutchPricesKey, priceDropsKey, totalRaisedKey, eventLogKey, pausedDeadlinesKey, pausedPriceDropsKey, archivedKey)
+	outcome, err := script.Run(s.redis, keys, itemID, expiry).Text()
+	if err != nil {
+		return "", scriptError(err)
+	}
+	return outcome, nil
+}
+
+// PauseItem moves the item's deadline and next price drop out of their
+// schedules, keeping how long they had to go, so that nothing happens to the
+// item until it is resumed.
+func (s *redisStore) PauseItem(itemID string, now time.Time) error {
+	script := s.newScript(`
+redis.replicate_commands()
+local itemKey = KEYS[1]
+local openItemsKey = KEYS[2]
+local deadlinesKey = KEYS[3]
+local priceDropsKey = KEYS[4]
+local pausedDeadlinesKey = KEYS[5]
+local pausedPriceDropsKey = KEYS[6]
+local eventLogKey = KEYS[7]
+local archivedKey = KEYS[8]
+local itemId = ARGV[1]
+local now = tonumber(ARGV[2])
+local pausableStates = ARGV[3]
+` + liveScript + itemStateScript + `
+local stored = redis.call("GET", itemKey)
+if not stored then
+	return redis.error_reply("NOITEM no such item exists")
+end
+if redis.call("SISMEMBER", openItemsKey, itemId) == 0 then
+	return redis.error_reply("CLOSED that item isn't open for bidding")
+end
+local item = cjson.decode(stored)
+local from = itemState(item, true)
+if not canMoveFrom(pausableStates, from) then
+	return redis.error_reply("STATE " .. from .. " paused")
+end
+local event = {event="pauseItem", itemId=itemId}
+local deadline = redis.call("ZSCORE", deadlinesKey, itemId)
+if deadline then
+	local remaining = math.max(0, tonumber(deadline) - now)
+	if remaining > 0 then
+		event.remaining = remaining
+	end
+	redis.call("HSET", pausedDeadlinesKey, itemId, remaining)
+	redis.call("ZREM", deadlinesKey, itemId)
+end
+local nextDrop = redis.call("ZSCORE", priceDropsKey, itemId)
+if nextDrop then
+	redis.call("HSET", pausedPriceDropsKey, itemId, math.max(0, tonumber(nextDrop) - now))
+	redis.call("ZREM", priceDropsKey, itemId)
+end
+item.state = "paused"
+redis.call("SET", itemKey, cjson.encode(item))
+logEvent(eventLogKey, "itemId", itemId, "event", cjson.encode(event))
+logEvent(eventLogKey, "itemId", itemId, "event", cjson.encode({event="stateChanged", itemId=itemId, from=from, to="paused"}))
+return redis.status_reply("ok")
+`)
+	keys := s.keys(itemID, openItemsKey, deadlinesKey, priceDropsKey, pausedDeadlinesKey, pausedPriceDropsKey, eventLogKey, archivedKey)
+	return scriptError(script.Run(s.redis, keys, itemID, unixMillis(now), statesFrom(StatePaused)).Err())
+}
+
+func (s *redisStore) ResumeItem(itemID string, now time.Time) error {
+	script := s.newScript(`
+redis.replicate_commands()
+local itemKey = KEYS[1]
+local openItemsKey = KEYS[2]
+local deadlinesKey = KEYS[3]
+local priceDropsKey = KEYS[4]
+local pausedDeadlinesKey = KEYS[5]
+local pausedPriceDropsKey = KEYS[6]
+local eventLogKey = KEYS[7]
+local archivedKey = KEYS[8]
+local itemId = ARGV[1]
+local now = tonumber(ARGV[2])
+` + liveScript + `
+local stored = redis.call("GET", itemKey)
+if not stored then
+	return redis.error_reply("NOITEM no such item exists")
+end
+if redis.call("SISMEMBER", openItemsKey, itemId) == 0 then
+	return redis.error_reply("CLOSED that item isn't open for bidding")
+end
+local item = cjson.decode(stored)
+if item.state ~= "paused" then
+	return redis.error_reply("REJECTED bidding on that item isn't paused")
+end
+local event = {event="resumeItem", itemId=itemId}
+local remaining = redis.call("HGET", pausedDeadlinesKey, itemId)
+if remaining then
+	event.deadline = now + tonumber(remaining)
+	redis.call("ZADD", deadlinesKey, event.deadline, itemId)
+	redis.call("HDEL", pausedDeadlinesKey, itemId)
+end
+local untilDrop = redis.call("HGET", pausedPriceDropsKey, itemId)
+if untilDrop then
+	redis.call("ZADD", priceDropsKey, now + tonumber(untilDrop), itemId)
+	redis.call("HDEL", pausedPriceDropsKey, itemId)
+end
+item.state = "open"
+redis.call("SET", itemKey, cjson.encode(item))
+logEvent(eventLogKey, "itemId", itemId, "event", cjson.encode(event))
+logEvent(eventLogKey, "itemId", itemId, "event", cjson.encode({event="stateChanged", itemId=itemId, from="paused", to="open"}))
+return redis.status_reply("ok")
+`)
+	keys := s.keys(itemID, openItemsKey, deadlinesKey, priceDropsKey, pausedDeadlinesKey, pausedPriceDropsKey, eventLogKey, archivedKey)
+	return scriptError(script.Run(s.redis, keys, itemID, unixMillis(now)).Err())
+}
+
+func (s *redisStore) SetItemState(itemID, state string) error {
+	script := s.newScript(`
+redis.replicate_commands()
+local itemKey = KEYS[1]
+local openItemsKey = KEYS[2]
+local bidsKey = KEYS[3]
+local totalRaisedKey = KEYS[4]
+local eventLogKey = KEYS[5]
+local queueKey = KEYS[6]
+local openingsKey = KEYS[7]
+local previewedOpeningsKey = KEYS[8]
+local archivedKey = KEYS[9]
+local itemId = ARGV[1]
+local to = ARGV[2]
+local allowedFrom = ARGV[3]
+` + liveScript + itemStateScript + queueScript + `
+local stored = redis.call("GET", itemKey)
+if not stored then
+	return redis.error_reply("NOITEM no such item exists")
+end
+local item = cjson.decode(stored)
+local open = redis.call("SISMEMBER", openItemsKey, itemId) == 1
+local from = itemState(item, open)
+-- Items that are up for auction have to be closed first.
+if open or not canMoveFrom(allowedFrom, from) then
+	return redis.error_reply("STATE " .. from .. " " .. to)
+end
+if to == "voided" and (from == "closed-sold" or from == "paid" or from == "shipped") then
+	local top = redis.call("LRANGE", bidsKey, -1, -1)
+	if table.getn(top) > 0 then
+		redis.call("DECRBY", totalRaisedKey, cjson.decode(top[1]).bid)
+	end
+end
+if to == "draft" or to == "approved" or to == "scheduled" then
+	item.closed = false
+	item.outcome = nil
+end
+item.state = to
+redis.call("SET", itemKey, cjson.encode(item))
+logEvent(eventLogKey, "itemId", itemId, "event", cjson.encode({event="stateChanged", itemId=itemId, from=from, to=to}))
+if redis.call("LREM", queueKey, 0, itemId) > 0 then
+	queueChanged(queueKey, eventLogKey, itemId)
+end
+if redis.call("ZREM", openingsKey, itemId) > 0 then
+	redis.call("SREM", previewedOpeningsKey, itemId)
+	logEvent(eventLogKey, "itemId", itemId, "event", cjson.encode({event="scheduled", itemId=itemId}))
+end
+return redis.status_reply("ok")
+`)
+	keys := s.keys(itemID, openItemsKey, "bids-"+itemID, totalRaisedKey, eventLogKey, queueKey, openingsKey, previewedOpeningsKey, archivedKey)
+	return scriptError(script.Run(s.redis, keys, itemID, state, statesFrom(state)).Err())
+}
+
+// queueScript defines the functions that the scripts which change the run queue
+// share. queueChanged logs a QueueChangedEvent, and enqueue puts an item into
+// the queue at a position counting from zero, or at the end if the position is
+// negative or past it.
+const queueScript = `
+local function queueChanged(queueKey, eventLogKey, itemId)
+	local event = {event="queueChanged", itemId=itemId}
+	local queue = redis.call("LRANGE", queueKey, 0, -1)
+	if table.getn(queue) > 0 then
+		event.queue = queue
+	end
+	logEvent(eventLogKey, "itemId", itemId, "event", cjson.encode(event))
+end
+
+local function enqueue(queueKey, itemId, position)
+	local pivot = false
+	if position >= 0 then
+		pivot = redis.call("LINDEX", queueKey, position)
+	end
+	if pivot then
+		redis.call("LINSERT", queueKey, "BEFORE", pivot, itemId)
+	else
+		redis.call("RPUSH", queueKey, itemId)
+	end
+end
+`
+
+func (s *redisStore) Queue() ([]string, error) {
+	return s.redis.LRange(s.key(queueKey), 0, -1).Result()
+}
+
+func (s *redisStore) QueueItem(itemID string, position int) error {
+	script := s.newScript(`
+redis.replicate_commands()
+local itemKey = KEYS[1]
+local openItemsKey = KEYS[2]
+local queueKey = KEYS[3]
+local eventLogKey = KEYS[4]
+local openingsKey = KEYS[5]
+local archivedKey = KEYS[6]
+local itemId = ARGV[1]
+local position = tonumber(ARGV[2])
+local queueableStates = ARGV[3]
+` + liveScript + itemStateScript + queueScript + `
+local stored = redis.call("GET", itemKey)
+if not stored then
+	return redis.error_reply("NOITEM no such item exists")
+end
+-- Items scheduled to open at a time are already in the scheduled state, and
+-- can go into the queue as well.
+local queued = false
+for _, queuedItemId in ipairs(redis.call("LRANGE", queueKey, 0, -1)) do
+	if queuedItemId == itemId then
+		queued = true
+	end
+end
+if queued or not redis.call("ZSCORE", openingsKey, itemId) then
+	local item = cjson.decode(stored)
+	local from = itemState(item, redis.call("SISMEMBER", openItemsKey, itemId) == 1)
+	if not canMoveFrom(queueableStates, from) then
+		return redis.error_reply("STATE " .. from .. " scheduled")
+	end
+	item.closed = false
+	item.outcome = nil
+	item.state = "scheduled"
+	redis.call("SET", itemKey, cjson.encode(item))
+	logEvent(eventLogKey, "itemId", itemId, "event", cjson.encode({event="stateChanged", itemId=itemId, from=from, to="scheduled"}))
+end
+enqueue(queueKey, itemId, position)
+queueChanged(queueKey, eventLogKey, itemId)
+return redis.status_reply("ok")
+`)
+	keys := s.keys(itemID, openItemsKey, queueKey, eventLogKey, openingsKey, archivedKey)
+	return scriptError(script.Run(s.redis, keys, itemID, position, statesFrom(StateScheduled)).Err())
+}
+
+func (s *redisStore) MoveQueuedItem(itemID string, position int) error {
+	script := s.newScript(`
+redis.replicate_commands()
+local itemKey = KEYS[1]
+local queueKey = KEYS[2]
+local eventLogKey = KEYS[3]
+local archivedKey = KEYS[4]
+local itemId = ARGV[1]
+local position = tonumber(ARGV[2])
+` + liveScript + queueScript + `
+if redis.call("EXISTS", itemKey) == 0 then
+	return redis.error_reply("NOITEM no such item exists")
+end
+if redis.call("LREM", queueKey, 0, itemId) == 0 then
+	return redis.error_reply("REJECTED that item isn't in the queue")
+end
+enqueue(queueKey, itemId, position)
+queueChanged(queueKey, eventLogKey, itemId)
+return redis.status_reply("ok")
+`)
+	keys := s.keys(itemID, queueKey, eventLogKey, archivedKey)
+	return scriptError(script.Run(s.redis, keys, itemID, position).Err())
+}
+
+func (s *redisStore) UnqueueItem(itemID string) error {
+	script := s.newScript(`
+redis.replicate_commands()
+local itemKey = KEYS[1]
+local openItemsKey = KEYS[2]
+local queueKey = KEYS[3]
+local eventLogKey = KEYS[4]
+local openingsKey = KEYS[5]
+local archivedKey = KEYS[6]
+local itemId = ARGV[1]
+` + liveScript + itemStateScript + queueScript + `
+local stored = redis.call("GET", itemKey)
+if not stored then
+	return redis.error_reply("NOITEM no such item exists")
+end
+if redis.call("LREM", queueKey, 0, itemId) == 0 then
+	return redis.error_reply("REJECTED that item isn't in the queue")
+end
+local item = cjson.decode(stored)
+local from = itemState(item, redis.call("SISMEMBER", openItemsKey, itemId) == 1)
+if from == "scheduled" and not redis.call("ZSCORE", openingsKey, itemId) then
+	item.state = "approved"
+	redis.call("SET", itemKey, cjson.encode(item))
+	logEvent(eventLogKey, "itemId", itemId, "event", cjson.encode({event="stateChanged", itemId=itemId, from=from, to="approved"}))
+end
+queueChanged(queueKey, eventLogKey, itemId)
+return redis.status_reply("ok")
+`)
+	keys := s.keys(itemID, openItemsKey, queueKey, eventLogKey, openingsKey, archivedKey)
+	return scriptError(script.Run(s.redis, keys, itemID).Err())
+}
+
+func (s *redisStore) ScheduleOpening(itemID string, at time.Time) error {
+	eventJSON, err := eventJSON(ItemScheduledEvent{ItemID: itemID, OpensAt: unixMillis(at)})
+	if err != nil {
+		return err
+	}
+	script := s.newScript(`
+redis.replicate_commands()
+local itemKey = KEYS[1]
+local openItemsKey = KEYS[2]
+local openingsKey = KEYS[3]
+local previewedOpeningsKey = KEYS[4]
+local eventLogKey = KEYS[5]
+local archivedKey = KEYS[6]
+local itemId = ARGV[1]
+local at = ARGV[2]
+local event = ARGV[3]
+local schedulableStates = ARGV[4]
+` + liveScript + itemStateScript + `
+local stored = redis.call("GET", itemKey)
+if not stored then
+	return redis.error_reply("NOITEM no such item exists")
+end
+local item = cjson.decode(stored)
+local from = itemState(item, redis.call("SISMEMBER", openItemsKey, itemId) == 1)
+if from ~= "scheduled" then
+	if not canMoveFrom(schedulableStates, from) then
+		return redis.error_reply("STATE " .. from .. " scheduled")
+	end
+	item.closed = false
+	item.outcome = nil
+	item.state = "scheduled"
+	redis.call("SET", itemKey, cjson.encode(item))
+	logEvent(eventLogKey, "itemId", itemId, "event", cjson.encode({event="stateChanged", itemId=itemId, from=from, to="scheduled"}))
+end
+redis.call("ZADD", openingsKey, at, itemId)
+redis.call("SREM", previewedOpeningsKey, itemId)
+logEvent(eventLogKey, "itemId", itemId, "event", event)
+return redis.status_reply("ok")
+`)
+	keys := s.keys(itemID, openItemsKey, openingsKey, previewedOpeningsKey, eventLogKey, archivedKey)
+	return scriptError(script.Run(s.redis, keys, itemID, unixMillis(at), eventJSON, statesFrom(StateScheduled)).Err())
+}
+
+func (s *redisStore) CancelOpening(itemID string) error {
+	script := s.newScript(`
+redis.replicate_commands()
+local itemKey = KEYS[1]
+local openItemsKey = KEYS[2]
+local openingsKey = KEYS[3]
+local previewedOpeningsKey = KEYS[4]
+local queueKey = KEYS[5]
+local eventLogKey = KEYS[6]
+local archivedKey = KEYS[7]
+local itemId = ARGV[1]
+` + liveScript + itemStateScript + `
+local stored = redis.call("GET", itemKey)
+if not stored then
+	return redis.error_reply("NOITEM no such item exists")
+end
+if redis.call("ZREM", openingsKey, itemId) == 0 then
+	return redis.error_reply("REJECTED that item isn't scheduled to open")
+end
+redis.call("SREM", previewedOpeningsKey, itemId)
+logEvent(eventLogKey, "itemId", itemId, "event", cjson.encode({event="scheduled", itemId=itemId}))
+for _, queued in ipairs(redis.call("LRANGE", queueKey, 0, -1)) do
+	if queued == itemId then
+		return redis.status_reply("ok")
+	end
+end
+local item = cjson.decode(stored)
+local from = itemState(item, redis.call("SISMEMBER", openItemsKey, itemId) == 1)
+if from == "scheduled" then
+	item.state = "approved"
+	redis.call("SET", itemKey, cjson.encode(item))
+	logEvent(eventLogKey, "itemId", itemId, "event", cjson.encode({event="stateChanged", itemId=itemId, from=from, to="approved"}))
+end
+return redis.status_reply("ok")
+`)
+	keys := s.keys(itemID, openItemsKey, openingsKey, previewedOpeningsKey, queueKey, eventLogKey, archivedKey)
+	return scriptError(script.Run(s.redis, keys, itemID).Err())
+}
+
+func (s *redisStore) Openings() ([]Opening, error) {
+	scheduled, err := s.redis.ZRangeWithScores(s.key(openingsKey), 0, -1).Result()
+	if err != nil {
+		return nil, err
+	}
+	previewed, err := s.redis.SMembers(s.key(previewedOpeningsKey)).Result()
+	if err != nil {
+		return nil, err
+	}
+	wasPreviewed := map[string]bool{}
+	for _, itemID := range previewed {
+		wasPreviewed[itemID] = true
+	}
+	openings := make([]Opening, 0, len(scheduled))
+	for _, z := range scheduled {
+		itemID, ok := z.Member.(string)
+		if !ok {
+			continue
+		}
+		openings = append(openings, Opening{ItemID: itemID, At: fromUnixMillis(int64(z.Score)), Previewed: wasPreviewed[itemID]})
+	}
+	return openings, nil
+}
+
+func (s *redisStore) PreviewOpening(itemID string, by time.Time) (bool, error) {
+	script := s.newScript(`
+redis.replicate_commands()
+local openingsKey = KEYS[1]
+local previewedOpeningsKey = KEYS[2]
+local eventLogKey = KEYS[3]
+local archivedKey = KEYS[4]
+local itemId = ARGV[1]
+local by = tonumber(ARGV[2])
+` + liveScript + `
+local at = tonumber(redis.call("ZSCORE", openingsKey, itemId))
+if not at or at > by or redis.call("SADD", previewedOpeningsKey, itemId) == 0 then
+	return 0
+end
+logEvent(eventLogKey, "itemId", itemId, "event", cjson.encode({event="scheduled", itemId=itemId, opensAt=at, reminder=true}))
+return 1
+`)
+	keys := s.keys(openingsKey, previewedOpeningsKey, eventLogKey, archivedKey)
+	previewed, err := script.Run(s.redis, keys, itemID, unixMillis(by)).Int()
+	if err != nil {
+		return false, scriptError(err)
+	}
+	return previewed == 1, nil
+}
+
+// OpenScheduledItem is given the current item beforehand, as OpenItem is.
+func (s *redisStore) OpenScheduledItem(itemID string, now time.Time) (bool, error) {
+	eventJSON, err := eventJSON(OpenItemEvent{ItemID: itemID})
+	if err != nil {
+		return false, err
+	}
+	script := s.newScript(`
+redis.replicate_commands()
+local openingsKey = KEYS[15]
+local archivedKey = KEYS[17]
+local itemId = ARGV[1]
+local now = tonumber(ARGV[2])
+` + liveScript + itemStateScript + queueScript + openItemScript + `
+local at = tonumber(redis.call("ZSCORE", openingsKey, itemId))
+if not at or at > now then
+	return 0
+end
+local opened = openItem(KEYS, itemId, "", ARGV[3], false, now, ARGV[4], ARGV[5])
+if type(opened) == "table" and opened.err then
+	return opened
+end
+return 1
+`)
+	for {
+		previousItemID, err := s.redis.Get(s.key(currentItemKey)).Result()
+		if err != nil && err != redis.Nil {
+			return false, err
+		}
+		previousItemKey := previousItemID
+		if previousItemKey == "" {
+			previousItemKey = itemID
+		}
+		keys := s.keys(currentItemKey, deadlinesKey, eventLogKey, deadlineExtensionsKey, openItemsKey, dutchPricesKey, priceDropsKey, itemID, "bids-"+itemID, totalRaisedKey, previousItemKey, pausedDeadlinesKey, pausedPriceDropsKey, queueKey, openingsKey, previewedOpeningsKey, archivedKey)
+		opened, err := script.Run(s.redis, keys, itemID, unixMillis(now), eventJSON, statesFrom(StateOpen), previousItemID).Int()
+		if err != nil && err.Error() == "CHANGED" {
+			continue
+		}
+		if err != nil {
+			return false, scriptError(err)
+		}
+		return opened == 1, nil
+	}
+}
+
+// NextItem is given the current item and the front of the queue beforehand,
+// for the keys of the items it closes and opens, and tries again if either has
+// changed since then. It checks that the next item can be opened before
+// closing the current one, since a script's changes can't be undone.
+func (s *redisStore) NextItem(closeCurrent bool, deadline time.Time, now time.Time) (string, error) {
+	script := s.newScript(`
+redis.replicate_commands()
+local currentItemKey = KEYS[1]
+local queueKey = KEYS[2]
+local openItemsKey = KEYS[3]
+local closeKeys = {unpack(KEYS, 4, 16)}
+local openKeys = {unpack(KEYS, 17, 32)}
+local archivedKey = KEYS[33]
+local expectedCurrentItemId = ARGV[1]
+local expectedNextItemId = ARGV[2]
+local closeCurrent = ARGV[3] == "1"
+local deadline = ARGV[4]
+local event = ARGV[5]
+local now = tonumber(ARGV[6])
+local openableStates = ARGV[7]
+` + liveScript + itemStateScript + queueScript + closeItemScript + openItemScript + `
+local currentItemId = redis.call("GET", currentItemKey) or ""
+local nextItemId = redis.call("LINDEX", queueKey, 0) or ""
+if currentItemId ~= expectedCurrentItemId or nextItemId ~= expectedNextItemId then
+	return redis.error_reply("CHANGED")
+end
+if nextItemId == "" then
+	return redis.error_reply("EMPTY there are no items in the queue")
+end
+if currentItemId ~= "" and not closeCurrent then
+	return redis.error_reply("REJECTED another item is already up for auction")
+end
+local stored = redis.call("GET", openKeys[8])
+if not stored then
+	return redis.error_reply("NOITEM no such item exists")
+end
+local from = itemState(cjson.decode(stored), redis.call("SISMEMBER", openItemsKey, nextItemId) == 1)
+if from ~= "open" and not canMoveFrom(openableStates, from) then
+	return redis.error_reply("STATE " .. from .. " open")
+end
+if currentItemId ~= "" then
+	local closed = closeItem(closeKeys, currentItemId, "")
+	if type(closed) == "table" and closed.err then
+		return closed
+	end
+end
+return openItem(openKeys, nextItemId, deadline, event, false, now, openableStates, "")
+`)
+	for {
+		currentItemID, err := s.redis.Get(s.key(currentItemKey)).Result()
+		if err != nil && err != redis.Nil {
+			return "", err
+		}
+		nextItemID, err := s.redis.LIndex(s.key(queueKey), 0).Result()
+		if err == redis.Nil {
+			// The script isn't run for an empty queue, so it can't say
+			// whether the auction has been archived.
+			archived, err := s.Archived()
+			if err != nil {
+				return "", err
+			}
+			if archived {
+				return "", ErrAuctionArchived
+			}
+			return "", ErrQueueEmpty
+		}
+		if err != nil {
+			return "", err
+		}
+		event := OpenItemEvent{ItemID: nextItemID}
+		deadlineMillis := ""
+		if !deadline.IsZero() {
+			event.Deadline = unixMillis(deadline)
+			deadlineMillis = strconv.FormatInt(event.Deadline, 10)
+		}
+		eventJSON, err := eventJSON(event)
+		if err != nil {
+			return "", err
+		}
+		closing := currentItemID
+		if closing == "" {
+			closing = nextItemID
+		}
+		keys := s.keys(currentItemKey, queueKey, openItemsKey,
+			closing, currentItemKey, deadlinesKey, deadlineExtensionsKey, openItemsKey, "bids-"+closing, sealedBidsKeyPrefix+closing, dutchPricesKey, priceDropsKey, totalRaisedKey, eventLogKey, pausedDeadlinesKey, pausedPriceDropsKey,
+			currentItemKey, deadlinesKey, eventLogKey, deadlineExtensionsKey, openItemsKey, dutchPricesKey, priceDropsKey, nextItemID, "bids-"+nextItemID, totalRaisedKey, nextItemID, pausedDeadlinesKey, pausedPriceDropsKey, queueKey, openingsKey, previewedOpeningsKey, archivedKey)
+		shouldClose := "0"
+		if closeCurrent {
+			shouldClose = "1"
+		}
+		err = script.Run(s.redis, keys, currentItemID, nextItemID, shouldClose, deadlineMillis, eventJSON, unixMillis(now), statesFrom(StateOpen)).Err()
+		if err != nil && err.Error() == "CHANGED" {
+			continue
+		}
+		if err != nil {
+			return "", scriptError(err)
+		}
+		return nextItemID, nil
+	}
+}
+
+// liveScript refuses to go on with a script that would change the auction once
+// it has been archived. The script has to define archivedKey first.
+const liveScript = `
+if redis.call("EXISTS", archivedKey) == 1 then
+	return redis.error_reply("ARCHIVED the auction has been archived")
+end
+`
+
+func (s *redisStore) Archive() error {
+	script := s.newScript(`
+redis.replicate_commands()
+local openItemsKey = KEYS[1]
+local queueKey = KEYS[2]
+local openingsKey = KEYS[3]
+local archivedKey = KEYS[4]
+local eventLogKey = KEYS[5]
+if redis.call("SCARD", openItemsKey) > 0 then
+	return redis.error_reply("REJECTED the auction can't be archived while items are open")
+end
+if redis.call("LLEN", queueKey) > 0 then
+	return redis.error_reply("REJECTED the auction can't be archived while items are queued")
+end
+if redis.call("ZCARD", openingsKey) > 0 then
+	return redis.error_reply("REJECTED the auction can't be archived while items are scheduled to open")
+end
+if redis.call("SETNX", archivedKey, "1") == 1 then
+	logEvent(eventLogKey, "itemId", "", "event", cjson.encode({event="archived"}))
+end
+return redis.status_reply("ok")
+`)
+	keys := s.keys(openItemsKey, queueKey, openingsKey, archivedKey, eventLogKey)
+	return scriptError(script.Run(s.redis, keys).Err())
+}
+
+func (s *redisStore) Archived() (bool, error) {
+	n, err := s.redis.Exists(s.key(archivedKey)).Result()
+	return n > 0, err
+}
+
+func (s *redisStore) CurrentItemID() (string, error) {
+	itemID, err := s.redis.Get(s.key(currentItemKey)).Result()
+	if err == redis.Nil || (err == nil && itemID == "") {
+		return "", ErrNoCurrentItem
+	}
+	return itemID, err
+}
+
+func (s *redisStore) OpenItemIDs() ([]string, error) {
+	return s.redis.SMembers(s.key(openItemsKey)).Result()
+}
+
+func (s *redisStore) IsOpen(itemID string) bool {
+	return s.redis.SIsMember(s.key(openItemsKey), itemID).Val()
+}
+
+func (s *redisStore) PlaceBid(bid BidRequest) error {
+	mode := "bid"
+	if bid.Max {
+		mode = "max"
+	} else if bid.Take {
+		mode = "take"
+	}
+	script := s.newScript(bidScript)
+	keys := s.keys("bids-"+bid.ItemID, eventLogKey, bid.ItemID, deadlinesKey, deadlineExtensionsKey, maxBidsKeyPrefix+bid.ItemID, openItemsKey, sealedBidsKeyPrefix+bid.ItemID, dutchPricesKey, priceDropsKey, bidRulesKey,
+		bid.ItemID, currentItemKey, deadlinesKey, deadlineExtensionsKey, openItemsKey, "bids-"+bid.ItemID, sealedBidsKeyPrefix+bid.ItemID, dutchPricesKey, priceDropsKey, totalRaisedKey, eventLogKey, pausedDeadlinesKey, pausedPriceDropsKey, archivedKey)
+	return scriptError(script.Run(s.redis, keys, mode, strconv.Itoa(bid.Cents), bid.Bidder, bid.DisplayName, bid.BidID, bid.ItemID, unixMillis(bid.Now)).Err())
+}
+
+// bidScript places a bid, or sets a bidder's maximum bid, on the item, then
+// lets any maximum bids outbid the leader until none can. All of the
+// resulting bids are pushed to the item's bid list and published, and the
+// deadline is extended if a bid was placed inside the soft close window. The
+// bid rules are checked here too, so that they can't change mid-bid. A bid that
+// claims a dutch auction item closes it too, with the keys CloseItem passes.
+const bidScript = `
+redis.replicate_commands()
+local bidKey = KEYS[1]
+local eventLogKey = KEYS[2]
+local itemKey = KEYS[3]
+local deadlinesKey = KEYS[4]
+local extensionsKey = KEYS[5]
+local maxBidsKey = KEYS[6]
+local openItemsKey = KEYS[7]
+local sealedBidsKey = KEYS[8]
+local dutchPricesKey = KEYS[9]
+local priceDropsKey = KEYS[10]
+local bidRulesKey = KEYS[11]
+local closeKeys = {unpack(KEYS, 12, 24)}
+local archivedKey = KEYS[25]
+local mode = ARGV[1]
+local bid = tonumber(ARGV[2])
+local bidder = ARGV[3]
+local bidderDisplayName = ARGV[4]
+local bidId = ARGV[5]
+local itemId = ARGV[6]
+local now = tonumber(ARGV[7])
+` + liveScript + itemStateScript + closeItemScript + `
+if redis.call("EXISTS", itemKey) == 0 then
+	return redis.error_reply("NOITEM no such item exists")
+end
+if redis.call("SISMEMBER", openItemsKey, itemId) == 0 then
+	return redis.error_reply("CLOSED that item isn't open for bidding")
+end
+local deadline = redis.call("ZSCORE", deadlinesKey, itemId)
+if deadline and tonumber(deadline) <= now then
+	return redis.error_reply("CLOSED bidding on this item has closed")
+end
+local item = cjson.decode(redis.call("GET", itemKey))
+if item.state == "paused" then
+	return redis.error_reply("PAUSED bidding is paused")
+end
+local startBid = tonumber(item.startBid) or 0
+
+local function dollars(cents)
+	return string.format("$%d.%02d", cents / 100, cents % 100)
+end
+
+-- The item's own rules override the global ones, which override the defaults.
+local rules = {increments={{increment=100}}, maxJump=0, cap=0}
+local function applyRules(r)
+	if type(r) ~= "table" then
+		return
+	end
+	if type(r.increments) == "table" and table.getn(r.increments) > 0 then
+		rules.increments = r.increments
+	end
+	if (tonumber(r.maxJump) or 0) > 0 then
+		rules.maxJump = tonumber(r.maxJump)
+	end
+	if (tonumber(r.cap) or 0) > 0 then
+		rules.cap = tonumber(r.cap)
+	end
+end
+local globalRules = redis.call("GET", bidRulesKey)
+if globalRules then
+	applyRules(cjson.decode(globalRules))
+end
+applyRules(item.rules)
+
+local function incrementAbove(amount)
+	for _, step in ipairs(rules.increments) do
+		local below = tonumber(step.below) or 0
+		if below == 0 or amount < below then
+			return tonumber(step.increment)
+		end
+	end
+	return tonumber(rules.increments[table.getn(rules.increments)].increment)
+end
+
+local function checkCap(amount)
+	if rules.cap > 0 and amount > rules.cap then
+		return string.format("bids on this item can't be more than %s", dollars(rules.cap))
+	end
+	return nil
+end
+
+if item.mode == "sealed" then
+	-- Sealed bids skip the usual rules, because bidders can't see each other's
+	-- bids. Bidders may replace their bid until bidding closes.
+	if mode ~= "bid" then
+		return redis.error_reply("REJECTED maximum bids can't be used on sealed-bid items")
+	end
+	local minimum = math.max(startBid, 1)
+	if bid < minimum then
+		return redis.error_reply(string.format("TOOLOW %d you must bid at least %s", minimum, dollars(minimum)))
+	end
+	local problem = checkCap(bid)
+	if problem then
+		return redis.error_reply("REJECTED " .. problem)
+	end
+	redis.call("HSET", sealedBidsKey, bidder, cjson.encode({bid=bid, bidder=bidder, bidderDisplayName=bidderDisplayName, id=bidId, itemId=itemId, placedAt=now}))
+	logEvent(eventLogKey, "itemId", itemId, "event", cjson.encode({event="sealedBid", itemId=itemId, bidCount=redis.call("HLEN", sealedBidsKey)}))
+	return redis.status_reply("ok")
+end
+
+if item.mode == "dutch" then
+	-- The first bid at or above the current price claims the item at that
+	-- price; "take" accepts whatever the current price is.
+	if mode == "max" then
+		return redis.error_reply("REJECTED maximum bids can't be used on dutch auction items")
+	end
+	if redis.call("LLEN", bidKey) > 0 then
+		return redis.error_reply("CLOSED this item has already been claimed")
+	end
+	local price = tonumber(redis.call("HGET", dutchPricesKey, itemId))
+	if not price then
+		return redis.error_reply("REJECTED this item has no current price")
+	end
+	if mode == "bid" and bid < price then
+		return redis.error_reply(string.format("TOOLOW %d the current price is %s", price, dollars(price)))
+	end
+	local record = {bid=price, bidder=bidder, bidderDisplayName=bidderDisplayName, id=bidId, itemId=itemId}
+	redis.call("RPUSH", bidKey, cjson.encode(record))
+	record.event = "bid"
+	logEvent(eventLogKey, "itemId", itemId, "event", cjson.encode(record))
+	local closed = closeItem(closeKeys, itemId, "")
+	if type(closed) == "table" and closed.err then
+		return closed
+	end
+	return redis.status_reply("ok")
+end
+
+local function topBid()
+	local currentBidInfo = redis.call("LRANGE", bidKey, -1, -1)
+	if table.getn(currentBidInfo) > 0 then
+		return cjson.decode(currentBidInfo[1])
+	end
+	return nil
+end
+
+local function minimumBid(top)
+	if top then
+		return top.bid + incrementAbove(top.bid)
+	end
+	return math.max(startBid, 1)
+end
+
+-- Bids on items with a reserve say whether the reserve has been met, without
+-- revealing what it is.
+local reserve = tonumber(item.reserve) or 0
+local lastBidId = nil
+local bidCount = 0
+local function pushBid(amount, who, displayName, proxy)
+	bidCount = bidCount + 1
+	local id = bidId
+	if bidCount > 1 then
+		id = bidId .. "-" .. bidCount
+	end
+	local record = {bid=amount, bidder=who, bidderDisplayName=displayName, id=id, itemId=itemId}
+	if proxy then
+		record.proxy = true
+	end
+	redis.call("RPUSH", bidKey, cjson.encode(record))
+	record.event = "bid"
+	if reserve > 0 then
+		record.reserveMet = amount >= reserve
+	end
+	logEvent(eventLogKey, "itemId", itemId, "event", cjson.encode(record))
+	lastBidId = id
+end
+
+local top = topBid()
+local problem = checkCap(bid)
+if problem then
+	return redis.error_reply("REJECTED " .. problem)
+end
+if mode == "bid" then
+	local minimum = minimumBid(top)
+	if not top and bid < minimum then
+		return redis.error_reply(string.format("TOOLOW %d the first bid must be at least the starting bid of %s", minimum, dollars(minimum)))
+	end
+	if top and bid < minimum then
+		return redis.error_reply(string.format("TOOLOW %d you must bid at least %s more than the previous high bid of %s", minimum, dollars(minimum - top.bid), dollars(top.bid)))
+	end
+	if rules.maxJump > 0 then
+		local base = startBid
+		if top then
+			base = top.bid
+		end
+		if bid - base > rules.maxJump then
+			return redis.error_reply(string.format("REJECTED you can't raise the bid by more than %s at once, so you can bid at most %s", dollars(rules.maxJump), dollars(base + rules.maxJump)))
+		end
+	end
+	pushBid(bid, bidder, bidderDisplayName, false)
+else
+	if top and top.bidder == bidder then
+		if bid < top.bid then
+			return redis.error_reply(string.format("TOOLOW %d your maximum bid can't be less than your current high bid of %s", top.bid, dollars(top.bid)))
+		end
+	else
+		local minimum = minimumBid(top)
+		if bid < minimum then
+			return redis.error_reply(string.format("TOOLOW %d your maximum bid must be at least %s", minimum, dollars(minimum)))
+		end
+	end
+	redis.call("HSET", maxBidsKey, bidder, cjson.encode({max=bid, bidder=bidder, bidderDisplayName=bidderDisplayName, setAt=now}))
+end
+
+-- Each round, the strongest maximum bid that isn't already winning outbids the
+-- leader by as little as it can, unless the leader's own maximum is stronger,
+-- in which case the leader's bid goes up just enough to stay ahead. A maximum
+-- that reaches the reserve price bids at least the reserve, so that it wins the
+-- item. Maximums set before the bid cap was lowered only count up to the cap.
+-- This settles quickly, but is capped anyway.
+for round = 1, 20 do
+	top = topBid()
+	local minimum = minimumBid(top)
+	local challenger = nil
+	local rival = 0
+	for _, blob in ipairs(redis.call("HVALS", maxBidsKey)) do
+		local m = cjson.decode(blob)
+		if rules.cap > 0 then
+			m.max = math.min(m.max, rules.cap)
+		end
+		if (not top or m.bidder ~= top.bidder) and m.max >= minimum then
+			if not challenger or m.max > challenger.max or (m.max == challenger.max and m.setAt < challenger.setAt) then
+				if challenger then
+					rival = math.max(rival, challenger.max)
+				end
+				challenger = m
+			else
+				rival = math.max(rival, m.max)
+			end
+		end
+	end
+	if not challenger then
+		break
+	end
+	local leader = nil
+	if top then
+		local leaderMax = redis.call("HGET", maxBidsKey, top.bidder)
+		if leaderMax then
+			leader = cjson.decode(leaderMax)
+			if rules.cap > 0 then
+				leader.max = math.min(leader.max, rules.cap)
+			end
+		end
+	end
+	if leader and (leader.max > challenger.max or (leader.max == challenger.max and leader.setAt <= challenger.setAt)) then
+		-- The leader's own maximum holds off the challenger.
+		local amount = math.min(leader.max, math.max(minimum, challenger.max + incrementAbove(challenger.max)))
+		if leader.max >= reserve then
+			amount = math.max(amount, reserve)
+		end
+		pushBid(amount, leader.bidder, leader.bidderDisplayName, true)
+	else
+		local opponent = rival
+		if top then
+			opponent = math.max(opponent, top.bid)
+		end
+		if leader then
+			opponent = math.max(opponent, leader.max)
+		end
+		local amount = math.min(challenger.max, math.max(minimum, opponent + incrementAbove(opponent)))
+		if challenger.max >= reserve then
+			amount = math.max(amount, reserve)
+		end
+		pushBid(amount, challenger.bidder, challenger.bidderDisplayName, true)
+	end
+end
+
+if deadline and lastBidId then
+	deadline = tonumber(deadline)
+	local softClose = item.softClose
+	if type(softClose) == "table" and deadline - now <= (tonumber(softClose.window) or 0) * 1000 then
+		local extended = tonumber(redis.call("HGET", extensionsKey, itemId) or "0")
+		local extension = (tonumber(softClose.extension) or 0) * 1000
+		local maxExtension = (tonumber(softClose.maxExtension) or 0) * 1000
+		if maxExtension > 0 then
+			extension = math.min(extension, maxExtension - extended)
+		end
+		if extension > 0 then
+			deadline = deadline + extension
+			redis.call("ZADD", deadlinesKey, deadline, itemId)
+			redis.call("HINCRBY", extensionsKey, itemId, extension)
+			logEvent(eventLogKey, "itemId", itemId, "event", cjson.encode({event="deadlineExtended", itemId=itemId, deadline=deadline, extendedBy=extension, bidId=lastBidId}))
+		end
+	end
+end
+return redis.status_reply("ok")`
+
+func (s *redisStore) BuyNow(bid BidRequest, cutoff float64) error {
+	script := s.newScript(buyNowScript)
+	keys := s.keys(bid.ItemID, "bids-"+bid.ItemID, openItemsKey, currentItemKey, deadlinesKey, deadlineExtensionsKey, totalRaisedKey, eventLogKey, archivedKey)
+	return scriptError(script.Run(s.redis, keys, bid.ItemID, bid.Bidder, bid.DisplayName, bid.BidID, strconv.FormatFloat(cutoff, 'f', -1, 64), unixMillis(bid.Now)).Err())
+}
+
+// buyNowScript records the winning bid at the buy-now price, closes the item
+// and adds the price to the total raised, all at once, so that nobody can bid
+// in between.
+const buyNowScript = `
+redis.replicate_commands()
+local itemKey = KEYS[1]
+local bidKey = KEYS[2]
+local openItemsKey = KEYS[3]
+local currentItemKey = KEYS[4]
+local deadlinesKey = KEYS[5]
+local extensionsKey = KEYS[6]
+local totalRaisedKey = KEYS[7]
+local eventLogKey = KEYS[8]
+local archivedKey = KEYS[9]
+local itemId = ARGV[1]
+local bidder = ARGV[2]
+local bidderDisplayName = ARGV[3]
+local bidId = ARGV[4]
+local cutoff = tonumber(ARGV[5])
+local now = tonumber(ARGV[6])
+` + liveScript + `
+
+if redis.call("EXISTS", itemKey) == 0 then
+	return redis.error_reply("NOITEM no such item exists")
+end
+if redis.call("SISMEMBER", openItemsKey, itemId) == 0 then
+	return redis.error_reply("CLOSED that item isn't open for bidding")
+end
+local deadline = redis.call("ZSCORE", deadlinesKey, itemId)
+if deadline and tonumber(deadline) <= now then
+	return redis.error_reply("CLOSED bidding on this item has closed")
+end
+local item = cjson.decode(redis.call("GET", itemKey))
+if item.state == "paused" then
+	return redis.error_reply("PAUSED bidding is paused")
+end
+local price = tonumber(item.buyNow) or 0
+if price <= 0 then
+	return redis.error_reply("REJECTED this item can't be bought now")
+end
+local top = redis.call("LRANGE", bidKey, -1, -1)
+if table.getn(top) > 0 and cjson.decode(top[1]).bid > price * cutoff then
+	return redis.error_reply("REJECTED bidding has gone too high to buy this item now")
+end
+
+local record = {bid=price, bidder=bidder, bidderDisplayName=bidderDisplayName, id=bidId, itemId=itemId, buyNow=true}
+redis.call("RPUSH", bidKey, cjson.encode(record))
+record.event = "bid"
+logEvent(eventLogKey, "itemId", itemId, "event", cjson.encode(record))
+
+-- Items in the open set with no state are open.
+local from = item.state
+if type(from) ~= "string" or from == "" then
+	from = "open"
+end
+item.closed = true
+item.outcome = "sold"
+item.state = "closed-sold"
+redis.call("SET", itemKey, cjson.encode(item))
+redis.call("SREM", openItemsKey, itemId)
+if redis.call("GET", currentItemKey) == itemId then
+	redis.call("SET", currentItemKey, "")
+end
+redis.call("ZREM", deadlinesKey, itemId)
+redis.call("HDEL", extensionsKey, itemId)
+redis.call("INCRBY", totalRaisedKey, price)
+logEvent(eventLogKey, "itemId", itemId, "event", cjson.encode({event="closeItem", itemId=itemId, outcome="sold", buyNow=true}))
+logEvent(eventLogKey, "itemId", itemId, "event", cjson.encode({event="stateChanged", itemId=itemId, from=from, to="closed-sold"}))
+return redis.status_reply("ok")`
+
+func (s *redisStore) DeleteBid(itemID, bidID string) error {
+	script := s.newScript(`
+redis.replicate_commands()
+local bidsKey = KEYS[1]
+local currentItemKey = KEYS[2]
+local totalRaisedKey = KEYS[3]
+local eventLogKey = KEYS[4]
+local itemKey = KEYS[5]
+local archivedKey = KEYS[6]
+local itemId = ARGV[1]
+local bidId = ARGV[2]
+` + liveScript + `
+local stored = redis.call("GET", itemKey)
+if stored and cjson.decode(stored).closed then
+	return redis.error_reply("REJECTED bids can't be deleted once the item has closed")
+end
+local bids = redis.call("LRANGE", bidsKey, 0, -1)
+for i, bid in ipairs(bids) do
+	local bidInfo = cjson.decode(bid)
+	if bidInfo.id == bidId then
+		redis.call("LREM", bidsKey, 0, bid)
+		-- if i == table.getn(bids) then
+		-- 	if redis.call("GET", currentItemKey) ~= itemId then
+		--		redis.call("DECRBY", totalRaisedKey, bidInfo.bid)
+		--	end
+		-- end
+		logEvent(eventLogKey, "itemId", itemId, "event", cjson.encode({event="deleteBid", itemId=itemId, bidId=bidId, bid=bidInfo.bid, bidder=bidInfo.bidder, bidderDisplayName=bidInfo.bidderDisplayName}))
+		return redis.status_reply("ok")
+	end
+end
+return redis.error_reply("NOBID no such bid exists")
+`)
+	return scriptError(script.Run(s.redis, s.keys("bids-"+itemID, currentItemKey, totalRaisedKey, eventLogKey, itemID, archivedKey), itemID, bidID).Err())
+}
+
+func (s *redisStore) GetBids(itemID string, n int) ([]Bid, error) {
+	result, err := s.redis.LRange(s.key("bids-"+itemID), int64(-n), -1).Result()
+	if err != nil {
+		return nil, err
+	}
+	bids := make([]Bid, 0, len(result))
+	for _, blob := range result {
+		var bid Bid
+		if err := json.Unmarshal([]byte(blob), &bid); err != nil {
+			continue
+		}
+		bids = append(bids, bid)
+	}
+	return bids, nil
+}
+
+func (s *redisStore) GetMaxBids(itemID string) ([]MaxBid, error) {
+	blobs, err := s.redis.HVals(s.key(maxBidsKeyPrefix + itemID)).Result()
+	if err != nil {
+		return nil, err
+	}
+	maxBids := make([]MaxBid, 0, len(blobs))
+	for _, blob := range blobs {
+		var maxBid MaxBid
+		if err := json.Unmarshal([]byte(blob), &maxBid); err != nil {
+			continue
+		}
+		maxBids = append(maxBids, maxBid)
+	}
+	return maxBids, nil
+}
+
+func (s *redisStore) GetMaxBid(itemID, bidder string) (*MaxBid, error) {
+	blob, err := s.redis.HGet(s.key(maxBidsKeyPrefix+itemID), bidder).Result()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var maxBid MaxBid
+	if err := json.Unmarshal([]byte(blob), &maxBid); err != nil {
+		return nil, err
+	}
+	return &maxBid, nil
+}
+
+func (s *redisStore) GetSealedBids(itemID string) ([]Bid, error) {
+	blobs, err := s.redis.HVals(s.key(sealedBidsKeyPrefix + itemID)).Result()
+	if err != nil {
+		return nil, err
+	}
+	bids := make([]Bid, 0, len(blobs))
+	for _, blob := range blobs {
+		var bid Bid
+		if err := json.Unmarshal([]byte(blob), &bid); err != nil {
+			continue
+		}
+		bids = append(bids, bid)
+	}
+	sort.Slice(bids, func(i, j int) bool {
+		return bids[i].BidCents < bids[j].BidCents
+	})
+	return bids, nil
+}
+
+func (s *redisStore) Deadline(itemID string) (time.Time, bool) {
+	score, err := s.redis.ZScore(s.key(deadlinesKey), itemID).Result()
+	if err != nil {
+		return time.Time{}, false
+	}
+	return fromUnixMillis(int64(score)), true
+}
+
+func (s *redisStore) SetDeadline(itemID string, deadline time.Time) error {
+	_, err := s.changeDeadline(itemID, "set", unixMillis(deadline))
+	return err
+}
+
+func (s *redisStore) ExtendDeadline(itemID string, by time.Duration) (time.Time, error) {
+	return s.changeDeadline(itemID, "extend", int64(by/time.Millisecond))
+}
+
+func (s *redisStore) CancelDeadline(itemID string) error {
+	_, err := s.changeDeadline(itemID, "cancel", 0)
+	return err
+}
+
+func (s *redisStore) changeDeadline(itemID string, operation string, millis int64) (time.Time, error) {
+	script := s.newScript(`
+redis.replicate_commands()
+local openItemsKey = KEYS[1]
+local deadlinesKey = KEYS[2]
+local eventLogKey = KEYS[3]
+local itemKey = KEYS[4]
+local archivedKey = KEYS[5]
+local itemId = ARGV[1]
+local operation = ARGV[2]
+local millis = tonumber(ARGV[3])
+` + liveScript + `
+if redis.call("SISMEMBER", openItemsKey, itemId) == 0 then
+	return redis.error_reply("CLOSED that item is not open")
+end
+local stored = redis.call("GET", itemKey)
+if stored and cjson.decode(stored).state == "paused" then
+	return redis.error_reply("REJECTED the deadline can't be changed while bidding is paused")
+end
+local deadline = redis.call("ZSCORE", deadlinesKey, itemId)
+if operation == "extend" then
+	if not deadline then
+		return redis.error_reply("REJECTED that item has no deadline to extend")
+	end
+	millis = tonumber(deadline) + millis
+end
+if operation == "cancel" then
+	redis.call("ZREM", deadlinesKey, itemId)
+	millis = 0
+else
+	redis.call("ZADD", deadlinesKey, millis, itemId)
+end
+logEvent(eventLogKey, "itemId", itemId, "event", cjson.encode({event="deadlineChanged", itemId=itemId, deadline=millis}))
+return millis
+`)
+	deadline, err := script.Run(s.redis, s.keys(openItemsKey, deadlinesKey, eventLogKey, itemID, archivedKey), itemID, operation, strconv.FormatInt(millis, 10)).Int64()
+	if err != nil {
+		return time.Time{}, scriptError(err)
+	}
+	if deadline == 0 {
+		return time.Time{}, nil
+	}
+	return fromUnixMillis(deadline), nil
+}
+
+func (s *redisStore) ExpiredItems(now time.Time) ([]string, error) {
+	return s.redis.ZRangeByScore(s.key(deadlinesKey), &redis.ZRangeBy{
+		Min: "-inf",
+		Max: strconv.FormatInt(unixMillis(now), 10),
+	}).Result()
+}
+
+func (s *redisStore) CurrentPrice(itemID string) (int, time.Time, error) {
+	price, err := s.redis.HGet(s.key(dutchPricesKey), itemID).Int()
+	if err == redis.Nil {
+		return 0, time.Time{}, errNoPrice
+	}
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+	nextDrop, err := s.redis.ZScore(s.key(priceDropsKey), itemID).Result()
+	if err == redis.Nil {
+		return price, time.Time{}, nil
+	}
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+	return price, fromUnixMillis(int64(nextDrop)), nil
+}
+
+func (s *redisStore) DuePriceDrops(now time.Time) ([]string, error) {
+	return s.redis.ZRangeByScore(s.key(priceDropsKey), &redis.ZRangeBy{
+		Min: "-inf",
+		Max: strconv.FormatInt(unixMillis(now), 10),
+	}).Result()
+}
+
+// DropPrice catches up on any drops that were missed while nothing was running,
+// and stops dropping the price once it reaches the floor.
+func (s *redisStore) DropPrice(itemID string, now time.Time) error {
+	script := s.newScript(`
+redis.replicate_commands()
+local itemKey = KEYS[1]
+local dutchPricesKey = KEYS[2]
+local priceDropsKey = KEYS[3]
+local eventLogKey = KEYS[4]
+local archivedKey = KEYS[5]
+local itemId = ARGV[1]
+local now = tonumber(ARGV[2])
+` + liveScript + `
+local nextDrop = redis.call("ZSCORE", priceDropsKey, itemId)
+local price = redis.call("HGET", dutchPricesKey, itemId)
+if not nextDrop or not price or tonumber(nextDrop) > now then
+	return 0
+end
+nextDrop = tonumber(nextDrop)
+price = tonumber(price)
+local schedule = cjson.decode(redis.call("GET", itemKey)).dutch
+local interval = schedule.interval * 1000
+-- Catch up on any drops that were missed while nothing was running.
+local steps = math.floor((now - nextDrop) / interval) + 1
+price = math.max(schedule.floor, price - steps * schedule.step)
+nextDrop = nextDrop + steps * interval
+redis.call("HSET", dutchPricesKey, itemId, price)
+if price <= schedule.floor then
+	redis.call("ZREM", priceDropsKey, itemId)
+	nextDrop = 0
+else
+	redis.call("ZADD", priceDropsKey, nextDrop, itemId)
+end
+logEvent(eventLogKey, "itemId", itemId, "event", cjson.encode({event="priceDropped", itemId=itemId, price=price, nextDrop=nextDrop}))
+return 1
+`)
+	return scriptError(script.Run(s.redis, s.keys(itemID, dutchPricesKey, priceDropsKey, eventLogKey, archivedKey), itemID, unixMillis(now)).Err())
+}
+
+func (s *redisStore) BidRules() (BidRules, error) {
+	blob, err := s.redis.Get(s.key(bidRulesKey)).Result()
+	if err == redis.Nil {
+		return DefaultBidRules, nil
+	}
+	if err != nil {
+		return BidRules{}, err
+	}
+	var rules BidRules
+	if err := json.Unmarshal([]byte(blob), &rules); err != nil {
+		return BidRules{}, err
+	}
+	return rules, nil
+}
+
+func (s *redisStore) SetBidRules(rules BidRules) error {
+	blob, err := json.Marshal(rules)
+	if err != nil {
+		return err
+	}
+	script := s.newScript(`
+local bidRulesKey = KEYS[1]
+local archivedKey = KEYS[2]
+` + liveScript + `
+redis.call("SET", bidRulesKey, ARGV[1])
+return redis.status_reply("ok")
+`)
+	return scriptError(script.Run(s.redis, s.keys(bidRulesKey, archivedKey), string(blob)).Err())
+}
+
+func (s *redisStore) TotalRaised() (int, error) {
+	total, err := s.redis.Get(s.key(totalRaisedKey)).Int()
+	if err == redis.Nil {
+		return 0, nil
+	}
+	return total, err
+}
+
+// ReconcileTotal reads every item in a script, so that the total can't change
+// part of the way through. The script is given the IDs and keys of the items
+// that existed beforehand, and if the items have changed since then, it tries
+// again.
+func (s *redisStore) ReconcileTotal(fix bool) (int, int, error) {
+	script := s.newScript(`
+local totalRaisedKey = KEYS[1]
+local allItemsKey = KEYS[2]
+local archivedKey = KEYS[3]
+local fix = ARGV[1] == "1"
+local count = (table.getn(KEYS) - 3) / 2
+-- Reconciling the total of an archived auction is fine, but fixing it isn't.
+if fix and redis.call("EXISTS", archivedKey) == 1 then
+	return redis.error_reply("ARCHIVED the auction has been archived")
+end
+if redis.call("SCARD", allItemsKey) ~= count then
+	return redis.error_reply("CHANGED")
+end
+local computed = 0
+for i = 1, count do
+	local itemId = ARGV[i + 1]
+	if redis.call("SISMEMBER", allItemsKey, itemId) == 0 then
+		return redis.error_reply("CHANGED")
+	end
+	local stored = redis.call("GET", KEYS[2 * i + 2])
+	if stored then
+		local item = cjson.decode(stored)
+		-- Items closed before outcomes were recorded were always counted.
+		if item.closed and (item.outcome == "sold" or item.outcome == nil) and item.state ~= "voided" then
+			local top = redis.call("LRANGE", KEYS[2 * i + 3], -1, -1)
+			if table.getn(top) > 0 then
+				computed = computed + cjson.decode(top[1]).bid
+			end
+		end
+	end
+end
+local recorded = tonumber(redis.call("GET", totalRaisedKey)) or 0
+if fix then
+	redis.call("SET", totalRaisedKey, computed)
+end
+return {recorded, computed}
+`)
+	for {
+		itemIDs, err := s.redis.SMembers(s.key(allItemsKey)).Result()
+		if err != nil {
+			return 0, 0, err
+		}
+		keys := s.keys(totalRaisedKey, allItemsKey, archivedKey)
+		for _, itemID := range itemIDs {
+			keys = append(keys, s.keys(itemID, "bids-"+itemID)...)
+		}
+		fixArg := "0"
+		if fix {
+			fixArg = "1"
+		}
+		args := []interface{}{fixArg}
+		for _, itemID := range itemIDs {
+			args = append(args, itemID)
+		}
+		result, err := script.Run(s.redis, keys, args...).Result()
+		if err != nil && err.Error() == "CHANGED" {
+			continue
+		}
+		if err != nil {
+			return 0, 0, scriptError(err)
+		}
+		totals, ok := result.([]interface{})
+		if !ok || len(totals) != 2 {
+			return 0, 0, fmt.Errorf("unexpected result %v reconciling the total", result)
+		}
+		recorded, _ := totals[0].(int64)
+		computed, _ := totals[1].(int64)
+		return int(recorded), int(computed), nil
+	}
+}
+
+func (s *redisStore) Subscribe(after string) (<-chan LogEntry, func(), error) {
+	if after == "" {
+		// Start from the last event logged so far, so that nothing logged
+		// after Subscribe returns is missed.
+		last, err := s.LastEventID()
+		if err != nil {
+			return nil, nil, err
+		}
+		after = "0"
+		if last != "" {
+			after = last
+		}
+	} else if _, err := nextStreamID(after); err != nil {
+		return nil, nil, err
+	}
+	done := make(chan struct{})
+	s.mu.Lock()
+	s.subscriptions[done] = true
+	s.mu.Unlock()
+	out := make(chan LogEntry)
+	go func() {
+		defer close(out)
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			// Block for a short while at a time, so that unsubscribing
+			// doesn't have to wait long for the read to finish.
+			streams, err := s.redis.XRead(&redis.XReadArgs{
+				Streams: []string{s.key(eventLogKey), after},
+				Count:   100,
+				Block:   time.Second,
+			}).Result()
+			if err == redis.Nil {
+				continue
+			}
+			if err != nil {
+				select {
+				case <-done:
+				default:
+					log.Printf("Reading auction events failed: %v.\n", err)
+				}
+				return
+			}
+			for _, stream := range streams {
+				for _, message := range stream.Messages {
+					after = message.ID
+					select {
+					case out <- logEntry(message):
+					case <-done:
+						return
+					}
+				}
+			}
+		}
+	}()
+	return out, func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if s.subscriptions[done] {
+			delete(s.subscriptions, done)
+			close(done)
+		}
+	}, nil
+}
+
+func (s *redisStore) EventLog(after, itemID string, limit int) ([]LogEntry, error) {
+	start := "-"
+	if after != "" {
+		var err error
+		if start, err = nextStreamID(after); err != nil {
+			return nil, err
+		}
+	}
+	const pageSize = 500
+	entries := []LogEntry{}
+	for {
+		messages, err := s.redis.XRangeN(s.key(eventLogKey), start, "+", pageSize).Result()
+		if err != nil {
+			return nil, err
+		}
+		for _, message := range messages {
+			entry := logEntry(message)
+			if itemID != "" && entry.ItemID != itemID {
+				continue
+			}
+			entries = append(entries, entry)
+			if limit > 0 && len(entries) == limit {
+				return entries, nil
+			}
+		}
+		if len(messages) < pageSize {
+			return entries, nil
+		}
+		if start, err = nextStreamID(messages[len(messages)-1].ID); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (s *redisStore) LastEventID() (string, error) {
+	last, err := s.redis.XRevRangeN(s.key(eventLogKey), "+", "-", 1).Result()
+	if err != nil || len(last) == 0 {
+		return "", err
+	}
+	return last[0].ID, nil
+}
+
+func logEntry(message redis.XMessage) LogEntry {
+	entry := LogEntry{ID: message.ID}
+	entry.ItemID, _ = message.Values["itemId"].(string)
+	entry.Payload, _ = message.Values["event"].(string)
+	return entry
+}
+
+// nextStreamID returns the smallest stream ID after the given one, because
+// XRANGE only takes inclusive ranges in the versions of redis we support.
+func nextStreamID(id string) (string, error) {
+	parts := strings.SplitN(id, "-", 2)
+	millis, err := strconv.ParseUint(parts[0], 10, 64)
+	if err != nil {
+		return "", ErrBadEventID
+	}
+	if len(parts) == 1 {
+		return fmt.Sprintf("%d-1", millis), nil
+	}
+	sequence, err := strconv.ParseUint(parts[1], 10, 64)
+	if err != nil {
+		return "", ErrBadEventID
+	}
+	if sequence == math.MaxUint64 {
+		return fmt.Sprintf("%d-0", millis+1), nil
+	}
+	return fmt.Sprintf("%d-%d", millis, sequence+1), nil
+}
+
+func (s *redisStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for done := range s.subscriptions {
+		close(done)
+	}
+	s.subscriptions = map[chan struct{}]bool{}
+	return nil
+}
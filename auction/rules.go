@@ -0,0 +1,80 @@
+package auction
+
+import (
+	"errors"
+)
+
+// BidRules limit the bids that can be placed on an item. The auction has a
+// global set of rules, and any rule set on an item overrides it for that item.
+// A rule that is left at zero (or empty) falls back to the global rule. All
+// amounts are in cents.
+//
+// The first bid on an item must always be at least its starting bid.
+type BidRules struct {
+	// Increments is a ladder of minimum increments, in order of the high bid
+	// they apply below. The last step's Below may be zero, in which case it
+	// applies to all higher bids; otherwise, the last step's increment is used
+	// for higher bids anyway.
+	Increments []IncrementStep `json:"increments,omitempty"`
+	// MaxJump is the most a single bid can raise the high bid by.
+	MaxJump int `json:"maxJump,omitempty"`
+	// Cap is the most that can be bid on an item.
+	Cap int `json:"cap,omitempty"`
+}
+
+// IncrementStep is one step of an increment ladder: while the high bid is
+// below Below, each bid must raise it by at least Increment.
+type IncrementStep struct {
+	Below int `json:"below,omitempty"`
+	Increment int `json:"increment"`
+}
+
+// DefaultBidRules are used until global rules are set: every bid must be at
+// least $1 more than the last.
+var DefaultBidRules = BidRules{
+	Increments: []IncrementStep{{Increment: 100}},
+}
+
+// Validate checks that the rules make sense.
+func (r *BidRules) Validate() error {
+	if r.MaxJump < 0 {
+		return errors.New("the maximum jump cannot be negative")
+	}
+	if r.Cap < 0 {
+		return errors.New("the bid cap cannot be negative")
+	}
+	last := 0
+	for i, step := range r.Increments {
+		if step.Increment <= 0 {
+			return errors.New("increments must be positive")
+		}
+		if step.Below == 0 {
+			if i != len(r.Increments)-1 {
+				return errors.New("only the last increment step can apply to all higher bids")
+			}
+			continue
+		}
+		if step.Below <= last {
+			return errors.New("increment steps must be in order of the bids they apply below")
+		}
+		last = step.Below
+	}
+	return nil
+}
+
+// BidRules returns the auction's global bid rules.
+func (a *Auction) BidRules() (BidRules, error) {
+	return a.store.BidRules()
+}
+
+// SetBidRules replaces the auction's global bid rules. They take effect from the
+// next bid.
+func (a *Auction) SetBidRules(rules BidRules) error {
+	if err := rules.Validate(); err != nil {
+		return err
+	}
+	if len(rules.Increments) == 0 {
+		rules.Increments = DefaultBidRules.Increments
+	}
+	return a.store.SetBidRules(rules)
+}
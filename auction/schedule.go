@@ -0,0 +1,83 @@
+package auction
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+)
+
+// previewLead is how long before an item's scheduled opening a reminder that
+// it is coming up is sent.
+const previewLead = 10 * time.Minute
+
+// SetTimeZone sets the time zone in which times on the schedule are given
+// when they don't say, which is usually the convention's.
+func (a *Auction) SetTimeZone(zone *time.Location) {
+	a.timeZone = zone
+}
+
+// TimeZone returns the time zone in which times on the schedule are given when
+// they don't say.
+func (a *Auction) TimeZone() *time.Location {
+	return a.timeZone
+}
+
+// ScheduleOpening schedules an approved or queued item to open at the given
+// time, replacing the current item as OpenItem does, or moves its opening if it
+// already has one. A reminder is sent shortly beforehand.
+func (a *Auction) ScheduleOpening(itemID string, at time.Time) error {
+	if at.IsZero() {
+		return errors.New("no opening time given")
+	}
+	return a.store.ScheduleOpening(itemID, at)
+}
+
+// CancelOpening cancels an item's scheduled opening. It is approved again
+// unless it is also in the run queue.
+func (a *Auction) CancelOpening(itemID string) error {
+	return a.store.CancelOpening(itemID)
+}
+
+// Openings returns the items that are scheduled to open, soonest first.
+func (a *Auction) Openings() ([]Opening, error) {
+	return a.store.Openings()
+}
+
+// openScheduledItems sends reminders for items that are about to open, and
+// opens the items whose time has come. Every process running the scheduler
+// does this, but the store only lets the first one send each reminder or open
+// each item.
+func (a *Auction) openScheduledItems(now time.Time) error {
+	openings, err := a.store.Openings()
+	if err != nil {
+		return fmt.Errorf("couldn't look up openings: %v", err)
+	}
+	for _, opening := range openings {
+		if opening.At.After(now) {
+			if opening.Previewed || opening.At.After(now.Add(previewLead)) {
+				continue
+			}
+			if _, err := a.store.PreviewOpening(opening.ItemID, now.Add(previewLead)); err != nil {
+				log.Printf("Couldn't send a reminder for item %q: %v.\n", opening.ItemID, err)
+			}
+			continue
+		}
+		if _, err := a.store.OpenScheduledItem(opening.ItemID, now); err != nil {
+			log.Printf("Couldn't open scheduled item %q: %v.\n", opening.ItemID, err)
+		}
+	}
+	return nil
+}
+
+// sortOpenings sorts openings soonest first, and by item ID when they are at
+// the same time.
+func sortOpenings(openings []Opening) {
+	sort.Slice(openings, func(i, j int) bool {
+		if !openings[i].At.Equal(openings[j].At) {
+			return openings[i].At.Before(openings[j].At)
+		}
+		return openings[i].ItemID < openings[j].ItemID
+	})
+}
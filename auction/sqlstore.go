@@ -0,0 +1,1740 @@
+package auction
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SQL dialects that OpenSQLStore understands. They are named after the
+// database/sql drivers they use, which must be imported by the caller.
+const (
+	DialectSQLite   = "sqlite3"
+	DialectPostgres = "postgres"
+)
+
+// migrations create and update the schema, in order. Each one is applied once,
+// in a transaction, and recorded in schema_migrations. {{serial}} is replaced
+// by the dialect's auto-incrementing primary key type.
+var migrations = [][]string{
+	{
+		`CREATE TABLE auction (
+			id INTEGER PRIMARY KEY,
+			current_item TEXT NOT NULL DEFAULT '',
+			total_raised BIGINT NOT NULL DEFAULT 0,
+			bid_rules TEXT
+		)`,
+		`INSERT INTO auction (id) VALUES (1)`,
+		`CREATE TABLE items (
+			id TEXT PRIMARY KEY,
+			external_id TEXT UNIQUE,
+			title TEXT NOT NULL,
+			data TEXT NOT NULL,
+			is_open BOOLEAN NOT NULL DEFAULT FALSE,
+			closed BOOLEAN NOT NULL DEFAULT FALSE,
+			outcome TEXT NOT NULL DEFAULT '',
+			winner TEXT,
+			winner_display_name TEXT,
+			price INTEGER,
+			deadline BIGINT,
+			extended_by BIGINT NOT NULL DEFAULT 0,
+			dutch_price INTEGER,
+			next_price_drop BIGINT
+		)`,
+		`CREATE TABLE bids (
+			seq {{serial}},
+			id TEXT NOT NULL,
+			item_id TEXT NOT NULL REFERENCES items (id),
+			bidder TEXT NOT NULL,
+			bidder_display_name TEXT NOT NULL,
+			amount INTEGER NOT NULL,
+			proxy BOOLEAN NOT NULL DEFAULT FALSE,
+			buy_now BOOLEAN NOT NULL DEFAULT FALSE,
+			sealed_bid INTEGER NOT NULL DEFAULT 0,
+			placed_at BIGINT NOT NULL
+		)`,
+		`CREATE INDEX bids_item_id ON bids (item_id, seq)`,
+		`CREATE TABLE max_bids (
+			item_id TEXT NOT NULL REFERENCES items (id),
+			bidder TEXT NOT NULL,
+			bidder_display_name TEXT NOT NULL,
+			max_amount INTEGER NOT NULL,
+			set_at BIGINT NOT NULL,
+			PRIMARY KEY (item_id, bidder)
+		)`,
+		`CREATE TABLE sealed_bids (
+			item_id TEXT NOT NULL REFERENCES items (id),
+			bidder TEXT NOT NULL,
+			id TEXT NOT NULL,
+			bidder_display_name TEXT NOT NULL,
+			amount INTEGER NOT NULL,
+			placed_at BIGINT NOT NULL,
+			PRIMARY KEY (item_id, bidder)
+		)`,
+	},
+	{
+		`CREATE TABLE events (
+			seq {{serial}},
+			item_id TEXT NOT NULL DEFAULT '',
+			payload TEXT NOT NULL,
+			logged_at BIGINT NOT NULL
+		)`,
+		`CREATE INDEX events_item_id ON events (item_id, seq)`,
+	},
+	{
+		`ALTER TABLE items ADD COLUMN state TEXT NOT NULL DEFAULT ''`,
+	},
+	{
+		// How long paused items had to go until their deadlines and next
+		// price drops, in milliseconds.
+		`ALTER TABLE items ADD COLUMN paused_deadline BIGINT`,
+		`ALTER TABLE items ADD COLUMN paused_price_drop BIGINT`,
+	},
+	{
+		`CREATE TABLE run_queue (
+			item_id TEXT PRIMARY KEY REFERENCES items (id),
+			position INTEGER NOT NULL
+		)`,
+	},
+	{
+		`ALTER TABLE items ADD COLUMN opens_at BIGINT`,
+		`ALTER TABLE items ADD COLUMN opening_previewed BOOLEAN NOT NULL DEFAULT FALSE`,
+	},
+	{
+		`ALTER TABLE auction ADD COLUMN archived BOOLEAN NOT NULL DEFAULT FALSE`,
+	},
+}
+
+// sqlStore keeps the auction in a SQL database, as a permanent record of the
+// items, bids and winners that can be queried once the auction is over. Every
+// change is made in a transaction that first locks the single row of the
+// auction table, so changes are made one at a time even across processes.
+//
+// Every event is kept in the events table, but events are only published to
+// subscribers in the same process.
+type sqlStore struct {
+	db      *sql.DB
+	dialect string
+	// mu is held from the start of each change until its events are
+	// published, so that they are published in the order the changes were
+	// made.
+	mu     sync.Mutex
+	events localEvents
+}
+
+// querier is satisfied by both *sql.DB and *sql.Tx.
+type querier interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// OpenSQLStore connects to a SQL database using the given dialect's driver,
+// brings its schema up to date, and returns a Store that keeps the auction in
+// it. Auctions in other namespaces are kept apart in their own schema on
+// Postgres, and in their own file alongside the database on SQLite. The empty
+// namespace is the database itself.
+func OpenSQLStore(dialect, dataSource, namespace string) (Store, error) {
+	if dialect != DialectSQLite && dialect != DialectPostgres {
+		return nil, fmt.Errorf("unknown SQL dialect %q", dialect)
+	}
+	if namespace != "" {
+		if err := CheckNamespace(namespace); err != nil {
+			return nil, err
+		}
+		dataSource = namespaceDataSource(dialect, dataSource, namespace)
+	}
+	db, err := sql.Open(dialect, dataSource)
+	if err != nil {
+		return nil, err
+	}
+	if dialect == DialectSQLite {
+		// SQLite only allows one writer at a time anyway, and sharing one
+		// connection keeps in-memory databases from vanishing between queries.
+		db.SetMaxOpenConns(1)
+	}
+	if dialect == DialectPostgres && namespace != "" {
+		if _, err := db.Exec(`CREATE SCHEMA IF NOT EXISTS "` + namespace + `"`); err != nil {
+			_ = db.Close()
+			return nil, fmt.Errorf("couldn't create the schema for %q: %v", namespace, err)
+		}
+	}
+	s := &sqlStore{db: db, dialect: dialect}
+	if err := s.migrate(); err != nil {
+		_ = db.Close()
+		return nil, fmt.Errorf("couldn't migrate the database: %v", err)
+	}
+	return s, nil
+}
+
+// namespaceDataSource returns the data source for a namespace, which must
+// already have been checked. On Postgres, every connection searches the
+// namespace's schema instead of the default one, which lib/pq passes on as a
+// run-time parameter. The schema is quoted, since namespaces can have dashes.
+// On SQLite, the namespace is added to the name of the database file, so that
+// "file:auction.db" becomes "file:auction-ns.db". In-memory databases are
+// already separate.
+func namespaceDataSource(dialect, dataSource, namespace string) string {
+	if dialect == DialectPostgres {
+		if strings.HasPrefix(dataSource, "postgres://") || strings.HasPrefix(dataSource, "postgresql://") {
+			separator := "?"
+			if strings.Contains(dataSource, "?") {
+				separator = "&"
+			}
+			return dataSource + separator + "search_path=" + url.QueryEscape(`"`+namespace+`"`)
+		}
+		return dataSource + ` search_path='"` + namespace + `"'`
+	}
+	path, query := dataSource, ""
+	if i := strings.Index(dataSource, "?"); i >= 0 {
+		path, query = dataSource[:i], dataSource[i:]
+	}
+	if strings.Contains(path, ":memory:") {
+		return dataSource
+	}
+	ext := filepath.Ext(path)
+	return strings.TrimSuffix(path, ext) + "-" + namespace + ext + query
+}
+
+// q rewrites a query written with ? placeholders for the dialect.
+func (s *sqlStore) q(query string) string {
+	if s.dialect != DialectPostgres {
+		return query
+	}
+	var b strings.Builder
+	n := 0
+	for _, c := range query {
+		if c == '?' {
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
+
+func (s *sqlStore) migrate() error {
+	if _, err := s.db.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (version INTEGER PRIMARY KEY)`); err != nil {
+		return err
+	}
+	var version int
+	if err := s.db.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&version); err != nil {
+		return err
+	}
+	serial := "INTEGER PRIMARY KEY AUTOINCREMENT"
+	if s.dialect == DialectPostgres {
+		serial = "BIGSERIAL PRIMARY KEY"
+	}
+	for ; version < len(migrations); version++ {
+		tx, err := s.db.Begin()
+		if err != nil {
+			return err
+		}
+		for _, statement := range migrations[version] {
+			if _, err := tx.Exec(strings.Replace(statement, "{{serial}}", serial, -1)); err != nil {
+				_ = tx.Rollback()
+				return fmt.Errorf("migration %d: %v", version+1, err)
+			}
+		}
+		if _, err := tx.Exec(s.q(`INSERT INTO schema_migrations (version) VALUES (?)`), version+1); err != nil {
+			_ = tx.Rollback()
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// change runs f in a transaction, as transact does, unless the auction has
+// been archived, in which case it returns ErrAuctionArchived. Everything that
+// changes the auction goes through it.
+func (s *sqlStore) change(f func(tx *sql.Tx) ([]Event, error)) error {
+	return s.transact(func(tx *sql.Tx) ([]Event, error) {
+		if err := s.checkLive(tx); err != nil {
+			return nil, err
+		}
+		return f(tx)
+	})
+}
+
+// checkLive returns ErrAuctionArchived if the auction has been archived. The
+// auction's row is locked by the time it is called, so the auction can't be
+// archived before the transaction is committed.
+func (s *sqlStore) checkLive(tx *sql.Tx) error {
+	var archived bool
+	if err := tx.QueryRow(`SELECT archived FROM auction WHERE id = 1`).Scan(&archived); err != nil {
+		return err
+	}
+	if archived {
+		return ErrAuctionArchived
+	}
+	return nil
+}
+
+// transact runs f in a transaction, logs the events it returns in the same
+// transaction, and publishes them once it has been committed.
+func (s *sqlStore) transact(f func(tx *sql.Tx) ([]Event, error)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`UPDATE auction SET id = id WHERE id = 1`); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	events, err := f(tx)
+	if err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	entries, err := s.logEvents(tx, events)
+	if err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	s.events.publish(entries...)
+	return nil
+}
+
+func (s *sqlStore) logEvents(tx *sql.Tx, events []Event) ([]LogEntry, error) {
+	entries := make([]LogEntry, 0, len(events))
+	now := time.Now().UnixNano() / int64(time.Millisecond)
+	for _, e := range events {
+		entry, err := newLogEntry(e)
+		if err != nil {
+			return nil, err
+		}
+		const insert = `INSERT INTO events (item_id, payload, logged_at) VALUES (?, ?, ?)`
+		var seq int64
+		if s.dialect == DialectPostgres {
+			err = tx.QueryRow(s.q(insert+` RETURNING seq`), entry.ItemID, entry.Payload, now).Scan(&seq)
+		} else {
+			var result sql.Result
+			if result, err = tx.Exec(insert, entry.ItemID, entry.Payload, now); err == nil {
+				seq, err = result.LastInsertId()
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+		entry.ID = strconv.FormatInt(seq, 10)
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// itemState is an item along with the state it has while it is open or
+// scheduled to open.
+type itemState struct {
+	item       Item
+	open       bool
+	deadline   sql.NullInt64
+	extended   int64
+	dutchPrice sql.NullInt64
+	nextDrop   sql.NullInt64
+	opensAt    sql.NullInt64
+	previewed  bool
+}
+
+func (s *sqlStore) loadItem(q querier, itemID string) (*itemState, error) {
+	var st itemState
+	var data string
+	err := q.QueryRow(s.q(`SELECT data, is_open, deadline, extended_by, dutch_price, next_price_drop, opens_at, opening_previewed FROM items WHERE id = ?`), itemID).
+		Scan(&data, &st.open, &st.deadline, &st.extended, &st.dutchPrice, &st.nextDrop, &st.opensAt, &st.previewed)
+	if err == sql.ErrNoRows {
+		return nil, ErrItemNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal([]byte(data), &st.item); err != nil {
+		return nil, err
+	}
+	st.item.ID = itemID
+	return &st, nil
+}
+
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
+
+// saveItem writes the item itself, and the columns that are copied from it so
+// that they can be queried.
+func (s *sqlStore) saveItem(tx *sql.Tx, item Item) error {
+	data, err := json.Marshal(item)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(s.q(`UPDATE items SET data = ?, external_id = ?, title = ?, closed = ?, outcome = ?, state = ? WHERE id = ?`),
+		string(data), nullString(item.ExternalID), item.Title, item.Closed, item.Outcome, item.State, item.ID)
+	return err
+}
+
+func (s *sqlStore) externalIDTaken(tx *sql.Tx, externalID string) (bool, error) {
+	var n int
+	err := tx.QueryRow(s.q(`SELECT COUNT(*) FROM items WHERE external_id = ?`), externalID).Scan(&n)
+	return n > 0, err
+}
+
+func (s *sqlStore) hasBids(tx *sql.Tx, itemID string) (bool, error) {
+	var n int
+	err := tx.QueryRow(s.q(`SELECT (SELECT COUNT(*) FROM bids WHERE item_id = ?) + (SELECT COUNT(*) FROM sealed_bids WHERE item_id = ?)`), itemID, itemID).Scan(&n)
+	return n > 0, err
+}
+
+func (s *sqlStore) CreateItem(item Item) error {
+	return s.change(func(tx *sql.Tx) ([]Event, error) {
+		if item.ExternalID != "" {
+			taken, err := s.externalIDTaken(tx, item.ExternalID)
+			if err != nil {
+				return nil, err
+			}
+			if taken {
+				return nil, &RejectedError{Reason: fmt.Sprintf("an item with external ID %q already exists", item.ExternalID)}
+			}
+		}
+		var n int
+		if err := tx.QueryRow(s.q(`SELECT COUNT(*) FROM items WHERE id = ?`), item.ID).Scan(&n); err != nil {
+			return nil, err
+		}
+		if n > 0 {
+			return nil, &RejectedError{Reason: "an item with that ID already exists"}
+		}
+		if _, err := tx.Exec(s.q(`INSERT INTO items (id, title, data) VALUES (?, ?, '')`), item.ID, item.Title); err != nil {
+			return nil, err
+		}
+		return nil, s.saveItem(tx, item)
+	})
+}
+
+func (s *sqlStore) UpdateItem(itemID string, update func(old Item) (Item, bool, error)) (*Item, error) {
+	var item Item
+	err := s.change(func(tx *sql.Tx) ([]Event, error) {
+		st, err := s.loadItem(tx, itemID)
+		if err != nil {
+			return nil, err
+		}
+		old := st.item
+		updated, destructive, err := update(old)
+		if err != nil {
+			return nil, err
+		}
+		if updated.ExternalID != old.ExternalID && updated.ExternalID != "" {
+			taken, err := s.externalIDTaken(tx, updated.ExternalID)
+			if err != nil {
+				return nil, err
+			}
+			if taken {
+				return nil, &RejectedError{Reason: fmt.Sprintf("an item with external ID %q already exists", updated.ExternalID)}
+			}
+		}
+		if destructive {
+			if st.open {
+				return nil, &RejectedError{Reason: "the starting bid and bidding rules cannot be changed while the item is open"}
+			}
+			bids, err := s.hasBids(tx, itemID)
+			if err != nil {
+				return nil, err
+			}
+			if bids {
+				return nil, &RejectedError{Reason: "the starting bid and bidding rules cannot be changed once the item has bids"}
+			}
+		}
+		item = updated
+		return nil, s.saveItem(tx, item)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &item, nil
+}
+
+func (s *sqlStore) DeleteItem(itemID string) error {
+	return s.change(func(tx *sql.Tx) ([]Event, error) {
+		st, err := s.loadItem(tx, itemID)
+		if err != nil {
+			return nil, err
+		}
+		if st.open {
+			return nil, &RejectedError{Reason: "the item cannot be deleted while it is open"}
+		}
+		bids, err := s.hasBids(tx, itemID)
+		if err != nil {
+			return nil, err
+		}
+		if bids {
+			return nil, &RejectedError{Reason: "the item cannot be deleted once it has bids"}
+		}
+		if position, err := s.queued(tx, itemID); err != nil {
+			return nil, err
+		} else if position >= 0 {
+			return nil, &RejectedError{Reason: "the item cannot be deleted while it is queued"}
+		}
+		if st.opensAt.Valid {
+			return nil, &RejectedError{Reason: "the item cannot be deleted while it is scheduled to open"}
+		}
+		if _, err := tx.Exec(s.q(`DELETE FROM max_bids WHERE item_id = ?`), itemID); err != nil {
+			return nil, err
+		}
+		_, err = tx.Exec(s.q(`DELETE FROM items WHERE id = ?`), itemID)
+		return nil, err
+	})
+}
+
+func (s *sqlStore) GetItem(itemID string) (*Item, error) {
+	st, err := s.loadItem(s.db, itemID)
+	if err != nil {
+		return nil, err
+	}
+	return &st.item, nil
+}
+
+func (s *sqlStore) GetItems() ([]Item, error) {
+	rows, err := s.db.Query(`SELECT id, data FROM items ORDER BY title`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []Item{}
+	for rows.Next() {
+		var id, data string
+		if err := rows.Scan(&id, &data); err != nil {
+			return nil, err
+		}
+		var item Item
+		if err := json.Unmarshal([]byte(data), &item); err != nil {
+			continue
+		}
+		item.ID = id
+		items = append(items, item)
+	}
+	return items, rows.Err()
+}
+
+func (s *sqlStore) GetItemByExternalID(externalID string) (*Item, error) {
+	var itemID string
+	err := s.db.QueryRow(s.q(`SELECT id FROM items WHERE external_id = ?`), externalID).Scan(&itemID)
+	if err == sql.ErrNoRows {
+		return nil, ErrItemNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return s.GetItem(itemID)
+}
+
+// forget clears everything that only matters while an item is open.
+func (s *sqlStore) forget(tx *sql.Tx, itemID string) error {
+	_, err := tx.Exec(s.q(`UPDATE items SET is_open = ?, deadline = NULL, extended_by = 0, dutch_price = NULL, next_price_drop = NULL, paused_deadline = NULL, paused_price_drop = NULL WHERE id = ?`), false, itemID)
+	return err
+}
+
+func (s *sqlStore) OpenItem(itemID string, options OpenOptions, now time.Time) error {
+	return s.change(func(tx *sql.Tx) ([]Event, error) {
+		return s.openItem(tx, itemID, options, now)
+	})
+}
+
+// openItem opens an item, taking it off the run queue if it is queued, and
+// returns the events to log.
+func (s *sqlStore) openItem(tx *sql.Tx, itemID string, options OpenOptions, now time.Time) ([]Event, error) {
+	st, err := s.loadItem(tx, itemID)
+	if err != nil {
+		return nil, err
+	}
+	item := st.item
+	from := stateOf(item, st.open)
+	if from != StateOpen {
+		if err := checkTransition(from, StateOpen); err != nil {
+			return nil, err
+		}
+	}
+	if item.Closed {
+		// Reopening a sold item takes its price back off the total. Items
+		// closed before outcomes were recorded were always counted.
+		if item.Outcome == OutcomeSold || item.Outcome == "" {
+			top, err := s.top(tx, itemID)
+			if err != nil {
+				return nil, err
+			}
+			if top != nil {
+				if _, err := tx.Exec(s.q(`UPDATE auction SET total_raised = total_raised - ? WHERE id = 1`), top.BidCents); err != nil {
+					return nil, err
+				}
+			}
+		}
+		if _, err := tx.Exec(s.q(`UPDATE items SET winner = NULL, winner_display_name = NULL, price = NULL WHERE id = ?`), itemID); err != nil {
+			return nil, err
+		}
+	}
+	if item.State != StateOpen {
+		setState(&item, StateOpen)
+		if err := s.saveItem(tx, item); err != nil {
+			return nil, err
+		}
+	}
+	var events []Event
+	if !options.Silent {
+		var current string
+		if err := tx.QueryRow(`SELECT current_item FROM auction WHERE id = 1`).Scan(&current); err != nil {
+			return nil, err
+		}
+		if current != "" && current != itemID {
+			previous, err := s.loadItem(tx, current)
+			if err != nil && err != ErrItemNotFound {
+				return nil, err
+			}
+			if previous != nil && previous.open {
+				previousState := stateOf(previous.item, true)
+				setState(&previous.item, StateApproved)
+				if err := s.saveItem(tx, previous.item); err != nil {
+					return nil, err
+				}
+				events = append(events, ItemStateChangedEvent{ItemID: current, From: previousState, To: StateApproved})
+			}
+			if err := s.forget(tx, current); err != nil {
+				return nil, err
+			}
+		}
+		if _, err := tx.Exec(s.q(`UPDATE auction SET current_item = ? WHERE id = 1`), itemID); err != nil {
+			return nil, err
+		}
+	}
+	// Reopening an open item mustn't put its price back up, and once an item
+	// has been claimed its price no longer drops.
+	if item.Mode == ModeDutch && item.Dutch != nil && from != StateOpen {
+		top, err := s.top(tx, itemID)
+		if err != nil {
+			return nil, err
+		}
+		if top == nil {
+			nextDrop := unixMillis(now) + int64(item.Dutch.Interval)*1000
+			if _, err := tx.Exec(s.q(`UPDATE items SET dutch_price = ?, next_price_drop = ? WHERE id = ?`), item.Dutch.StartPrice, nextDrop, itemID); err != nil {
+				return nil, err
+			}
+		}
+	}
+	event := OpenItemEvent{ItemID: itemID, Silent: options.Silent}
+	deadline := sql.NullInt64{}
+	if !options.Deadline.IsZero() {
+		event.Deadline = unixMillis(options.Deadline)
+		deadline = sql.NullInt64{Int64: event.Deadline, Valid: true}
+	}
+	if _, err := tx.Exec(s.q(`UPDATE items SET is_open = ?, extended_by = 0, deadline = ?, paused_deadline = NULL, paused_price_drop = NULL, opens_at = NULL, opening_previewed = ? WHERE id = ?`), true, deadline, false, itemID); err != nil {
+		return nil, err
+	}
+	events = append(events, event)
+	if from != StateOpen {
+		events = append(events, ItemStateChangedEvent{ItemID: itemID, From: from, To: StateOpen})
+	}
+	changed, err := s.unqueue(tx, itemID)
+	if err != nil {
+		return nil, err
+	}
+	return append(events, changed...), nil
+}
+
+// closeItem marks an open item as closed with the given outcome, recording the
+// winner if it was sold, and takes it off the auction. It returns the event for
+// the item's change of state.
+func (s *sqlStore) closeItem(tx *sql.Tx, item Item, outcome string, top *Bid) (Event, error) {
+	from, to := stateOf(item, true), closedState(outcome)
+	setState(&item, to)
+	item.Outcome = outcome
+	if err := s.saveItem(tx, item); err != nil {
+		return nil, err
+	}
+	if err := s.forget(tx, item.ID); err != nil {
+		return nil, err
+	}
+	if outcome == OutcomeSold {
+		if _, err := tx.Exec(s.q(`UPDATE items SET winner = ?, winner_display_name = ?, price = ? WHERE id = ?`), top.Bidder, top.BidderDisplayName, top.BidCents, item.ID); err != nil {
+			return nil, err
+		}
+		if _, err := tx.Exec(s.q(`UPDATE auction SET total_raised = total_raised + ? WHERE id = 1`), top.BidCents); err != nil {
+			return nil, err
+		}
+	}
+	if _, err := tx.Exec(s.q(`UPDATE auction SET current_item = '' WHERE id = 1 AND current_item = ?`), item.ID); err != nil {
+		return nil, err
+	}
+	return ItemStateChangedEvent{ItemID: item.ID, From: from, To: to}, nil
+}
+
+func (s *sqlStore) CloseItem(itemID string, expiredBy time.Time) (string, error) {
+	result := ""
+	err := s.change(func(tx *sql.Tx) ([]Event, error) {
+		st, err := s.loadItem(tx, itemID)
+		if err != nil {
+			return nil, err
+		}
+		if !st.open {
+			return nil, ErrItemClosed
+		}
+		if !expiredBy.IsZero() && (!st.deadline.Valid || st.deadline.Int64 > unixMillis(expiredBy)) {
+			return nil, nil
+		}
+		var events []Event
+		result, events, err = s.finishItem(tx, st.item)
+		return events, err
+	})
+	return result, err
+}
+
+// finishItem reveals any sealed bids on an open item, decides the outcome and
+// closes it. It returns the outcome and the events to log.
+func (s *sqlStore) finishItem(tx *sql.Tx, item Item) (string, []Event, error) {
+	if item.Mode == ModeSealed {
+		sealed, err := s.sealedBids(tx, item.ID)
+		if err != nil {
+			return "", nil, err
+		}
+		for i, bid := range revealSealedBids(item, sealed) {
+			if err := s.insertBid(tx, bid, sealed[i].PlacedAt); err != nil {
+				return "", nil, err
+			}
+		}
+		if _, err := tx.Exec(s.q(`DELETE FROM sealed_bids WHERE item_id = ?`), item.ID); err != nil {
+			return "", nil, err
+		}
+	}
+	top, err := s.top(tx, item.ID)
+	if err != nil {
+		return "", nil, err
+	}
+	result := outcome(item, top)
+	changed, err := s.closeItem(tx, item, result, top)
+	if err != nil {
+		return "", nil, err
+	}
+	return result, []Event{CloseItemEvent{ItemID: item.ID, Outcome: result}, changed}, nil
+}
+
+func (s *sqlStore) PauseItem(itemID string, now time.Time) error {
+	return s.change(func(tx *sql.Tx) ([]Event, error) {
+		st, err := s.loadItem(tx, itemID)
+		if err != nil {
+			return nil, err
+		}
+		if !st.open {
+			return nil, ErrItemClosed
+		}
+		item := st.item
+		from := stateOf(item, true)
+		if err := checkTransition(from, StatePaused); err != nil {
+			return nil, err
+		}
+		event := PauseItemEvent{ItemID: itemID}
+		var pausedDeadline, pausedPriceDrop sql.NullInt64
+		if st.deadline.Valid {
+			event.Remaining = remainingMillis(st.deadline.Int64, now)
+			pausedDeadline = sql.NullInt64{Int64: event.Remaining, Valid: true}
+		}
+		if st.nextDrop.Valid {
+			pausedPriceDrop = sql.NullInt64{Int64: remainingMillis(st.nextDrop.Int64, now), Valid: true}
+		}
+		if _, err := tx.Exec(s.q(`UPDATE items SET deadline = NULL, next_price_drop = NULL, paused_deadline = ?, paused_price_drop = ? WHERE id = ?`), pausedDeadline, pausedPriceDrop, itemID); err != nil {
+			return nil, err
+		}
+		setState(&item, StatePaused)
+		if err := s.saveItem(tx, item); err != nil {
+			return nil, err
+		}
+		return []Event{event, ItemStateChangedEvent{ItemID: itemID, From: from, To: StatePaused}}, nil
+	})
+}
+
+func (s *sqlStore) ResumeItem(itemID string, now time.Time) error {
+	return s.change(func(tx *sql.Tx) ([]Event, error) {
+		st, err := s.loadItem(tx, itemID)
+		if err != nil {
+			return nil, err
+		}
+		if !st.open {
+			return nil, ErrItemClosed
+		}
+		item := st.item
+		if stateOf(item, true) != StatePaused {
+			return nil, &RejectedError{Reason: "bidding on that item isn't paused"}
+		}
+		var pausedDeadline, pausedPriceDrop sql.NullInt64
+		if err := tx.QueryRow(s.q(`SELECT paused_deadline, paused_price_drop FROM items WHERE id = ?`), itemID).Scan(&pausedDeadline, &pausedPriceDrop); err != nil {
+			return nil, err
+		}
+		event := ResumeItemEvent{ItemID: itemID}
+		var deadline, nextDrop sql.NullInt64
+		if pausedDeadline.Valid {
+			event.Deadline = unixMillis(now) + pausedDeadline.Int64
+			deadline = sql.NullInt64{Int64: event.Deadline, Valid: true}
+		}
+		if pausedPriceDrop.Valid {
+			nextDrop = sql.NullInt64{Int64: unixMillis(now) + pausedPriceDrop.Int64, Valid: true}
+		}
+		if _, err := tx.Exec(s.q(`UPDATE items SET deadline = ?, next_price_drop = ?, paused_deadline = NULL, paused_price_drop = NULL WHERE id = ?`), deadline, nextDrop, itemID); err != nil {
+			return nil, err
+		}
+		setState(&item, StateOpen)
+		if err := s.saveItem(tx, item); err != nil {
+			return nil, err
+		}
+		return []Event{event, ItemStateChangedEvent{ItemID: itemID, From: StatePaused, To: StateOpen}}, nil
+	})
+}
+
+func (s *sqlStore) SetItemState(itemID, state string) error {
+	return s.change(func(tx *sql.Tx) ([]Event, error) {
+		st, err := s.loadItem(tx, itemID)
+		if err != nil {
+			return nil, err
+		}
+		item := st.item
+		from := stateOf(item, st.open)
+		if err := checkManualTransition(from, state); err != nil {
+			return nil, err
+		}
+		if state == StateVoided && countedStates[from] {
+			top, err := s.top(tx, itemID)
+			if err != nil {
+				return nil, err
+			}
+			if top != nil {
+				if _, err := tx.Exec(s.q(`UPDATE auction SET total_raised = total_raised - ? WHERE id = 1`), top.BidCents); err != nil {
+					return nil, err
+				}
+			}
+		}
+		setState(&item, state)
+		if err := s.saveItem(tx, item); err != nil {
+			return nil, err
+		}
+		changed, err := s.unqueue(tx, itemID)
+		if err != nil {
+			return nil, err
+		}
+		events := append([]Event{ItemStateChangedEvent{ItemID: itemID, From: from, To: state}}, changed...)
+		if st.opensAt.Valid {
+			if err := s.setOpening(tx, itemID, sql.NullInt64{}); err != nil {
+				return nil, err
+			}
+			events = append(events, ItemScheduledEvent{ItemID: itemID})
+		}
+		return events, nil
+	})
+}
+
+func (s *sqlStore) queue(q querier) ([]string, error) {
+	rows, err := q.Query(`SELECT item_id FROM run_queue ORDER BY position`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	queue := []string{}
+	for rows.Next() {
+		var itemID string
+		if err := rows.Scan(&itemID); err != nil {
+			return nil, err
+		}
+		queue = append(queue, itemID)
+	}
+	return queue, rows.Err()
+}
+
+// queued returns the item's position in the run queue, or -1 if it isn't in
+// it.
+func (s *sqlStore) queued(q querier, itemID string) (int, error) {
+	queue, err := s.queue(q)
+	if err != nil {
+		return 0, err
+	}
+	for i, queued := range queue {
+		if queued == itemID {
+			return i, nil
+		}
+	}
+	return -1, nil
+}
+
+// setQueue replaces the run queue, and returns the event for the change.
+func (s *sqlStore) setQueue(tx *sql.Tx, itemID string, queue []string) (Event, error) {
+	if _, err := tx.Exec(`DELETE FROM run_queue`); err != nil {
+		return nil, err
+	}
+	for i, queued := range queue {
+		if _, err := tx.Exec(s.q(`INSERT INTO run_queue (item_id, position) VALUES (?, ?)`), queued, i); err != nil {
+			return nil, err
+		}
+	}
+	return QueueChangedEvent{ItemID: itemID, Queue: queue}, nil
+}
+
+// unqueue takes an item off the run queue, and returns the event for the
+// change if it was in it.
+func (s *sqlStore) unqueue(tx *sql.Tx, itemID string) ([]Event, error) {
+	queue, err := s.queue(tx)
+	if err != nil {
+		return nil, err
+	}
+	for i, queued := range queue {
+		if queued == itemID {
+			changed, err := s.setQueue(tx, itemID, append(queue[:i], queue[i+1:]...))
+			if err != nil {
+				return nil, err
+			}
+			return []Event{changed}, nil
+		}
+	}
+	return nil, nil
+}
+
+// enqueue returns the queue with an item put into it at the given position.
+func enqueue(queue []string, itemID string, position int) []string {
+	if position < 0 || position > len(queue) {
+		position = len(queue)
+	}
+	queue = append(queue, "")
+	copy(queue[position+1:], queue[position:])
+	queue[position] = itemID
+	return queue
+}
+
+func (s *sqlStore) Queue() ([]string, error) {
+	return s.queue(s.db)
+}
+
+func (s *sqlStore) QueueItem(itemID string, position int) error {
+	return s.change(func(tx *sql.Tx) ([]Event, error) {
+		st, err := s.loadItem(tx, itemID)
+		if err != nil {
+			return nil, err
+		}
+		queued, err := s.queued(tx, itemID)
+		if err != nil {
+			return nil, err
+		}
+		var events []Event
+		item := st.item
+		from := stateOf(item, st.open)
+		if !st.opensAt.Valid || queued >= 0 {
+			if err := checkTransition(from, StateScheduled); err != nil {
+				return nil, err
+			}
+			setState(&item, StateScheduled)
+			if err := s.saveItem(tx, item); err != nil {
+				return nil, err
+			}
+			events = append(events, ItemStateChangedEvent{ItemID: itemID, From: from, To: StateScheduled})
+		}
+		queue, err := s.queue(tx)
+		if err != nil {
+			return nil, err
+		}
+		changed, err := s.setQueue(tx, itemID, enqueue(queue, itemID, position))
+		if err != nil {
+			return nil, err
+		}
+		return append(events, changed), nil
+	})
+}
+
+func (s *sqlStore) MoveQueuedItem(itemID string, position int) error {
+	return s.change(func(tx *sql.Tx) ([]Event, error) {
+		if _, err := s.loadItem(tx, itemID); err != nil {
+			return nil, err
+		}
+		queue, err := s.queue(tx)
+		if err != nil {
+			return nil, err
+		}
+		for i, queued := range queue {
+			if queued == itemID {
+				queue = enqueue(append(queue[:i], queue[i+1:]...), itemID, position)
+				changed, err := s.setQueue(tx, itemID, queue)
+				if err != nil {
+					return nil, err
+				}
+				return []Event{changed}, nil
+			}
+		}
+		return nil, &RejectedError{Reason: "that item isn't in the queue"}
+	})
+}
+
+func (s *sqlStore) UnqueueItem(itemID string) error {
+	return s.change(func(tx *sql.Tx) ([]Event, error) {
+		st, err := s.loadItem(tx, itemID)
+		if err != nil {
+			return nil, err
+		}
+		changed, err := s.unqueue(tx, itemID)
+		if err != nil {
+			return nil, err
+		}
+		if len(changed) == 0 {
+			return nil, &RejectedError{Reason: "that item isn't in the queue"}
+		}
+		item := st.item
+		if from := stateOf(item, st.open); from == StateScheduled && !st.opensAt.Valid {
+			setState(&item, StateApproved)
+			if err := s.saveItem(tx, item); err != nil {
+				return nil, err
+			}
+			changed = append([]Event{ItemStateChangedEvent{ItemID: itemID, From: from, To: StateApproved}}, changed...)
+		}
+		return changed, nil
+	})
+}
+
+// setOpening sets or clears the time an item is scheduled to open, and
+// forgets any reminder of it.
+func (s *sqlStore) setOpening(tx *sql.Tx, itemID string, opensAt sql.NullInt64) error {
+	_, err := tx.Exec(s.q(`UPDATE items SET opens_at = ?, opening_previewed = ? WHERE id = ?`), opensAt, false, itemID)
+	return err
+}
+
+func (s *sqlStore) ScheduleOpening(itemID string, at time.Time) error {
+	return s.change(func(tx *sql.Tx) ([]Event, error) {
+		st, err := s.loadItem(tx, itemID)
+		if err != nil {
+			return nil, err
+		}
+		var events []Event
+		item := st.item
+		from := stateOf(item, st.open)
+		if from != StateScheduled {
+			if err := checkTransition(from, StateScheduled); err != nil {
+				return nil, err
+			}
+			setState(&item, StateScheduled)
+			if err := s.saveItem(tx, item); err != nil {
+				return nil, err
+			}
+			events = append(events, ItemStateChangedEvent{ItemID: itemID, From: from, To: StateScheduled})
+		}
+		if err := s.setOpening(tx, itemID, sql.NullInt64{Int64: unixMillis(at), Valid: true}); err != nil {
+			return nil, err
+		}
+		return append(events, ItemScheduledEvent{ItemID: itemID, OpensAt: unixMillis(at)}), nil
+	})
+}
+
+func (s *sqlStore) CancelOpening(itemID string) error {
+	return s.change(func(tx *sql.Tx) ([]Event, error) {
+		st, err := s.loadItem(tx, itemID)
+		if err != nil {
+			return nil, err
+		}
+		if !st.opensAt.Valid {
+			return nil, &RejectedError{Reason: "that item isn't scheduled to open"}
+		}
+		if err := s.setOpening(tx, itemID, sql.NullInt64{}); err != nil {
+			return nil, err
+		}
+		events := []Event{ItemScheduledEvent{ItemID: itemID}}
+		position, err := s.queued(tx, itemID)
+		if err != nil {
+			return nil, err
+		}
+		item := st.item
+		if from := stateOf(item, st.open); from == StateScheduled && position < 0 {
+			setState(&item, StateApproved)
+			if err := s.saveItem(tx, item); err != nil {
+				return nil, err
+			}
+			events = append(events, ItemStateChangedEvent{ItemID: itemID, From: from, To: StateApproved})
+		}
+		return events, nil
+	})
+}
+
+func (s *sqlStore) Openings() ([]Opening, error) {
+	rows, err := s.db.Query(`SELECT id, opens_at, opening_previewed FROM items WHERE opens_at IS NOT NULL ORDER BY opens_at, id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	openings := []Opening{}
+	for rows.Next() {
+		var opening Opening
+		var at int64
+		if err := rows.Scan(&opening.ItemID, &at, &opening.Previewed); err != nil {
+			return nil, err
+		}
+		opening.At = fromUnixMillis(at)
+		openings = append(openings, opening)
+	}
+	return openings, rows.Err()
+}
+
+func (s *sqlStore) PreviewOpening(itemID string, by time.Time) (bool, error) {
+	previewed := false
+	err := s.change(func(tx *sql.Tx) ([]Event, error) {
+		st, err := s.loadItem(tx, itemID)
+		if err != nil {
+			return nil, err
+		}
+		if !st.opensAt.Valid || st.previewed || st.opensAt.Int64 > unixMillis(by) {
+			return nil, nil
+		}
+		if _, err := tx.Exec(s.q(`UPDATE items SET opening_previewed = ? WHERE id = ?`), true, itemID); err != nil {
+			return nil, err
+		}
+		previewed = true
+		return []Event{ItemScheduledEvent{ItemID: itemID, OpensAt: st.opensAt.Int64, Reminder: true}}, nil
+	})
+	return previewed, err
+}
+
+func (s *sqlStore) OpenScheduledItem(itemID string, now time.Time) (bool, error) {
+	opened := false
+	err := s.change(func(tx *sql.Tx) ([]Event, error) {
+		st, err := s.loadItem(tx, itemID)
+		if err != nil {
+			return nil, err
+		}
+		if !st.opensAt.Valid || st.opensAt.Int64 > unixMillis(now) {
+			return nil, nil
+		}
+		events, err := s.openItem(tx, itemID, OpenOptions{}, now)
+		if err != nil {
+			return nil, err
+		}
+		opened = true
+		return events, nil
+	})
+	return opened, err
+}
+
+func (s *sqlStore) NextItem(closeCurrent bool, deadline time.Time, now time.Time) (string, error) {
+	next := ""
+	err := s.change(func(tx *sql.Tx) ([]Event, error) {
+		queue, err := s.queue(tx)
+		if err != nil {
+			return nil, err
+		}
+		if len(queue) == 0 {
+			return nil, ErrQueueEmpty
+		}
+		next = queue[0]
+		var events []Event
+		var current string
+		if err := tx.QueryRow(`SELECT current_item FROM auction WHERE id = 1`).Scan(&current); err != nil {
+			return nil, err
+		}
+		if current != "" {
+			if !closeCurrent {
+				return nil, &RejectedError{Reason: "another item is already up for auction"}
+			}
+			st, err := s.loadItem(tx, current)
+			if err != nil {
+				return nil, err
+			}
+			if !st.open {
+				return nil, ErrItemClosed
+			}
+			if _, events, err = s.finishItem(tx, st.item); err != nil {
+				return nil, err
+			}
+		}
+		opened, err := s.openItem(tx, next, OpenOptions{Deadline: deadline}, now)
+		if err != nil {
+			return nil, err
+		}
+		return append(events, opened...), nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return next, nil
+}
+
+func (s *sqlStore) CurrentItemID() (string, error) {
+	var itemID string
+	if err := s.db.QueryRow(`SELECT current_item FROM auction WHERE id = 1`).Scan(&itemID); err != nil {
+		return "", err
+	}
+	if itemID == "" {
+		return "", ErrNoCurrentItem
+	}
+	return itemID, nil
+}
+
+func (s *sqlStore) OpenItemIDs() ([]string, error) {
+	return s.ids(s.q(`SELECT id FROM items WHERE is_open = ?`), true)
+}
+
+func (s *sqlStore) IsOpen(itemID string) bool {
+	var open bool
+	_ = s.db.QueryRow(s.q(`SELECT is_open FROM items WHERE id = ?`), itemID).Scan(&open)
+	return open
+}
+
+// ids returns the IDs selected by a query.
+func (s *sqlStore) ids(query string, args ...interface{}) ([]string, error) {
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	ids := []string{}
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+const bidColumns = `id, item_id, bidder, bidder_display_name, amount, proxy, buy_now, sealed_bid`
+
+func scanBids(rows *sql.Rows) ([]Bid, error) {
+	defer rows.Close()
+	bids := []Bid{}
+	for rows.Next() {
+		var bid Bid
+		if err := rows.Scan(&bid.ID, &bid.ItemID, &bid.Bidder, &bid.BidderDisplayName, &bid.BidCents, &bid.Proxy, &bid.BuyNow, &bid.SealedBidCents); err != nil {
+			return nil, err
+		}
+		bids = append(bids, bid)
+	}
+	return bids, rows.Err()
+}
+
+// top returns the item's high bid, or nil if it has no bids.
+func (s *sqlStore) top(q querier, itemID string) (*Bid, error) {
+	rows, err := q.Query(s.q(`SELECT `+bidColumns+` FROM bids WHERE item_id = ? ORDER BY seq DESC LIMIT 1`), itemID)
+	if err != nil {
+		return nil, err
+	}
+	bids, err := scanBids(rows)
+	if err != nil || len(bids) == 0 {
+		return nil, err
+	}
+	return &bids[0], nil
+}
+
+func (s *sqlStore) insertBid(tx *sql.Tx, bid Bid, placedAt int64) error {
+	_, err := tx.Exec(s.q(`INSERT INTO bids (`+bidColumns+`, placed_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`),
+		bid.ID, bid.ItemID, bid.Bidder, bid.BidderDisplayName, bid.BidCents, bid.Proxy, bid.BuyNow, bid.SealedBidCents, placedAt)
+	return err
+}
+
+func (s *sqlStore) maxBids(q querier, itemID string) (map[string]MaxBid, error) {
+	rows, err := q.Query(s.q(`SELECT bidder, bidder_display_name, max_amount, set_at FROM max_bids WHERE item_id = ?`), itemID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	maxBids := map[string]MaxBid{}
+	for rows.Next() {
+		var m MaxBid
+		if err := rows.Scan(&m.Bidder, &m.BidderDisplayName, &m.MaxCents, &m.SetAt); err != nil {
+			return nil, err
+		}
+		maxBids[m.Bidder] = m
+	}
+	return maxBids, rows.Err()
+}
+
+func (s *sqlStore) sealedBids(q querier, itemID string) ([]sealedBid, error) {
+	rows, err := q.Query(s.q(`SELECT id, bidder, bidder_display_name, amount, placed_at FROM sealed_bids WHERE item_id = ? ORDER BY amount`), itemID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	sealed := []sealedBid{}
+	for rows.Next() {
+		bid := sealedBid{Bid: Bid{ItemID: itemID}}
+		if err := rows.Scan(&bid.ID, &bid.Bidder, &bid.BidderDisplayName, &bid.BidCents, &bid.PlacedAt); err != nil {
+			return nil, err
+		}
+		sealed = append(sealed, bid)
+	}
+	return sealed, rows.Err()
+}
+
+func (s *sqlStore) bidRules(q querier) (*BidRules, error) {
+	var blob sql.NullString
+	if err := q.QueryRow(`SELECT bid_rules FROM auction WHERE id = 1`).Scan(&blob); err != nil {
+		return nil, err
+	}
+	if !blob.Valid {
+		return nil, nil
+	}
+	var rules BidRules
+	if err := json.Unmarshal([]byte(blob.String), &rules); err != nil {
+		return nil, err
+	}
+	return &rules, nil
+}
+
+func (s *sqlStore) PlaceBid(req BidRequest) error {
+	return s.change(func(tx *sql.Tx) ([]Event, error) {
+		st, err := s.loadItem(tx, req.ItemID)
+		if err != nil {
+			return nil, err
+		}
+		if !st.open {
+			return nil, ErrItemClosed
+		}
+		global, err := s.bidRules(tx)
+		if err != nil {
+			return nil, err
+		}
+		top, err := s.top(tx, req.ItemID)
+		if err != nil {
+			return nil, err
+		}
+		maxBids, err := s.maxBids(tx, req.ItemID)
+		if err != nil {
+			return nil, err
+		}
+		sealed, err := s.sealedBids(tx, req.ItemID)
+		if err != nil {
+			return nil, err
+		}
+		hasSealedBid := false
+		for _, bid := range sealed {
+			hasSealedBid = hasSealedBid || bid.Bidder == req.Bidder
+		}
+		result, err := placeBid(bidState{
+			item:          st.item,
+			rules:         mergeRules(global, st.item.Rules),
+			top:           top,
+			maxBids:       maxBids,
+			sealedBids:    len(sealed),
+			hasSealedBid:  hasSealedBid,
+			deadline:      st.deadline.Int64,
+			extended:      st.extended,
+			dutchPrice:    int(st.dutchPrice.Int64),
+			hasDutchPrice: st.dutchPrice.Valid,
+		}, req)
+		if err != nil {
+			return nil, err
+		}
+		for _, bid := range result.bids {
+			if err := s.insertBid(tx, bid, unixMillis(req.Now)); err != nil {
+				return nil, err
+			}
+		}
+		if m := result.maxBid; m != nil {
+			_, err := tx.Exec(s.q(`INSERT INTO max_bids (item_id, bidder, bidder_display_name, max_amount, set_at) VALUES (?, ?, ?, ?, ?)
+				ON CONFLICT (item_id, bidder) DO UPDATE SET bidder_display_name = excluded.bidder_display_name, max_amount = excluded.max_amount, set_at = excluded.set_at`),
+				req.ItemID, m.Bidder, m.BidderDisplayName, m.MaxCents, m.SetAt)
+			if err != nil {
+				return nil, err
+			}
+		}
+		if b := result.sealedBid; b != nil {
+			_, err := tx.Exec(s.q(`INSERT INTO sealed_bids (item_id, bidder, id, bidder_display_name, amount, placed_at) VALUES (?, ?, ?, ?, ?, ?)
+				ON CONFLICT (item_id, bidder) DO UPDATE SET id = excluded.id, bidder_display_name = excluded.bidder_display_name, amount = excluded.amount, placed_at = excluded.placed_at`),
+				req.ItemID, b.Bidder, b.ID, b.BidderDisplayName, b.BidCents, b.PlacedAt)
+			if err != nil {
+				return nil, err
+			}
+		}
+		if result.extension > 0 {
+			if _, err := tx.Exec(s.q(`UPDATE items SET deadline = ?, extended_by = extended_by + ? WHERE id = ?`), result.deadline, result.extension, req.ItemID); err != nil {
+				return nil, err
+			}
+		}
+		if result.claimed {
+			_, closed, err := s.finishItem(tx, st.item)
+			if err != nil {
+				return nil, err
+			}
+			return append(result.events, closed...), nil
+		}
+		return result.events, nil
+	})
+}
+
+func (s *sqlStore) BuyNow(req BidRequest, cutoff float64) error {
+	return s.change(func(tx *sql.Tx) ([]Event, error) {
+		st, err := s.loadItem(tx, req.ItemID)
+		if err != nil {
+			return nil, err
+		}
+		if !st.open {
+			return nil, ErrItemClosed
+		}
+		top, err := s.top(tx, req.ItemID)
+		if err != nil {
+			return nil, err
+		}
+		bid, err := buyNow(st.item, top, req, st.deadline.Int64, cutoff)
+		if err != nil {
+			return nil, err
+		}
+		if err := s.insertBid(tx, bid, unixMillis(req.Now)); err != nil {
+			return nil, err
+		}
+		changed, err := s.closeItem(tx, st.item, OutcomeSold, &bid)
+		if err != nil {
+			return nil, err
+		}
+		return []Event{BidEvent{Bid: bid}, CloseItemEvent{ItemID: req.ItemID, Outcome: OutcomeSold, BuyNow: true}, changed}, nil
+	})
+}
+
+func (s *sqlStore) DeleteBid(itemID, bidID string) error {
+	return s.change(func(tx *sql.Tx) ([]Event, error) {
+		st, err := s.loadItem(tx, itemID)
+		if err != nil && err != ErrItemNotFound {
+			return nil, err
+		}
+		if st != nil && st.item.Closed {
+			return nil, &RejectedError{Reason: "bids can't be deleted once the item has closed"}
+		}
+		rows, err := tx.Query(s.q(`SELECT `+bidColumns+` FROM bids WHERE item_id = ? AND id = ?`), itemID, bidID)
+		if err != nil {
+			return nil, err
+		}
+		bids, err := scanBids(rows)
+		if err != nil {
+			return nil, err
+		}
+		if len(bids) == 0 {
+			return nil, ErrBidNotFound
+		}
+		if _, err := tx.Exec(s.q(`DELETE FROM bids WHERE item_id = ? AND id = ?`), itemID, bidID); err != nil {
+			return nil, err
+		}
+		bid := bids[0]
+		return []Event{DeleteBidEvent{ItemID: itemID, BidID: bidID, BidCents: bid.BidCents, Bidder: bid.Bidder, BidderDisplayName: bid.BidderDisplayName}}, nil
+	})
+}
+
+func (s *sqlStore) GetBids(itemID string, n int) ([]Bid, error) {
+	query := `SELECT ` + bidColumns + ` FROM bids WHERE item_id = ? ORDER BY seq DESC`
+	args := []interface{}{itemID}
+	if n > 0 {
+		query += ` LIMIT ?`
+		args = append(args, n)
+	}
+	rows, err := s.db.Query(s.q(query), args...)
+	if err != nil {
+		return nil, err
+	}
+	bids, err := scanBids(rows)
+	if err != nil {
+		return nil, err
+	}
+	for i, j := 0, len(bids)-1; i < j; i, j = i+1, j-1 {
+		bids[i], bids[j] = bids[j], bids[i]
+	}
+	return bids, nil
+}
+
+func (s *sqlStore) GetMaxBids(itemID string) ([]MaxBid, error) {
+	maxBids, err := s.maxBids(s.db, itemID)
+	if err != nil {
+		return nil, err
+	}
+	list := make([]MaxBid, 0, len(maxBids))
+	for _, m := range maxBids {
+		list = append(list, m)
+	}
+	return list, nil
+}
+
+func (s *sqlStore) GetMaxBid(itemID, bidder string) (*MaxBid, error) {
+	maxBids, err := s.maxBids(s.db, itemID)
+	if err != nil {
+		return nil, err
+	}
+	m, ok := maxBids[bidder]
+	if !ok {
+		return nil, nil
+	}
+	return &m, nil
+}
+
+func (s *sqlStore) GetSealedBids(itemID string) ([]Bid, error) {
+	sealed, err := s.sealedBids(s.db, itemID)
+	if err != nil {
+		return nil, err
+	}
+	bids := make([]Bid, 0, len(sealed))
+	for _, bid := range sealed {
+		bids = append(bids, bid.Bid)
+	}
+	return bids, nil
+}
+
+func (s *sqlStore) Deadline(itemID string) (time.Time, bool) {
+	var deadline sql.NullInt64
+	if err := s.db.QueryRow(s.q(`SELECT deadline FROM items WHERE id = ?`), itemID).Scan(&deadline); err != nil || !deadline.Valid {
+		return time.Time{}, false
+	}
+	return fromUnixMillis(deadline.Int64), true
+}
+
+// changeDeadline sets the deadline of an open item to whatever change returns
+// given the current one, where zero means no deadline.
+func (s *sqlStore) changeDeadline(itemID string, change func(deadline sql.NullInt64) (int64, error)) (time.Time, error) {
+	var deadline int64
+	err := s.change(func(tx *sql.Tx) ([]Event, error) {
+		st, err := s.loadItem(tx, itemID)
+		if err == ErrItemNotFound || (err == nil && !st.open) {
+			return nil, ErrItemClosed
+		}
+		if err != nil {
+			return nil, err
+		}
+		if st.item.State == StatePaused {
+			return nil, &RejectedError{Reason: "the deadline can't be changed while bidding is paused"}
+		}
+		deadline, err = change(st.deadline)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := tx.Exec(s.q(`UPDATE items SET deadline = ? WHERE id = ?`), sql.NullInt64{Int64: deadline, Valid: deadline != 0}, itemID); err != nil {
+			return nil, err
+		}
+		return []Event{DeadlineChangedEvent{ItemID: itemID, Deadline: deadline}}, nil
+	})
+	if err != nil || deadline == 0 {
+		return time.Time{}, err
+	}
+	return fromUnixMillis(deadline), nil
+}
+
+func (s *sqlStore) SetDeadline(itemID string, deadline time.Time) error {
+	_, err := s.changeDeadline(itemID, func(sql.NullInt64) (int64, error) {
+		return unixMillis(deadline), nil
+	})
+	return err
+}
+
+func (s *sqlStore) ExtendDeadline(itemID string, by time.Duration) (time.Time, error) {
+	return s.changeDeadline(itemID, func(deadline sql.NullInt64) (int64, error) {
+		if !deadline.Valid {
+			return 0, &RejectedError{Reason: "that item has no deadline to extend"}
+		}
+		return deadline.Int64 + int64(by/time.Millisecond), nil
+	})
+}
+
+func (s *sqlStore) CancelDeadline(itemID string) error {
+	_, err := s.changeDeadline(itemID, func(sql.NullInt64) (int64, error) {
+		return 0, nil
+	})
+	return err
+}
+
+func (s *sqlStore) ExpiredItems(now time.Time) ([]string, error) {
+	return s.ids(s.q(`SELECT id FROM items WHERE is_open = ? AND deadline <= ? ORDER BY deadline`), true, unixMillis(now))
+}
+
+func (s *sqlStore) CurrentPrice(itemID string) (int, time.Time, error) {
+	var price, nextDrop sql.NullInt64
+	err := s.db.QueryRow(s.q(`SELECT dutch_price, next_price_drop FROM items WHERE id = ?`), itemID).Scan(&price, &nextDrop)
+	if err == sql.ErrNoRows || (err == nil && !price.Valid) {
+		return 0, time.Time{}, errNoPrice
+	}
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+	if !nextDrop.Valid {
+		return int(price.Int64), time.Time{}, nil
+	}
+	return int(price.Int64), fromUnixMillis(nextDrop.Int64), nil
+}
+
+func (s *sqlStore) DuePriceDrops(now time.Time) ([]string, error) {
+	return s.ids(s.q(`SELECT id FROM items WHERE next_price_drop <= ? ORDER BY next_price_drop`), unixMillis(now))
+}
+
+func (s *sqlStore) DropPrice(itemID string, now time.Time) error {
+	return s.change(func(tx *sql.Tx) ([]Event, error) {
+		st, err := s.loadItem(tx, itemID)
+		if err != nil {
+			return nil, err
+		}
+		if !st.dutchPrice.Valid || !st.nextDrop.Valid || st.item.Dutch == nil || st.nextDrop.Int64 > unixMillis(now) {
+			return nil, nil
+		}
+		price, nextDrop := dropPrice(*st.item.Dutch, int(st.dutchPrice.Int64), st.nextDrop.Int64, unixMillis(now))
+		if _, err := tx.Exec(s.q(`UPDATE items SET dutch_price = ?, next_price_drop = ? WHERE id = ?`), price, sql.NullInt64{Int64: nextDrop, Valid: nextDrop != 0}, itemID); err != nil {
+			return nil, err
+		}
+		return []Event{PriceDroppedEvent{ItemID: itemID, PriceCents: price, NextDrop: nextDrop}}, nil
+	})
+}
+
+func (s *sqlStore) BidRules() (BidRules, error) {
+	rules, err := s.bidRules(s.db)
+	if err != nil {
+		return BidRules{}, err
+	}
+	if rules == nil {
+		return DefaultBidRules, nil
+	}
+	return *rules, nil
+}
+
+func (s *sqlStore) SetBidRules(rules BidRules) error {
+	blob, err := json.Marshal(rules)
+	if err != nil {
+		return err
+	}
+	return s.change(func(tx *sql.Tx) ([]Event, error) {
+		_, err := tx.Exec(s.q(`UPDATE auction SET bid_rules = ? WHERE id = 1`), string(blob))
+		return nil, err
+	})
+}
+
+func (s *sqlStore) Archive() error {
+	return s.transact(func(tx *sql.Tx) ([]Event, error) {
+		var open, queued, scheduled int
+		if err := tx.QueryRow(s.q(`SELECT COUNT(*) FROM items WHERE is_open = ?`), true).Scan(&open); err != nil {
+			return nil, err
+		}
+		if err := tx.QueryRow(`SELECT COUNT(*) FROM run_queue`).Scan(&queued); err != nil {
+			return nil, err
+		}
+		if err := tx.QueryRow(`SELECT COUNT(*) FROM items WHERE opens_at IS NOT NULL`).Scan(&scheduled); err != nil {
+			return nil, err
+		}
+		if err := checkArchivable(open, queued, scheduled); err != nil {
+			return nil, err
+		}
+		result, err := tx.Exec(s.q(`UPDATE auction SET archived = ? WHERE id = 1 AND archived = ?`), true, false)
+		if err != nil {
+			return nil, err
+		}
+		if n, err := result.RowsAffected(); err != nil || n == 0 {
+			return nil, err
+		}
+		return []Event{AuctionArchivedEvent{}}, nil
+	})
+}
+
+func (s *sqlStore) Archived() (bool, error) {
+	var archived bool
+	err := s.db.QueryRow(`SELECT archived FROM auction WHERE id = 1`).Scan(&archived)
+	return archived, err
+}
+
+func (s *sqlStore) TotalRaised() (int, error) {
+	var total int
+	err := s.db.QueryRow(`SELECT total_raised FROM auction WHERE id = 1`).Scan(&total)
+	return total, err
+}
+
+func (s *sqlStore) ReconcileTotal(fix bool) (int, int, error) {
+	var recorded, computed int
+	err := s.transact(func(tx *sql.Tx) ([]Event, error) {
+		if err := tx.QueryRow(`SELECT total_raised FROM auction WHERE id = 1`).Scan(&recorded); err != nil {
+			return nil, err
+		}
+		rows, err := tx.Query(s.q(`SELECT id FROM items WHERE closed = ? AND outcome = ? AND state <> ?`), true, OutcomeSold, StateVoided)
+		if err != nil {
+			return nil, err
+		}
+		var sold []string
+		for rows.Next() {
+			var id string
+			if err := rows.Scan(&id); err != nil {
+				_ = rows.Close()
+				return nil, err
+			}
+			sold = append(sold, id)
+		}
+		_ = rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+		for _, itemID := range sold {
+			top, err := s.top(tx, itemID)
+			if err != nil {
+				return nil, err
+			}
+			if top != nil {
+				computed += top.BidCents
+			}
+		}
+		if fix {
+			if err := s.checkLive(tx); err != nil {
+				return nil, err
+			}
+			if _, err := tx.Exec(s.q(`UPDATE auction SET total_raised = ? WHERE id = 1`), computed); err != nil {
+				return nil, err
+			}
+		}
+		return nil, nil
+	})
+	return recorded, computed, err
+}
+
+func (s *sqlStore) Subscribe(after string) (<-chan LogEntry, func(), error) {
+	return s.events.subscribe(after, func(after string) ([]LogEntry, error) {
+		return s.EventLog(after, "", 0)
+	})
+}
+
+func (s *sqlStore) EventLog(after, itemID string, limit int) ([]LogEntry, error) {
+	var start int64
+	if after != "" {
+		var err error
+		if start, err = parseLocalEventID(after); err != nil {
+			return nil, err
+		}
+	}
+	query := `SELECT seq, item_id, payload FROM events WHERE seq > ?`
+	args := []interface{}{start}
+	if itemID != "" {
+		query += ` AND item_id = ?`
+		args = append(args, itemID)
+	}
+	query += ` ORDER BY seq`
+	if limit > 0 {
+		query += ` LIMIT ?`
+		args = append(args, limit)
+	}
+	rows, err := s.db.Query(s.q(query), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	entries := []LogEntry{}
+	for rows.Next() {
+		var seq int64
+		var entry LogEntry
+		if err := rows.Scan(&seq, &entry.ItemID, &entry.Payload); err != nil {
+			return nil, err
+		}
+		entry.ID = strconv.FormatInt(seq, 10)
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
+
+func (s *sqlStore) LastEventID() (string, error) {
+	var seq sql.NullInt64
+	if err := s.db.QueryRow(`SELECT MAX(seq) FROM events`).Scan(&seq); err != nil || !seq.Valid {
+		return "", err
+	}
+	return strconv.FormatInt(seq.Int64, 10), nil
+}
+
+func (s *sqlStore) Close() error {
+	s.events.close()
+	return s.db.Close()
+}
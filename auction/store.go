@@ -0,0 +1,221 @@
+package auction
+
+import (
+	"fmt"
+	"time"
+)
+
+// Store holds the state of an auction: its items, bids, deadlines and totals.
+// Every method is atomic, and a change is logged as an event by the same
+// operation that makes it, so subscribers never see a change that didn't
+// happen, or miss one that did. Every change to an item's state also publishes
+// an ItemStateChangedEvent.
+//
+// Stores return the same errors as Auction, such as ErrItemNotFound.
+// NewRedisStore, NewMemoryStore and OpenSQLStore return the implementations,
+// and the storetest package checks that a Store behaves the way they do. Each
+// store holds one auction, and the redis and SQL stores keep several auctions
+// in the same database apart by namespace.
+type Store interface {
+	// CreateItem stores a new item, which must already have an ID.
+	CreateItem(item Item) error
+	// UpdateItem replaces an item with the result of calling update on it.
+	// update also reports whether the change is destructive, meaning that it
+	// can't be made while the item is open or once it has bids. update may be
+	// called more than once, and must not use the store.
+	UpdateItem(itemID string, update func(old Item) (Item, bool, error)) (*Item, error)
+	// DeleteItem removes an item that isn't open or scheduled, and has no
+	// bids.
+	DeleteItem(itemID string) error
+	GetItem(itemID string) (*Item, error)
+	GetItems() ([]Item, error)
+	GetItemByExternalID(externalID string) (*Item, error)
+
+	// OpenItem opens an item for bidding, and publishes an OpenItemEvent. It
+	// returns a TransitionError if the item's state can't move to open.
+	// Reopening a sold item takes its price back off the total raised. Unless
+	// the item is opened silently, the item it replaces as the current item
+	// goes back to being approved. An item opened from the run queue is taken
+	// off it, and any scheduled opening is cancelled.
+	OpenItem(itemID string, options OpenOptions, now time.Time) error
+	// CloseItem closes an open or paused item, reveals any sealed bids,
+	// decides the outcome, adds the price of a sold item to the total raised,
+	// and publishes a CloseItemEvent. If expiredBy is set, the item is only
+	// closed if its deadline is no later than that, and otherwise the outcome
+	// is "".
+	CloseItem(itemID string, expiredBy time.Time) (string, error)
+	// PauseItem stops bidding on an open item without closing it, and
+	// publishes a PauseItemEvent. The item's deadline and any dutch auction
+	// price drop are frozen, with as long to go as they had left, until it
+	// is resumed.
+	PauseItem(itemID string, now time.Time) error
+	// ResumeItem restarts bidding on a paused item, starting the clock on its
+	// deadline and price drops again, and publishes a ResumeItemEvent.
+	ResumeItem(itemID string, now time.Time) error
+	// SetItemState moves an item to one of the states that nothing else
+	// happens in, such as StatePaid, or returns a TransitionError. Voiding a
+	// sold item takes its price off the total raised, and moving a scheduled
+	// item takes it off the run queue and cancels its opening.
+	SetItemState(itemID, state string) error
+	// Queue returns the IDs of the items in the run queue, next first.
+	Queue() ([]string, error)
+	// QueueItem schedules an item by adding it to the run queue at the given
+	// position, counting from zero, or at the end if the position is negative
+	// or past the end. It publishes a QueueChangedEvent.
+	QueueItem(itemID string, position int) error
+	// MoveQueuedItem moves an item that is already in the run queue to
+	// another position, as QueueItem would place it.
+	MoveQueuedItem(itemID string, position int) error
+	// UnqueueItem takes an item off the run queue, and approves it again
+	// unless it is scheduled to open.
+	UnqueueItem(itemID string) error
+	// NextItem opens the item at the front of the run queue as the current
+	// item, taking it off the queue, and returns its ID. If there is already
+	// a current item, it is closed first if closeCurrent is set, and
+	// otherwise nothing changes and a RejectedError is returned. It returns
+	// ErrQueueEmpty if there is nothing to open.
+	NextItem(closeCurrent bool, deadline time.Time, now time.Time) (string, error)
+	// ScheduleOpening schedules an item to open at the given time, or
+	// changes the time if it already has one, and publishes an
+	// ItemScheduledEvent. Queued items keep their place in the run queue.
+	ScheduleOpening(itemID string, at time.Time) error
+	// CancelOpening cancels an item's scheduled opening, and publishes an
+	// ItemScheduledEvent. Unless it is queued, the item is approved again.
+	CancelOpening(itemID string) error
+	// Openings returns every scheduled opening, soonest first.
+	Openings() ([]Opening, error)
+	// PreviewOpening publishes an ItemScheduledEvent reminding everyone of
+	// the item's opening, if it is due by the given time and hasn't been
+	// previewed yet, and reports whether it did.
+	PreviewOpening(itemID string, by time.Time) (bool, error)
+	// OpenScheduledItem opens an item as the current item, as OpenItem does,
+	// if its scheduled opening is due by now, and reports whether it did.
+	OpenScheduledItem(itemID string, now time.Time) (bool, error)
+	// Archive marks the auction as archived once it is over, meaning nothing
+	// is open, queued or scheduled to open, and publishes an
+	// AuctionArchivedEvent. Once it is archived, every method that would
+	// change the auction returns ErrAuctionArchived instead, checking in the
+	// same operation as the change.
+	Archive() error
+	// Archived reports whether the auction has been archived.
+	Archived() (bool, error)
+	// CurrentItemID returns the item that is up for live auction, or
+	// ErrNoCurrentItem.
+	CurrentItemID() (string, error)
+	OpenItemIDs() ([]string, error)
+	IsOpen(itemID string) bool
+
+	// PlaceBid applies the bid rules to a bid or maximum bid, places it along
+	// with any automatic bids it provokes, extends the deadline inside the
+	// soft close window, and publishes the results. A bid that claims a dutch
+	// auction item closes it too. Bids on paused items fail with ErrItemPaused.
+	PlaceBid(bid BidRequest) error
+	// BuyNow records a bid at the item's buy-now price, closes it and adds the
+	// price to the total raised, unless the high bid is more than cutoff times
+	// the buy-now price.
+	BuyNow(bid BidRequest, cutoff float64) error
+	DeleteBid(itemID, bidID string) error
+	// GetBids returns the last n bids on the item, highest last, or all of
+	// them if n is zero.
+	GetBids(itemID string, n int) ([]Bid, error)
+	GetMaxBids(itemID string) ([]MaxBid, error)
+	GetMaxBid(itemID, bidder string) (*MaxBid, error)
+	// GetSealedBids returns the unrevealed bids on a sealed-bid item, lowest
+	// first.
+	GetSealedBids(itemID string) ([]Bid, error)
+
+	// Deadline returns the item's deadline, if it has one and isn't paused.
+	// Deadlines can't be changed while an item is paused.
+	Deadline(itemID string) (time.Time, bool)
+	SetDeadline(itemID string, deadline time.Time) error
+	ExtendDeadline(itemID string, by time.Duration) (time.Time, error)
+	CancelDeadline(itemID string) error
+	// ExpiredItems returns the open items whose deadlines are no later than now.
+	ExpiredItems(now time.Time) ([]string, error)
+
+	// CurrentPrice returns the current price of an open dutch auction item, and
+	// when it will next drop, which is zero if it won't.
+	CurrentPrice(itemID string) (int, time.Time, error)
+	// DuePriceDrops returns the dutch auction items whose price is due to drop.
+	DuePriceDrops(now time.Time) ([]string, error)
+	// DropPrice drops the item's price as many times as it is due to have
+	// dropped by now, and publishes a PriceDroppedEvent.
+	DropPrice(itemID string, now time.Time) error
+
+	BidRules() (BidRules, error)
+	SetBidRules(rules BidRules) error
+	TotalRaised() (int, error)
+	// ReconcileTotal recomputes the total raised from the winning bid on every
+	// item that was sold and hasn't been voided, and returns it along with the
+	// recorded total. If fix is set, the recorded total is replaced by the
+	// computed one in the same operation.
+	ReconcileTotal(fix bool) (recorded, computed int, err error)
+
+	// Subscribe returns a channel of every event logged after the given ID, and
+	// a function that stops the subscription and closes the channel. An empty
+	// ID subscribes to the events logged from now on, and "0" replays the
+	// whole log first.
+	Subscribe(after string) (<-chan LogEntry, func(), error)
+	// EventLog returns up to limit events logged after the given ID, oldest
+	// first. If itemID isn't empty, only events about that item are returned.
+	// A limit of zero or less returns every event. Stores may trim old
+	// events from the log, as the redis store does, in which case events
+	// can't be replayed from before the oldest one kept.
+	EventLog(after, itemID string, limit int) ([]LogEntry, error)
+	// LastEventID returns the ID of the last event logged, or an empty string
+	// if nothing has been logged yet.
+	LastEventID() (string, error)
+	Close() error
+}
+
+// LogEntry is an event as it is kept in the event log. IDs increase with every
+// event logged, but their form depends on the store.
+type LogEntry struct {
+	ID     string
+	ItemID string
+	// Payload is the event as JSON.
+	Payload string
+}
+
+// Opening is a time at which an item is scheduled to open.
+type Opening struct {
+	ItemID string
+	At     time.Time
+	// Previewed is set once the opening's reminder has been published.
+	Previewed bool
+}
+
+// BidRequest asks a Store to place a bid. Amounts are in cents.
+type BidRequest struct {
+	ItemID      string
+	Cents       int
+	Bidder      string
+	DisplayName string
+	// BidID is the ID of the bid. Automatic bids it provokes get IDs based on
+	// it.
+	BidID string
+	// Max sets the bidder's maximum bid, rather than placing a bid.
+	Max bool
+	// Take claims a dutch auction item at whatever its current price is.
+	Take bool
+	Now  time.Time
+}
+
+// CheckNamespace checks that a namespace can keep an auction apart from others
+// in the same database. Namespaces are at most 63 lowercase letters, digits,
+// dashes and underscores, starting with a letter or digit, so that they can
+// also name a database schema or file, or be part of a URL.
+func CheckNamespace(namespace string) error {
+	if namespace == "" || len(namespace) > 63 {
+		return fmt.Errorf("invalid auction ID %q: it must be 1 to 63 characters long", namespace)
+	}
+	for i, c := range namespace {
+		switch {
+		case c >= 'a' && c <= 'z', c >= '0' && c <= '9':
+		case (c == '-' || c == '_') && i > 0:
+		default:
+			return fmt.Errorf("invalid auction ID %q: it can only have lowercase letters, digits, dashes and underscores, and must start with a letter or digit", namespace)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,50 @@
+package auction_test
+
+import (
+	"os"
+	"testing"
+
+	"github.com/go-redis/redis/v7"
+	_ "github.com/mattn/go-sqlite3"
+
+	"github.com/PonyFest/auction-bot/auction"
+	"github.com/PonyFest/auction-bot/auction/storetest"
+)
+
+func TestMemoryStore(t *testing.T) {
+	storetest.Run(t, func() (auction.Store, error) {
+		return auction.NewMemoryStore(), nil
+	})
+}
+
+func TestSQLiteStore(t *testing.T) {
+	storetest.Run(t, func() (auction.Store, error) {
+		return auction.OpenSQLStore(auction.DialectSQLite, "file::memory:", "")
+	})
+}
+
+// TestRedisStore runs the checks against the redis database at REDIS_URL, if
+// it is set. The database must be empty, and is emptied again afterwards.
+func TestRedisStore(t *testing.T) {
+	url := os.Getenv("REDIS_URL")
+	if url == "" {
+		t.Skip("REDIS_URL isn't set")
+	}
+	options, err := redis.ParseURL(url)
+	if err != nil {
+		t.Fatalf("invalid redis URL %q: %v", url, err)
+	}
+	r := redis.NewClient(options)
+	defer r.Close()
+	size, err := r.DBSize().Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size != 0 {
+		t.Fatal("the redis database isn't empty, and the checks would wipe it")
+	}
+	defer r.FlushDB()
+	storetest.Run(t, func() (auction.Store, error) {
+		return auction.NewRedisStore(r, "", auction.DefaultEventLogLength), r.FlushDB().Err()
+	})
+}
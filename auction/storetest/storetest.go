@@ -0,0 +1,1439 @@
+// Package storetest checks that an auction.Store behaves the way the auction
+// expects: that bids are checked and placed atomically, that totals and
+// outcomes are kept, and that every change is logged and published as an
+// event.
+//
+// The same checks run against every store, from the tests of the auction
+// package, so a new store only has to be added there to be tried out before the
+// auction is trusted to it.
+package storetest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/PonyFest/auction-bot/auction"
+)
+
+// eventTimeout is how long a check waits for an event it expects.
+const eventTimeout = 2 * time.Second
+
+// A Check is one conformance check. It is given an auction kept in an empty
+// store, along with the store itself.
+type Check struct {
+	Name string
+	Run  func(t *testing.T, a *auction.Auction, s auction.Store)
+}
+
+// Run runs every check as a subtest, each against a new, empty store returned
+// by newStore.
+func Run(t *testing.T, newStore func() (auction.Store, error)) {
+	for _, check := range Checks {
+		check := check
+		t.Run(check.Name, func(t *testing.T) {
+			s, err := newStore()
+			if err != nil {
+				t.Fatalf("couldn't create store: %v", err)
+			}
+			a := auction.New(s)
+			defer a.Close()
+			check.Run(t, a, s)
+		})
+	}
+}
+
+// Checks are the conformance checks, in the order Run runs them.
+var Checks = []Check{
+	{"items", checkItems},
+	{"external IDs", checkExternalIDs},
+	{"bid rules", checkBidRules},
+	{"maximum bids", checkMaxBids},
+	{"outcomes and totals", checkOutcomes},
+	{"reconciling totals", checkReconcile},
+	{"item states", checkStates},
+	{"current item", checkCurrentItem},
+	{"run queue", checkQueue},
+	{"scheduled openings", checkOpenings},
+	{"archiving", checkArchiving},
+	{"sealed bids", checkSealedBids},
+	{"dutch auctions", checkDutch},
+	{"buy now", checkBuyNow},
+	{"deadlines", checkDeadlines},
+	{"soft close", checkSoftClose},
+	{"pausing", checkPause},
+	{"deleting bids", checkDeleteBid},
+	{"events", checkEvents},
+	{"event log", checkEventLog},
+	{"event subscribers", checkEventSubscribers},
+	{"concurrent bids", checkConcurrentBids},
+}
+
+func expect(err error, want error) error {
+	if err != want {
+		return fmt.Errorf("got error %v, want %v", err, want)
+	}
+	return nil
+}
+
+func expectRejected(err error) error {
+	var rejected *auction.RejectedError
+	if !errors.As(err, &rejected) {
+		return fmt.Errorf("got error %v, want a rejection", err)
+	}
+	return nil
+}
+
+func expectTooLow(err error, minimum int) error {
+	var tooLow *auction.ErrBidTooLow
+	if !errors.As(err, &tooLow) {
+		return fmt.Errorf("got error %v, want a bid that is too low", err)
+	}
+	if tooLow.Minimum != minimum {
+		return fmt.Errorf("got minimum bid %d, want %d", tooLow.Minimum, minimum)
+	}
+	return nil
+}
+
+// expectBids checks the amounts and bidders of the item's bids, in order.
+func expectBids(a *auction.Auction, itemID string, want ...string) error {
+	bids, err := a.GetTopBids(itemID, 0)
+	if err != nil {
+		return err
+	}
+	got := make([]string, 0, len(bids))
+	for _, bid := range bids {
+		got = append(got, fmt.Sprintf("%s:%d", bid.Bidder, bid.BidCents))
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		return fmt.Errorf("got bids %v, want %v", got, want)
+	}
+	return nil
+}
+
+func expectTotal(a *auction.Auction, want int) error {
+	if got := a.TotalRaisedCents(); got != want {
+		return fmt.Errorf("got total raised %d, want %d", got, want)
+	}
+	return nil
+}
+
+func expectOutcome(a *auction.Auction, itemID string, want string) error {
+	item, err := a.GetItem(itemID)
+	if err != nil {
+		return err
+	}
+	if !item.Closed || item.Outcome != want {
+		return fmt.Errorf("got closed %v with outcome %q, want outcome %q", item.Closed, item.Outcome, want)
+	}
+	return nil
+}
+
+func expectState(a *auction.Auction, itemID string, want string) error {
+	item, err := a.GetItem(itemID)
+	if err != nil {
+		return err
+	}
+	if item.State != want {
+		return fmt.Errorf("got state %q, want %q", item.State, want)
+	}
+	return nil
+}
+
+func expectTransitionError(err error) error {
+	var transition *auction.TransitionError
+	if !errors.As(err, &transition) {
+		return fmt.Errorf("got error %v, want an illegal transition", err)
+	}
+	return nil
+}
+
+// openItem creates an item and opens it for live auction.
+func openItem(a *auction.Auction, item auction.Item) (*auction.Item, error) {
+	created, err := a.CreateItem(item)
+	if err != nil {
+		return nil, err
+	}
+	return created, a.OpenItem(created.ID, auction.OpenOptions{})
+}
+
+// first returns the first error that isn't nil.
+func first(errs ...error) error {
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func checkItems(t *testing.T, a *auction.Auction, s auction.Store) {
+	item, err := a.CreateItem(auction.Item{Title: "Plush", StartBid: 500, Images: []string{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := a.GetItem(item.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Title != "Plush" || got.StartBid != 500 || got.Closed {
+		t.Fatalf("got item %+v back", got)
+	}
+	item.Title = "Plushie"
+	if _, err := a.UpdateItem(item.ID, *item); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := a.GetItem(item.ID); got == nil || got.Title != "Plushie" {
+		t.Fatal("the update wasn't stored")
+	}
+	if err := a.OpenItem(item.ID, auction.OpenOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	item.StartBid = 100
+	if _, err := a.UpdateItem(item.ID, *item); expectRejected(err) != nil {
+		t.Fatalf("changing the starting bid of an open item: %v", expectRejected(err))
+	}
+	if err := expectRejected(a.DeleteItem(item.ID)); err != nil {
+		t.Fatalf("deleting an open item: %v", err)
+	}
+	if err := a.Bid(item.ID, 500, "alice", "Alice"); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.CloseItem(item.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := expectRejected(a.DeleteItem(item.ID)); err != nil {
+		t.Fatalf("deleting an item with bids: %v", err)
+	}
+	other, err := a.CreateItem(auction.Item{Title: "Other"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := a.DeleteItem(other.ID); err != nil {
+		t.Fatal(err)
+	}
+	items, err := a.GetItems()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 1 || items[0].ID != item.ID {
+		t.Fatalf("got items %+v, want only %q", items, item.ID)
+	}
+	if err := first(
+		expect(a.DeleteItem(other.ID), auction.ErrItemNotFound),
+		expect(a.OpenItem("nonexistent", auction.OpenOptions{}), auction.ErrItemNotFound),
+		expect(a.Bid("nonexistent", 100, "alice", "Alice"), auction.ErrItemNotFound),
+	); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func checkExternalIDs(t *testing.T, a *auction.Auction, s auction.Store) {
+	item, err := a.CreateItem(auction.Item{Title: "Plush", ExternalID: "A1", Mode: auction.ModeSealed, StartBid: 500})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.CreateItem(auction.Item{Title: "Copy", ExternalID: "A1"}); expectRejected(err) != nil {
+		t.Fatalf("reusing an external ID: %v", expectRejected(err))
+	}
+	found, err := a.FindItem("A1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found.ID != item.ID {
+		t.Fatalf("found %q by external ID, want %q", found.ID, item.ID)
+	}
+	item.ExternalID = "A2"
+	if _, err := a.UpdateItem(item.ID, *item); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.GetItemByExternalID("A1"); err != auction.ErrItemNotFound {
+		t.Fatalf("the old external ID still finds an item: %v", err)
+	}
+	rename := func(item *auction.Item) {
+		item.Title = "Renamed"
+	}
+	if created, err := a.CheckUpsert("A2", rename); err != nil || created {
+		t.Fatalf("checking an upsert of an existing item: created %v, error %v", created, err)
+	}
+	if _, created, err := a.UpsertItem("A2", rename); err != nil || created {
+		t.Fatalf("upserting an existing item: created %v, error %v", created, err)
+	}
+	got, err := a.GetItem(item.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Upserting only changes the fields it sets.
+	if got.Title != "Renamed" || got.Mode != auction.ModeSealed || got.StartBid != 500 {
+		t.Fatalf("got %+v after upserting a new title", got)
+	}
+	// Checking an upsert finds the same problems as upserting.
+	lower := func(item *auction.Item) {
+		item.StartBid = 100
+	}
+	if err := a.OpenItem(item.ID, auction.OpenOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.CheckUpsert("A2", lower); expectRejected(err) != nil {
+		t.Fatalf("checking an upsert of an open item: %v", expectRejected(err))
+	}
+	if _, _, err := a.UpsertItem("A2", lower); expectRejected(err) != nil {
+		t.Fatalf("upserting an open item: %v", expectRejected(err))
+	}
+	if created, err := a.CheckUpsert("A3", rename); err != nil || !created {
+		t.Fatalf("checking an upsert of a new item: created %v, error %v", created, err)
+	}
+}
+
+func checkBidRules(t *testing.T, a *auction.Auction, s auction.Store) {
+	if err := a.SetBidRules(auction.BidRules{
+		Increments: []auction.IncrementStep{{Below: 1000, Increment: 100}, {Increment: 500}},
+		MaxJump:    5000,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	item, err := openItem(a, auction.Item{Title: "Plush", StartBid: 500, Rules: &auction.BidRules{Cap: 10000}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := first(
+		expectTooLow(a.Bid(item.ID, 400, "alice", "Alice"), 500),
+		a.Bid(item.ID, 900, "alice", "Alice"),
+		expectTooLow(a.Bid(item.ID, 950, "bob", "Bob"), 1000),
+		a.Bid(item.ID, 1000, "bob", "Bob"),
+		expectTooLow(a.Bid(item.ID, 1400, "alice", "Alice"), 1500),
+		expectRejected(a.Bid(item.ID, 7000, "alice", "Alice")),
+		a.Bid(item.ID, 6000, "alice", "Alice"),
+		expectRejected(a.Bid(item.ID, 10500, "bob", "Bob")),
+		expectBids(a, item.ID, "alice:900", "bob:1000", "alice:6000"),
+		// The item's rules and soft close can't change once it has bids.
+		expectRejected(errorOf(a.UpdateItem(item.ID, auction.Item{Title: "Plush", StartBid: 500, Rules: &auction.BidRules{Cap: 20000}}))),
+		expectRejected(errorOf(a.UpdateItem(item.ID, auction.Item{Title: "Plush", StartBid: 500, Rules: &auction.BidRules{Cap: 10000}, SoftClose: &auction.SoftClose{Window: 30, Extension: 30}}))),
+		errorOf(a.UpdateItem(item.ID, auction.Item{Title: "Renamed", StartBid: 500, Rules: &auction.BidRules{Cap: 10000}})),
+	); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func checkMaxBids(t *testing.T, a *auction.Auction, s auction.Store) {
+	item, err := openItem(a, auction.Item{Title: "Plush", StartBid: 500})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := first(
+		a.SetMaxBid(item.ID, 2000, "alice", "Alice"),
+		expectBids(a, item.ID, "alice:500"),
+		a.Bid(item.ID, 1000, "bob", "Bob"),
+		expectBids(a, item.ID, "alice:500", "bob:1000", "alice:1100"),
+		a.SetMaxBid(item.ID, 3000, "bob", "Bob"),
+		expectBids(a, item.ID, "alice:500", "bob:1000", "alice:1100", "bob:2100"),
+		expectTooLow(a.SetMaxBid(item.ID, 2000, "bob", "Bob"), 2100),
+	); err != nil {
+		t.Fatal(err)
+	}
+	maxBid, err := a.MaxBid(item.ID, "bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if maxBid == nil || maxBid.MaxCents != 3000 {
+		t.Fatalf("got maximum bid %+v, want 3000", maxBid)
+	}
+	if maxBid, err := a.MaxBid(item.ID, "carol"); maxBid != nil || err != nil {
+		t.Fatalf("got maximum bid %+v and error %v for a bidder with none", maxBid, err)
+	}
+	maxBids, err := a.GetMaxBids(item.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(maxBids) != 2 {
+		t.Fatalf("got %d maximum bids, want 2", len(maxBids))
+	}
+}
+
+func checkOutcomes(t *testing.T, a *auction.Auction, s auction.Store) {
+	sold, err := openItem(a, auction.Item{Title: "Sold", StartBid: 500})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := first(
+		a.Bid(sold.ID, 700, "alice", "Alice"),
+		a.CloseItem(sold.ID),
+		expectOutcome(a, sold.ID, auction.OutcomeSold),
+		expectTotal(a, 700),
+		expect(a.CloseItem(sold.ID), auction.ErrItemClosed),
+		expect(a.Bid(sold.ID, 800, "bob", "Bob"), auction.ErrItemClosed),
+	); err != nil {
+		t.Fatal(err)
+	}
+	reserved, err := openItem(a, auction.Item{Title: "Reserved", StartBid: 500, Reserve: 2000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := first(
+		a.Bid(reserved.ID, 600, "alice", "Alice"),
+		a.CloseItem(reserved.ID),
+		expectOutcome(a, reserved.ID, auction.OutcomeReserveNotMet),
+		expectTotal(a, 700),
+	); err != nil {
+		t.Fatal(err)
+	}
+	unsold, err := openItem(a, auction.Item{Title: "Unsold"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := first(
+		a.CloseItem(unsold.ID),
+		expectOutcome(a, unsold.ID, auction.OutcomeUnsold),
+		// Reopening a sold item takes it back off the total.
+		a.OpenItem(sold.ID, auction.OpenOptions{}),
+		expectTotal(a, 0),
+		a.Bid(sold.ID, 800, "bob", "Bob"),
+		a.CloseItem(sold.ID),
+		expectTotal(a, 800),
+	); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func checkReconcile(t *testing.T, a *auction.Auction, s auction.Store) {
+	expectReconciled := func(recorded, computed int) error {
+		r, err := a.ReconcileTotal(false)
+		if err != nil {
+			return err
+		}
+		if r.Recorded != recorded || r.Computed != computed || r.Fixed {
+			return fmt.Errorf("got reconciliation %+v, want %d recorded and %d computed", *r, recorded, computed)
+		}
+		return nil
+	}
+	var items []*auction.Item
+	for _, item := range []auction.Item{{Title: "Sold"}, {Title: "Reserved", Reserve: 2000}, {Title: "Open"}} {
+		created, err := a.CreateItem(item)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := a.OpenItem(created.ID, auction.OpenOptions{Silent: true}); err != nil {
+			t.Fatal(err)
+		}
+		items = append(items, created)
+	}
+	sold, reserved, open := items[0], items[1], items[2]
+	if err := first(
+		expectReconciled(0, 0),
+		a.Bid(sold.ID, 700, "alice", "Alice"),
+		a.CloseItem(sold.ID),
+		a.Bid(reserved.ID, 600, "alice", "Alice"),
+		a.CloseItem(reserved.ID),
+		a.Bid(open.ID, 900, "bob", "Bob"),
+		expectReconciled(700, 700),
+		// Reopening and closing again keeps the totals in step.
+		a.OpenItem(sold.ID, auction.OpenOptions{Silent: true}),
+		expectReconciled(0, 0),
+		a.Bid(sold.ID, 800, "bob", "Bob"),
+		a.CloseItem(sold.ID),
+		expectReconciled(800, 800),
+	); err != nil {
+		t.Fatal(err)
+	}
+	r, err := a.ReconcileTotal(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Fixed || r.Discrepancy() != 0 {
+		t.Fatalf("got reconciliation %+v for matching totals", *r)
+	}
+	if err := expectTotal(a, 800); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func checkStates(t *testing.T, a *auction.Auction, s auction.Store) {
+	draft, err := a.CreateItem(auction.Item{Title: "Plush", State: auction.StateDraft})
+	if err != nil {
+		t.Fatal(err)
+	}
+	voided, err := a.CreateItem(auction.Item{Title: "Print"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := first(
+		expectState(a, draft.ID, auction.StateDraft),
+		expectState(a, voided.ID, auction.StateApproved),
+		expectTransitionError(a.OpenItem(draft.ID, auction.OpenOptions{})),
+		a.SetItemState(draft.ID, auction.StateApproved),
+		a.OpenItem(draft.ID, auction.OpenOptions{}),
+		expectState(a, draft.ID, auction.StateOpen),
+		// Items that are up for auction have to be closed first.
+		expectTransitionError(a.SetItemState(draft.ID, auction.StatePaid)),
+		a.Bid(draft.ID, 500, "alice", "Alice"),
+		// Opening another item takes the current one off the auction.
+		a.OpenItem(voided.ID, auction.OpenOptions{}),
+		expectState(a, draft.ID, auction.StateApproved),
+		a.OpenItem(draft.ID, auction.OpenOptions{}),
+		a.CloseItem(draft.ID),
+		expectState(a, draft.ID, auction.StateClosedSold),
+		expectOutcome(a, draft.ID, auction.OutcomeSold),
+		a.SetItemState(draft.ID, auction.StatePaid),
+		a.SetItemState(draft.ID, auction.StateShipped),
+		expectTransitionError(a.SetItemState(draft.ID, auction.StateVoided)),
+		expectTransitionError(a.OpenItem(draft.ID, auction.OpenOptions{})),
+
+		// Voiding a sale takes it off the total, and voided items stay voided.
+		expectState(a, voided.ID, auction.StateApproved),
+		a.OpenItem(voided.ID, auction.OpenOptions{}),
+		a.Bid(voided.ID, 700, "bob", "Bob"),
+		a.CloseItem(voided.ID),
+		expectTotal(a, 1200),
+		a.SetItemState(voided.ID, auction.StateVoided),
+		expectTotal(a, 500),
+		expectTransitionError(a.OpenItem(voided.ID, auction.OpenOptions{})),
+		expectTransitionError(a.SetItemState(voided.ID, auction.StateApproved)),
+		expectRejected(a.SetItemState(voided.ID, auction.StateOpen)),
+	); err != nil {
+		t.Fatal(err)
+	}
+	r, err := a.ReconcileTotal(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Recorded != 500 || r.Computed != 500 {
+		t.Fatalf("got reconciliation %+v, want 500 recorded and computed", *r)
+	}
+
+	shipped, err := a.GetItemsInState(auction.StateShipped, auction.StateDraft)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(shipped) != 1 || shipped[0].ID != draft.ID {
+		t.Fatalf("got %d items in the shipped or draft states, want only %s", len(shipped), draft.ID)
+	}
+
+	// Every transition is logged.
+	entries, err := a.EventLog("", draft.ID, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, e := range entries {
+		if changed, ok := e.Event.(*auction.ItemStateChangedEvent); ok {
+			got = append(got, changed.From+" "+changed.To)
+		}
+	}
+	want := []string{"draft approved", "approved open", "open approved", "approved open", "open closed-sold", "closed-sold paid", "paid shipped"}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("got state changes %q, want %q", got, want)
+	}
+}
+
+func checkCurrentItem(t *testing.T, a *auction.Auction, s auction.Store) {
+	if _, err := a.GetCurrentItem(); err != auction.ErrNoCurrentItem {
+		t.Fatalf("got %v for an empty auction, want %v", err, auction.ErrNoCurrentItem)
+	}
+	live, err := openItem(a, auction.Item{Title: "Live"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	silent, err := a.CreateItem(auction.Item{Title: "Silent"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := a.OpenItem(silent.ID, auction.OpenOptions{Silent: true}); err != nil {
+		t.Fatal(err)
+	}
+	current, err := a.GetCurrentItem()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if current.ID != live.ID {
+		t.Fatal("a silent item replaced the current item")
+	}
+	open, err := a.OpenItems()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(open) != 2 {
+		t.Fatalf("got %d open items, want 2", len(open))
+	}
+	next, err := openItem(a, auction.Item{Title: "Next"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.IsOpen(live.ID) || !a.IsOpen(silent.ID) || !a.IsOpen(next.ID) {
+		t.Fatal("opening the next item didn't replace only the current item")
+	}
+	if err := a.CloseItem(next.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.GetCurrentItem(); err != auction.ErrNoCurrentItem {
+		t.Fatalf("got %v after closing the current item, want %v", err, auction.ErrNoCurrentItem)
+	}
+}
+
+// expectQueue checks the items in the run queue, in order.
+func expectQueue(a *auction.Auction, want ...string) error {
+	items, err := a.Queue()
+	if err != nil {
+		return err
+	}
+	got := make([]string, 0, len(items))
+	for _, item := range items {
+		if item.State != auction.StateScheduled {
+			return fmt.Errorf("queued item %s is %s, not scheduled", item.ID, item.State)
+		}
+		got = append(got, item.ID)
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		return fmt.Errorf("got queue %v, want %v", got, want)
+	}
+	return nil
+}
+
+func checkQueue(t *testing.T, a *auction.Auction, s auction.Store) {
+	var items []*auction.Item
+	for _, title := range []string{"Plush", "Badge", "Poster"} {
+		item, err := a.CreateItem(auction.Item{Title: title})
+		if err != nil {
+			t.Fatal(err)
+		}
+		items = append(items, item)
+	}
+	plush, badge, poster := items[0].ID, items[1].ID, items[2].ID
+	draft, err := a.CreateItem(auction.Item{Title: "Draft", State: auction.StateDraft})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := first(
+		a.QueueItem(plush, -1),
+		a.QueueItem(badge, -1),
+		a.QueueItem(poster, 0),
+		expectQueue(a, poster, plush, badge),
+		expectTransitionError(a.QueueItem(draft.ID, -1)),
+		expectTransitionError(a.QueueItem(plush, -1)),
+		a.MoveQueuedItem(badge, 0),
+		expectQueue(a, badge, poster, plush),
+		a.SkipQueuedItem(badge),
+		expectQueue(a, poster, plush, badge),
+		a.UnqueueItem(plush),
+		expectState(a, plush, auction.StateApproved),
+		expectQueue(a, poster, badge),
+		expectRejected(a.UnqueueItem(plush)),
+		expectRejected(a.MoveQueuedItem(plush, 0)),
+		expectRejected(a.DeleteItem(poster)),
+		expectRejected(a.SetItemState(poster, auction.StateScheduled)),
+	); err != nil {
+		t.Fatal(err)
+	}
+
+	// The first item is opened without closing anything, since nothing is
+	// up for auction yet.
+	if _, err := s.NextItem(false, time.Time{}, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if err := first(
+		expectState(a, poster, auction.StateOpen),
+		expectQueue(a, badge),
+		expectRejected(errorOf(s.NextItem(false, time.Time{}, time.Now()))),
+		a.Bid(poster, 500, "alice", "Alice"),
+	); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(time.Hour).Truncate(time.Millisecond)
+	next, err := a.NextItem(deadline)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if next.ID != badge {
+		t.Fatalf("got next item %s, want %s", next.ID, badge)
+	}
+	if got, ok := a.Deadline(badge); !ok || !got.Equal(deadline) {
+		t.Fatalf("got deadline %v for the next item, want %v", got, deadline)
+	}
+	if err := first(
+		expectState(a, poster, auction.StateClosedSold),
+		expectTotal(a, 500),
+		expectState(a, badge, auction.StateOpen),
+		expectQueue(a),
+		// With nothing left, the current item stays open.
+		expect(errorOf(a.NextItem(time.Time{})), auction.ErrQueueEmpty),
+		expectState(a, badge, auction.StateOpen),
+
+		// Items leave the queue however they stop being scheduled.
+		a.QueueItem(plush, -1),
+		a.SetItemState(plush, auction.StateApproved),
+		expectQueue(a),
+		a.QueueItem(plush, -1),
+		a.OpenItem(plush, auction.OpenOptions{}),
+		expectQueue(a),
+	); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := a.EventLog("", plush, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	changes := 0
+	for _, e := range entries {
+		if _, ok := e.Event.(*auction.QueueChangedEvent); ok {
+			changes++
+		}
+	}
+	if changes != 6 {
+		t.Fatalf("got %d queue changes for %s, want 6", changes, plush)
+	}
+}
+
+// expectOpenings checks the items that are scheduled to open, soonest first.
+func expectOpenings(a *auction.Auction, want ...string) error {
+	openings, err := a.Openings()
+	if err != nil {
+		return err
+	}
+	got := make([]string, 0, len(openings))
+	for _, opening := range openings {
+		got = append(got, opening.ItemID)
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		return fmt.Errorf("got openings %v, want %v", got, want)
+	}
+	return nil
+}
+
+// expectDone returns a check of whether a store operation that only happens
+// once did anything, for its results.
+func expectDone(want bool) func(done bool, err error) error {
+	return func(done bool, err error) error {
+		if err != nil {
+			return err
+		}
+		if done != want {
+			return fmt.Errorf("got %v, want %v", done, want)
+		}
+		return nil
+	}
+}
+
+func checkOpenings(t *testing.T, a *auction.Auction, s auction.Store) {
+	panel, err := a.CreateItem(auction.Item{Title: "Panel"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	raffle, err := a.CreateItem(auction.Item{Title: "Raffle"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	draft, err := a.CreateItem(auction.Item{Title: "Draft", State: auction.StateDraft})
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now().Truncate(time.Millisecond)
+	if err := first(
+		a.ScheduleOpening(panel.ID, now.Add(time.Hour)),
+		a.ScheduleOpening(raffle.ID, now.Add(30*time.Minute)),
+		expectState(a, panel.ID, auction.StateScheduled),
+		expectOpenings(a, raffle.ID, panel.ID),
+		expectTransitionError(a.ScheduleOpening(draft.ID, now)),
+		expectRejected(a.DeleteItem(panel.ID)),
+		// Scheduling an item again moves its opening.
+		a.ScheduleOpening(panel.ID, now.Add(10*time.Minute)),
+		expectOpenings(a, panel.ID, raffle.ID),
+
+		// Reminders are sent once, and only when they are due.
+		expectDone(false)(s.PreviewOpening(panel.ID, now)),
+		expectDone(true)(s.PreviewOpening(panel.ID, now.Add(10*time.Minute))),
+		expectDone(false)(s.PreviewOpening(panel.ID, now.Add(10*time.Minute))),
+	); err != nil {
+		t.Fatal(err)
+	}
+	openings, err := a.Openings()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !openings[0].Previewed || openings[1].Previewed || !openings[0].At.Equal(now.Add(10*time.Minute)) {
+		t.Fatalf("got openings %+v after a reminder for %s", openings, panel.ID)
+	}
+
+	// Items are opened once, and only when their time has come.
+	if err := first(
+		expectDone(false)(s.OpenScheduledItem(raffle.ID, now)),
+		expectDone(true)(s.OpenScheduledItem(panel.ID, now.Add(10*time.Minute))),
+		expectDone(false)(s.OpenScheduledItem(panel.ID, now.Add(10*time.Minute))),
+		expectState(a, panel.ID, auction.StateOpen),
+		expectOpenings(a, raffle.ID),
+		a.CloseItem(panel.ID),
+
+		// Items scheduled to open can be queued as well, and stay scheduled
+		// until both are cancelled.
+		a.QueueItem(raffle.ID, -1),
+		expectQueue(a, raffle.ID),
+		a.UnqueueItem(raffle.ID),
+		expectState(a, raffle.ID, auction.StateScheduled),
+		a.QueueItem(raffle.ID, -1),
+		a.CancelOpening(raffle.ID),
+		expectState(a, raffle.ID, auction.StateScheduled),
+		expectRejected(a.CancelOpening(raffle.ID)),
+		a.UnqueueItem(raffle.ID),
+		expectState(a, raffle.ID, auction.StateApproved),
+
+		// Openings are cancelled however items stop being scheduled.
+		a.ScheduleOpening(raffle.ID, now.Add(time.Hour)),
+		a.SetItemState(raffle.ID, auction.StateApproved),
+		expectOpenings(a),
+	); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := a.EventLog("", panel.ID, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reminders := 0
+	for _, e := range entries {
+		if scheduled, ok := e.Event.(*auction.ItemScheduledEvent); ok && scheduled.Reminder {
+			reminders++
+			if scheduled.OpensAt != now.Add(10*time.Minute).UnixNano()/int64(time.Millisecond) {
+				t.Fatalf("got a reminder for an opening at %d", scheduled.OpensAt)
+			}
+		}
+	}
+	if reminders != 1 {
+		t.Fatalf("got %d reminders for %s, want 1", reminders, panel.ID)
+	}
+}
+
+func expectArchived(a *auction.Auction, want bool) error {
+	archived, err := a.Archived()
+	if err != nil {
+		return err
+	}
+	if archived != want {
+		return fmt.Errorf("got archived %v, want %v", archived, want)
+	}
+	return nil
+}
+
+func checkArchiving(t *testing.T, a *auction.Auction, s auction.Store) {
+	plush, err := openItem(a, auction.Item{Title: "Plush", StartBid: 500})
+	if err != nil {
+		t.Fatal(err)
+	}
+	poster, err := a.CreateItem(auction.Item{Title: "Poster"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := first(
+		// Everything has to be finished with first.
+		expectRejected(a.Archive()),
+		a.CloseItem(plush.ID),
+		a.QueueItem(poster.ID, -1),
+		expectRejected(a.Archive()),
+		a.UnqueueItem(poster.ID),
+		a.ScheduleOpening(poster.ID, time.Now().Add(time.Hour)),
+		expectRejected(a.Archive()),
+		a.CancelOpening(poster.ID),
+		expectArchived(a, false),
+
+		a.Archive(),
+		expectArchived(a, true),
+		// Archiving again is harmless.
+		a.Archive(),
+		expect(errorOf(a.CreateItem(auction.Item{Title: "Late"})), auction.ErrAuctionArchived),
+		expect(a.OpenItem(poster.ID, auction.OpenOptions{}), auction.ErrAuctionArchived),
+		expect(a.QueueItem(poster.ID, -1), auction.ErrAuctionArchived),
+		expect(a.DeleteItem(poster.ID), auction.ErrAuctionArchived),
+		expect(a.SetItemState(plush.ID, auction.StatePaid), auction.ErrAuctionArchived),
+		expect(a.SetBidRules(auction.DefaultBidRules), auction.ErrAuctionArchived),
+
+		// The store refuses the changes itself, not just Auction.
+		expect(s.CreateItem(auction.Item{ID: "late", Title: "Late"}), auction.ErrAuctionArchived),
+		expect(errorOf(s.UpdateItem(poster.ID, func(old auction.Item) (auction.Item, bool, error) {
+			old.Title = "Renamed"
+			return old, false, nil
+		})), auction.ErrAuctionArchived),
+		expect(s.OpenItem(poster.ID, auction.OpenOptions{}, time.Now()), auction.ErrAuctionArchived),
+		expect(errorOf(s.CloseItem(plush.ID, time.Time{})), auction.ErrAuctionArchived),
+		expect(s.SetItemState(plush.ID, auction.StatePaid), auction.ErrAuctionArchived),
+		expect(s.QueueItem(poster.ID, -1), auction.ErrAuctionArchived),
+		expect(s.ScheduleOpening(poster.ID, time.Now().Add(time.Hour)), auction.ErrAuctionArchived),
+		expect(errorOf(s.NextItem(false, time.Time{}, time.Now())), auction.ErrAuctionArchived),
+		expect(s.SetDeadline(poster.ID, time.Now().Add(time.Hour)), auction.ErrAuctionArchived),
+		expect(s.SetBidRules(auction.DefaultBidRules), auction.ErrAuctionArchived),
+		expect(reconcileError(s, true), auction.ErrAuctionArchived),
+		// Reconciling without fixing anything is fine.
+		reconcileError(s, false),
+	); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := a.EventLog("", "", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	archived := 0
+	for _, e := range entries {
+		if _, ok := e.Event.(*auction.AuctionArchivedEvent); ok {
+			archived++
+		}
+	}
+	if archived != 1 {
+		t.Fatalf("got %d archived events, want 1", archived)
+	}
+}
+
+func checkSealedBids(t *testing.T, a *auction.Auction, s auction.Store) {
+	item, err := openItem(a, auction.Item{Title: "Sealed", StartBid: 500, Mode: auction.ModeSealed, Pricing: auction.PricingSecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := first(
+		expectTooLow(a.Bid(item.ID, 400, "alice", "Alice"), 500),
+		expectRejected(a.SetMaxBid(item.ID, 1000, "alice", "Alice")),
+		a.Bid(item.ID, 900, "alice", "Alice"),
+		a.Bid(item.ID, 1500, "bob", "Bob"),
+		a.Bid(item.ID, 1200, "alice", "Alice"),
+		expectBids(a, item.ID),
+	); err != nil {
+		t.Fatal(err)
+	}
+	sealed, err := a.GetSealedBids(item.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sealed) != 2 || sealed[0].BidCents != 1200 || sealed[1].BidCents != 1500 {
+		t.Fatalf("got sealed bids %+v, want 1200 then 1500", sealed)
+	}
+	if err := first(
+		a.CloseItem(item.ID),
+		expectBids(a, item.ID, "alice:1200", "bob:1200"),
+		expectTotal(a, 1200),
+	); err != nil {
+		t.Fatal(err)
+	}
+	bids, err := a.GetTopBids(item.ID, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bids[0].SealedBidCents != 1500 {
+		t.Fatalf("got sealed bid %d on the winning bid, want 1500", bids[0].SealedBidCents)
+	}
+	if sealed, _ := a.GetSealedBids(item.ID); len(sealed) != 0 {
+		t.Fatal("sealed bids weren't cleared when they were revealed")
+	}
+}
+
+func checkDutch(t *testing.T, a *auction.Auction, s auction.Store) {
+	item, err := a.CreateItem(auction.Item{Title: "Dutch", Mode: auction.ModeDutch, Dutch: &auction.DutchSchedule{StartPrice: 5000, Step: 1000, Interval: 60, Floor: 2500}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	opened := time.Now()
+	if err := a.OpenItem(item.ID, auction.OpenOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	price, nextDrop, err := a.CurrentPrice(item.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if price != 5000 || nextDrop.Before(opened.Add(59*time.Second)) {
+		t.Fatalf("got price %d dropping at %v, want 5000 in a minute", price, nextDrop)
+	}
+	// Dropping late catches up on the drops that were missed, but not past the
+	// floor.
+	if err := s.DropPrice(item.ID, nextDrop.Add(90*time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	if price, _, _ = a.CurrentPrice(item.ID); price != 3000 {
+		t.Fatalf("got price %d after two drops, want 3000", price)
+	}
+	// Opening it again doesn't put the price back up.
+	if err := a.OpenItem(item.ID, auction.OpenOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if price, _, _ = a.CurrentPrice(item.ID); price != 3000 {
+		t.Fatalf("got price %d after opening the item again, want 3000", price)
+	}
+	if err := s.DropPrice(item.ID, nextDrop.Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if price, nextDrop, _ = a.CurrentPrice(item.ID); price != 2500 || !nextDrop.IsZero() {
+		t.Fatalf("got price %d dropping at %v, want 2500 and no more drops", price, nextDrop)
+	}
+	if err := first(
+		expectRejected(a.SetMaxBid(item.ID, 5000, "alice", "Alice")),
+		expectTooLow(a.Bid(item.ID, 2000, "alice", "Alice"), 2500),
+		a.Take(item.ID, "bob", "Bob"),
+		expectBids(a, item.ID, "bob:2500"),
+		expectOutcome(a, item.ID, auction.OutcomeSold),
+		expectTotal(a, 2500),
+		// Once it has been claimed, reopening it doesn't start the price
+		// drops again.
+		a.OpenItem(item.ID, auction.OpenOptions{}),
+		func() error {
+			if price, _, err := a.CurrentPrice(item.ID); err == nil {
+				return fmt.Errorf("got price %d after reopening a claimed item, want none", price)
+			}
+			return nil
+		}(),
+	); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func checkBuyNow(t *testing.T, a *auction.Auction, s auction.Store) {
+	item, err := openItem(a, auction.Item{Title: "Plush", StartBid: 500, BuyNow: 2000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := a.CreateItem(auction.Item{Title: "Other", StartBid: 500, BuyNow: 2000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := first(
+		a.BuyNow(item.ID, "alice", "Alice"),
+		expectOutcome(a, item.ID, auction.OutcomeSold),
+		expectTotal(a, 2000),
+		expectBids(a, item.ID, "alice:2000"),
+		a.OpenItem(other.ID, auction.OpenOptions{}),
+		a.Bid(other.ID, 1600, "bob", "Bob"),
+		expectRejected(a.BuyNow(other.ID, "alice", "Alice")),
+		expect(a.BuyNow(item.ID, "bob", "Bob"), auction.ErrItemClosed),
+	); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func checkDeadlines(t *testing.T, a *auction.Auction, s auction.Store) {
+	item, err := a.CreateItem(auction.Item{Title: "Plush"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(time.Hour).Truncate(time.Millisecond)
+	if err := a.OpenItem(item.ID, auction.OpenOptions{Deadline: deadline}); err != nil {
+		t.Fatal(err)
+	}
+	if got, ok := a.Deadline(item.ID); !ok || !got.Equal(deadline) {
+		t.Fatalf("got deadline %v, want %v", got, deadline)
+	}
+	extended, err := a.ExtendDeadline(item.ID, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !extended.Equal(deadline.Add(time.Minute)) {
+		t.Fatalf("got extended deadline %v, want %v", extended, deadline.Add(time.Minute))
+	}
+	if expired, _ := s.ExpiredItems(time.Now()); len(expired) != 0 {
+		t.Fatalf("got expired items %v before the deadline", expired)
+	}
+	if outcome, err := s.CloseItem(item.ID, time.Now()); outcome != "" || err != nil {
+		t.Fatalf("closing before the deadline gave outcome %q and error %v", outcome, err)
+	}
+	later := extended.Add(time.Second)
+	if expired, _ := s.ExpiredItems(later); len(expired) != 1 || expired[0] != item.ID {
+		t.Fatalf("got expired items %v after the deadline", expired)
+	}
+	if outcome, err := s.CloseItem(item.ID, later); outcome != auction.OutcomeUnsold || err != nil {
+		t.Fatalf("closing after the deadline gave outcome %q and error %v", outcome, err)
+	}
+	if _, ok := a.Deadline(item.ID); ok {
+		t.Fatal("the deadline outlived the item closing")
+	}
+	if err := a.OpenItem(item.ID, auction.OpenOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := first(
+		expectRejected(func() error { _, err := a.ExtendDeadline(item.ID, time.Minute); return err }()),
+		a.SetDeadline(item.ID, time.Now().Add(-time.Second)),
+		expect(a.Bid(item.ID, 100, "alice", "Alice"), auction.ErrItemClosed),
+		a.CancelDeadline(item.ID),
+		a.Bid(item.ID, 100, "alice", "Alice"),
+	); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func checkSoftClose(t *testing.T, a *auction.Auction, s auction.Store) {
+	item, err := a.CreateItem(auction.Item{Title: "Plush", SoftClose: &auction.SoftClose{Window: 120, Extension: 60, MaxExtension: 90}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(30 * time.Second).Truncate(time.Millisecond)
+	if err := a.OpenItem(item.ID, auction.OpenOptions{Deadline: deadline}); err != nil {
+		t.Fatal(err)
+	}
+	for i, want := range []time.Duration{60 * time.Second, 90 * time.Second, 90 * time.Second} {
+		if err := a.Bid(item.ID, (i+1)*100, "alice", "Alice"); err != nil {
+			t.Fatal(err)
+		}
+		if got, _ := a.Deadline(item.ID); !got.Equal(deadline.Add(want)) {
+			t.Fatalf("after bid %d, got deadline %v, want %v", i+1, got, deadline.Add(want))
+		}
+	}
+}
+
+func checkPause(t *testing.T, a *auction.Auction, s auction.Store) {
+	item, err := a.CreateItem(auction.Item{Title: "Plush", BuyNow: 2000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(time.Hour).Truncate(time.Millisecond)
+	if err := a.OpenItem(item.ID, auction.OpenOptions{Deadline: deadline}); err != nil {
+		t.Fatal(err)
+	}
+	// The clock stops with ten minutes to go, and starts again five minutes
+	// later.
+	paused := deadline.Add(-10 * time.Minute)
+	resumed := paused.Add(5 * time.Minute)
+	if err := first(
+		a.Bid(item.ID, 100, "alice", "Alice"),
+		s.PauseItem(item.ID, paused),
+		expectState(a, item.ID, auction.StatePaused),
+		expect(a.Bid(item.ID, 200, "bob", "Bob"), auction.ErrItemPaused),
+		expect(a.BuyNow(item.ID, "bob", "Bob"), auction.ErrItemPaused),
+		expectRejected(a.SetDeadline(item.ID, deadline)),
+		expectTransitionError(a.PauseItem(item.ID)),
+		expectBids(a, item.ID, "alice:100"),
+	); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := a.Deadline(item.ID); ok {
+		t.Fatal("a paused item still has a deadline")
+	}
+	if expired, _ := s.ExpiredItems(deadline.Add(time.Hour)); len(expired) != 0 {
+		t.Fatalf("got expired items %v while paused", expired)
+	}
+	if err := first(
+		s.ResumeItem(item.ID, resumed),
+		expectState(a, item.ID, auction.StateOpen),
+		expectRejected(a.ResumeItem(item.ID)),
+	); err != nil {
+		t.Fatal(err)
+	}
+	if got, ok := a.Deadline(item.ID); !ok || !got.Equal(deadline.Add(5*time.Minute)) {
+		t.Fatalf("got deadline %v after resuming, want %v", got, deadline.Add(5*time.Minute))
+	}
+	// Paused items can still be closed, and count like any other.
+	if err := first(
+		a.Bid(item.ID, 200, "bob", "Bob"),
+		a.PauseItem(item.ID),
+		a.CloseItem(item.ID),
+		expectState(a, item.ID, auction.StateClosedSold),
+		expectTotal(a, 200),
+		expect(a.ResumeItem(item.ID), auction.ErrItemClosed),
+	); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func checkDeleteBid(t *testing.T, a *auction.Auction, s auction.Store) {
+	item, err := openItem(a, auction.Item{Title: "Plush"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := first(
+		a.Bid(item.ID, 100, "alice", "Alice"),
+		a.Bid(item.ID, 200, "bob", "Bob"),
+	); err != nil {
+		t.Fatal(err)
+	}
+	bids, err := a.GetTopBids(item.ID, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := first(
+		a.DeleteBid(item.ID, bids[0].ID),
+		expectBids(a, item.ID, "alice:100"),
+		expect(a.DeleteBid(item.ID, bids[0].ID), auction.ErrBidNotFound),
+		a.Bid(item.ID, 200, "carol", "Carol"),
+		a.CloseItem(item.ID),
+		expectTotal(a, 200),
+		func() error {
+			bids, err := a.GetTopBids(item.ID, 1)
+			if err != nil {
+				return err
+			}
+			return expectRejected(a.DeleteBid(item.ID, bids[0].ID))
+		}(),
+		expectBids(a, item.ID, "alice:100", "carol:200"),
+		expectTotal(a, 200),
+	); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func checkEvents(t *testing.T, a *auction.Auction, s auction.Store) {
+	events := a.Events()
+	item, err := openItem(a, auction.Item{Title: "Plush", Reserve: 1000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := first(
+		a.Bid(item.ID, 500, "alice", "Alice"),
+		a.Bid(item.ID, 1000, "bob", "Bob"),
+		a.CloseItem(item.ID),
+	); err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for len(got) < 6 {
+		select {
+		case e := <-events:
+			switch e := e.(type) {
+			case *auction.OpenItemEvent:
+				got = append(got, "open "+e.ItemID)
+			case *auction.BidEvent:
+				got = append(got, fmt.Sprintf("bid %s %d reserveMet=%v", e.Bidder, e.BidCents, e.ReserveMet != nil && *e.ReserveMet))
+			case *auction.CloseItemEvent:
+				got = append(got, fmt.Sprintf("close %s %s", e.ItemID, e.Outcome))
+			case *auction.ItemStateChangedEvent:
+				got = append(got, fmt.Sprintf("state %s %s", e.From, e.To))
+			default:
+				got = append(got, e.Event())
+			}
+		case <-time.After(eventTimeout):
+			t.Fatalf("got events %q, then nothing", got)
+		}
+	}
+	want := []string{
+		"open " + item.ID,
+		"state approved open",
+		"bid alice 500 reserveMet=false",
+		"bid bob 1000 reserveMet=true",
+		"close " + item.ID + " " + auction.OutcomeSold,
+		"state open closed-sold",
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("got events %q, want %q", got, want)
+	}
+
+	// Every subscriber gets every event, and unsubscribing closes the channel.
+	entries, unsubscribe, err := s.Subscribe("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, unsubscribeOther, err := s.Subscribe("")
+	if err != nil {
+		unsubscribe()
+		t.Fatal(err)
+	}
+	defer unsubscribeOther()
+	if err := a.OpenItem(item.ID, auction.OpenOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	// Reopening the item logs the opening and the change of state.
+	for _, ch := range []<-chan auction.LogEntry{entries, other} {
+		for i := 0; i < 2; i++ {
+			select {
+			case <-ch:
+			case <-time.After(eventTimeout):
+				t.Fatal("a subscriber missed an event")
+			}
+		}
+	}
+	unsubscribe()
+	select {
+	case _, ok := <-entries:
+		if ok {
+			t.Fatal("got an event after unsubscribing")
+		}
+	case <-time.After(eventTimeout):
+		t.Fatal("unsubscribing didn't close the channel")
+	}
+}
+
+func checkEventLog(t *testing.T, a *auction.Auction, s auction.Store) {
+	plush, err := openItem(a, auction.Item{Title: "Plush"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	poster, err := a.CreateItem(auction.Item{Title: "Print"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := first(
+		a.Bid(plush.ID, 500, "alice", "Alice"),
+		a.OpenItem(poster.ID, auction.OpenOptions{Silent: true}),
+		a.Bid(poster.ID, 700, "bob", "Bob"),
+		a.CloseItem(plush.ID),
+	); err != nil {
+		t.Fatal(err)
+	}
+	describe := func(events []auction.LoggedEvent) []string {
+		var got []string
+		for _, e := range events {
+			got = append(got, e.Event.Event()+" "+e.ItemID)
+		}
+		return got
+	}
+	all, err := a.EventLog("", "", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"openItem " + plush.ID, "stateChanged " + plush.ID, "bid " + plush.ID,
+		"openItem " + poster.ID, "stateChanged " + poster.ID, "bid " + poster.ID,
+		"closeItem " + plush.ID, "stateChanged " + plush.ID,
+	}
+	if fmt.Sprint(describe(all)) != fmt.Sprint(want) {
+		t.Fatalf("got event log %q, want %q", describe(all), want)
+	}
+	seen := map[string]bool{}
+	for _, e := range all {
+		if e.ID == "" || seen[e.ID] {
+			t.Fatalf("event ID %q is empty or repeated", e.ID)
+		}
+		seen[e.ID] = true
+	}
+
+	// The log can be read from any ID, and for a single item.
+	page, err := a.EventLog(all[1].ID, "", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(describe(page)) != fmt.Sprint(want[2:4]) {
+		t.Fatalf("got %q after the second event, want %q", describe(page), want[2:4])
+	}
+	plushEvents, err := a.EventLog("", plush.ID, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if wantPlush := []string{want[0], want[1], want[2], want[6], want[7]}; fmt.Sprint(describe(plushEvents)) != fmt.Sprint(wantPlush) {
+		t.Fatalf("got %q for one item, want %q", describe(plushEvents), wantPlush)
+	}
+	if err := first(
+		expect(errorOf(a.EventLog("nonsense", "", 0)), auction.ErrBadEventID),
+		expect(errorOf(a.EventsAfter(context.Background(), "nonsense")), auction.ErrBadEventID),
+	); err != nil {
+		t.Fatal(err)
+	}
+
+	// Subscribing after an ID replays what came after it, then carries on
+	// with new events, each exactly once.
+	events, err := a.EventsAfter(context.Background(), all[2].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Bid(poster.ID, 900, "carol", "Carol"); err != nil {
+		t.Fatal(err)
+	}
+	var got []auction.LoggedEvent
+	for len(got) < 6 {
+		select {
+		case e := <-events:
+			got = append(got, e)
+		case <-time.After(eventTimeout):
+			t.Fatalf("got events %q, then nothing", describe(got))
+		}
+	}
+	wantResumed := []string{want[3], want[4], want[5], want[6], want[7], "bid " + poster.ID}
+	if fmt.Sprint(describe(got)) != fmt.Sprint(wantResumed) {
+		t.Fatalf("got events %q after resuming, want %q", describe(got), wantResumed)
+	}
+	select {
+	case e := <-events:
+		t.Fatalf("got unexpected event %q", describe([]auction.LoggedEvent{e}))
+	case <-time.After(eventTimeout / 10):
+	}
+}
+
+func checkEventSubscribers(t *testing.T, a *auction.Auction, s auction.Store) {
+	item, err := openItem(a, auction.Item{Title: "Plush"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	fast, err := a.EventsAfter(ctx, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	slow, err := a.EventsAfter(ctx, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats := a.EventStats(); stats.Subscribers != 2 {
+		t.Fatalf("got %d subscribers, want 2", stats.Subscribers)
+	}
+
+	// A subscriber that doesn't keep up is dropped, without holding up
+	// anyone else.
+	for i := 0; i < auction.DefaultEventBuffer+10; i++ {
+		if err := a.Bid(item.ID, 100*(i+1), fmt.Sprintf("bidder%d", i%2), "Bidder"); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case <-fast:
+		case <-time.After(eventTimeout):
+			t.Fatal("a subscriber that kept up missed an event")
+		}
+	}
+	n := 0
+	for range slow {
+		n++
+	}
+	if n > auction.DefaultEventBuffer {
+		t.Fatalf("a subscriber that fell behind got %d events before being dropped", n)
+	}
+	if stats := a.EventStats(); stats.Subscribers != 1 || stats.Dropped != 1 {
+		t.Fatalf("got stats %+v, want 1 subscriber and 1 dropped", stats)
+	}
+
+	// Cancelling the context unsubscribes.
+	cancel()
+	select {
+	case _, ok := <-fast:
+		if ok {
+			t.Fatal("got an event after cancelling")
+		}
+	case <-time.After(eventTimeout):
+		t.Fatal("cancelling the context didn't close the channel")
+	}
+	if stats := a.EventStats(); stats.Subscribers != 0 {
+		t.Fatalf("got %d subscribers after cancelling, want 0", stats.Subscribers)
+	}
+}
+
+// errorOf returns the error from a call that returns a value and an error.
+func errorOf(_ interface{}, err error) error {
+	return err
+}
+
+func reconcileError(s auction.Store, fix bool) error {
+	_, _, err := s.ReconcileTotal(fix)
+	return err
+}
+
+func checkConcurrentBids(t *testing.T, a *auction.Auction, s auction.Store) {
+	item, err := openItem(a, auction.Item{Title: "Plush"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	const bidders = 20
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	accepted := 0
+	for i := 0; i < bidders; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if a.Bid(item.ID, 1000, fmt.Sprintf("bidder%d", i), "Bidder") == nil {
+				mu.Lock()
+				accepted++
+				mu.Unlock()
+			}
+		}(i)
+	}
+	wg.Wait()
+	if accepted != 1 {
+		t.Fatalf("%d of %d equal bids were accepted, want exactly 1", accepted, bidders)
+	}
+	bids, err := a.GetTopBids(item.ID, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(bids) != 1 {
+		t.Fatalf("got %d bids, want 1", len(bids))
+	}
+}
@@ -1,20 +1,37 @@
 package bot
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log"
 	"math"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/PonyFest/auction-bot/auction"
 	"github.com/bwmarrin/discordgo"
 )
 
+// resubscribeDelay is how long the bot waits before trying again when it can't
+// subscribe to auction events.
+const resubscribeDelay = 5 * time.Second
+
 type AuctionBot struct {
 	discord *discordgo.Session
-	discordChannel string
-	discordGuild string
+	// mu guards auctions and their channels, which Bind can change while the
+	// bot is running.
+	mu sync.RWMutex
+	auctions []*boundAuction
+}
+
+// boundAuction is an auction run in one or more discord channels, which may be
+// in different guilds. Each channel is bound to only one auction.
+type boundAuction struct {
+	*AuctionBot
+	channels []*Channel
 	auction *auction.Auction
 }
 
@@ -24,12 +41,12 @@ func New(auc *auction.Auction, discordToken, discordChannel string) (*AuctionBot
 		return nil, fmt.Errorf("couldn't create discord session: %v", err)
 	}
 	b := &AuctionBot{
-		discord:        d,
-		discordChannel: discordChannel,
-		auction:        auc,
+		discord: d,
 	}
 	d.AddHandler(b.handleMessage)
-	go b.handleAuctionUpdates()
+	if err := b.Bind(auc, Channel{ID: discordChannel}); err != nil {
+		return nil, err
+	}
 	return b, nil
 }
 
@@ -41,8 +58,34 @@ func (b *AuctionBot) RunForever() error {
 	select{}
 }
 
-func (b *AuctionBot) handleAuctionUpdates() {
-	ch := b.auction.Events()
+// events returns a channel of auction events that lasts as long as the bot
+// does. If the bot's subscription ends, it subscribes again from the last event
+// it saw, so that nothing goes unannounced.
+func (b *boundAuction) events() <-chan auction.Event {
+	out := make(chan auction.Event)
+	go func() {
+		lastEventID := ""
+		for {
+			ch, err := b.auction.EventsAfter(context.Background(), lastEventID)
+			if err != nil {
+				log.Printf("Subscribing to auction events failed: %v.\n", err)
+				time.Sleep(resubscribeDelay)
+				continue
+			}
+			for event := range ch {
+				if event.ID != "" {
+					lastEventID = event.ID
+				}
+				out <- event.Event
+			}
+			log.Printf("Auction events stopped. Resubscribing.\n")
+		}
+	}()
+	return out
+}
+
+func (b *boundAuction) handleAuctionUpdates() {
+	ch := b.events()
 	for {
 		event, ok := <-ch
 		log.Println(event, ok)
@@ -53,23 +96,35 @@ func (b *AuctionBot) handleAuctionUpdates() {
 		case *auction.CloseItemEvent:
 			item, _ := b.auction.GetItem(e.ItemID)
 			if item == nil {
-				_, _ = b.discord.ChannelMessageSend(b.discordChannel, "Bidding on this item has closed.")
+				b.announce(VerbosityItems, "Bidding on this item has closed.")
 				break
 			}
 			bids, err := b.auction.GetTopBids(e.ItemID, 1)
 			if err != nil {
-				_, _ = b.discord.ChannelMessageSend(b.discordChannel, fmt.Sprintf("Bidding for **%s** has closed.", item.Title))
+				b.announce(VerbosityItems, fmt.Sprintf("Bidding for **%s** has closed.", item.Title))
 				break
 			}
 			if len(bids) == 0 {
-				_, _ = b.discord.ChannelMessageSend(b.discordChannel, fmt.Sprintf("Bidding for **%s** has closed. There were no bids.", item.Title))
+				b.announce(VerbosityItems, fmt.Sprintf("Bidding for **%s** has closed. There were no bids.", item.Title))
+				break
+			}
+			if e.BuyNow {
+				b.announce(VerbosityItems, fmt.Sprintf("<@%s> has bought **%s** outright for $%d.%02d!", bids[0].Bidder, item.Title, bids[0].BidCents/100, bids[0].BidCents%100))
 				break
 			}
-			_, _ = b.discord.ChannelMessageSend(b.discordChannel, fmt.Sprintf("Bidding for **%s** has closed. The winner was <@%s>, at $%d.%02d!", item.Title, bids[0].Bidder, bids[0].BidCents / 100, bids[0].BidCents % 100))
+			if e.Outcome == auction.OutcomeReserveNotMet {
+				b.announce(VerbosityItems, fmt.Sprintf("Bidding for **%s** has closed. Unfortunately, the reserve price wasn't met, so the item won't be sold.", item.Title))
+				break
+			}
+			if item.Mode == auction.ModeSealed && item.Pricing == auction.PricingSecond {
+				b.announce(VerbosityItems, fmt.Sprintf("Sealed bidding for **%s** has closed. The winner was <@%s>, who bid $%d.%02d and pays the second-highest price of $%d.%02d!", item.Title, bids[0].Bidder, bids[0].SealedBidCents/100, bids[0].SealedBidCents%100, bids[0].BidCents/100, bids[0].BidCents%100))
+				break
+			}
+			b.announce(VerbosityItems, fmt.Sprintf("Bidding for **%s** has closed. The winner was <@%s>, at $%d.%02d!", item.Title, bids[0].Bidder, bids[0].BidCents / 100, bids[0].BidCents % 100))
 		case *auction.OpenItemEvent:
 			item, _ := b.auction.GetItem(e.ItemID)
 			if item == nil {
-				_, _ = b.discord.ChannelMessageSend(b.discordChannel, "Bidding for the next item has started!")
+				b.announce(VerbosityItems, "Bidding for the next item has started!")
 				break
 			}
 			pictureURL := ""
@@ -78,35 +133,187 @@ func (b *AuctionBot) handleAuctionUpdates() {
 			}
 			bids, _ := b.auction.GetTopBids(e.ItemID, 1)
 			message := ""
-			if len(bids) == 1 {
+			if item.Mode == auction.ModeDutch && item.Dutch != nil {
+				command := "!take"
+				if e.Silent {
+					command = fmt.Sprintf("!take %s", itemRef(item))
+				}
+				d := item.Dutch
+				message = fmt.Sprintf("A dutch auction for **%s** has started at **$%d.%02d**! The price drops by $%d.%02d every %s until someone claims it by saying `%s`.\n\n%s%s", item.Title, d.StartPrice/100, d.StartPrice%100, d.Step/100, d.Step%100, time.Duration(d.Interval)*time.Second, command, item.Description, pictureURL)
+			} else if item.Mode == auction.ModeSealed {
+				command := "!bid price"
+				if e.Silent {
+					command = fmt.Sprintf("!bid %s price", itemRef(item))
+				}
+				pricing := "The highest bid wins."
+				if item.Pricing == auction.PricingSecond {
+					pricing = "The highest bid wins, but only pays the second-highest price."
+				}
+				message = fmt.Sprintf("Sealed bidding for **%s** has started! Bids are secret until bidding closes: say `%s`, here or in a DM to me. %s Bids start at **$%d.%02d**.\n\n%s%s", item.Title, command, pricing, item.StartBid/100, item.StartBid%100, item.Description, pictureURL)
+			} else if e.Silent {
+				message = fmt.Sprintf("**%s** is now open for silent bidding! To bid, say `!bid %s price`. Bidding starts at **$%d.%02d**.\n\n%s%s", item.Title, itemRef(item), item.StartBid/100, item.StartBid%100, item.Description, pictureURL)
+			} else if len(bids) == 1 {
 				message = fmt.Sprintf("Bidding for **%s** has reopened! The current high bid is **$%d.%02d**.\n\n%s%s", item.Title, bids[0].BidCents / 100, bids[0].BidCents % 100, item.Description, pictureURL)
 			} else {
 				message = fmt.Sprintf("Bidding for **%s** has started! Bidding starts at **$%d.%02d**.\n\n%s%s", item.Title, item.StartBid/100, item.StartBid%100, item.Description, pictureURL)
 			}
-			_, _ = b.discord.ChannelMessageSend(b.discordChannel, message)
+			if item.Reserve > 0 {
+				message += "\n\nThis item has a reserve price."
+			}
+			if item.BuyNow > 0 {
+				command := "!buynow"
+				if e.Silent {
+					command = fmt.Sprintf("!buynow %s", itemRef(item))
+				}
+				message += fmt.Sprintf("\n\nYou can buy it now for **$%d.%02d** by saying `%s`, until bidding gets close to that.", item.BuyNow/100, item.BuyNow%100, command)
+			}
+			if e.Deadline != 0 {
+				message += fmt.Sprintf("\n\nBidding closes %s.", discordTime(e.Deadline))
+			}
+			b.announce(VerbosityItems, message)
+		case *auction.DeadlineChangedEvent:
+			item, _ := b.auction.GetItem(e.ItemID)
+			if item == nil {
+				break
+			}
+			if e.Deadline == 0 {
+				b.announce(VerbosityItems, fmt.Sprintf("Bidding for **%s** will no longer close automatically.", item.Title))
+				break
+			}
+			b.announce(VerbosityItems, fmt.Sprintf("Bidding for **%s** now closes %s.", item.Title, discordTime(e.Deadline)))
+		case *auction.DeadlineExtendedEvent:
+			item, _ := b.auction.GetItem(e.ItemID)
+			if item == nil {
+				break
+			}
+			b.announce(VerbosityAll, fmt.Sprintf("A last-minute bid has extended bidding for **%s**! Bidding now closes %s.", item.Title, discordTime(e.Deadline)))
+		case *auction.PauseItemEvent:
+			item, _ := b.auction.GetItem(e.ItemID)
+			if item == nil {
+				break
+			}
+			message := fmt.Sprintf("Bidding for **%s** is paused for now. Hold on to your bids until it resumes!", item.Title)
+			if e.Remaining > 0 {
+				remaining := (time.Duration(e.Remaining) * time.Millisecond).Round(time.Second)
+				message += fmt.Sprintf(" The clock has stopped with %s to go.", remaining)
+			}
+			b.announce(VerbosityItems, message)
+		case *auction.ResumeItemEvent:
+			item, _ := b.auction.GetItem(e.ItemID)
+			if item == nil {
+				break
+			}
+			message := fmt.Sprintf("Bidding for **%s** has resumed!", item.Title)
+			if e.Deadline != 0 {
+				message += fmt.Sprintf(" Bidding now closes %s.", discordTime(e.Deadline))
+			}
+			b.announce(VerbosityItems, message)
+		case *auction.ItemScheduledEvent:
+			// Only the reminders shortly before an item opens are worth telling
+			// the channel about.
+			if !e.Reminder {
+				break
+			}
+			item, _ := b.auction.GetItem(e.ItemID)
+			if item == nil {
+				break
+			}
+			pictureURL := ""
+			if len(item.Images) > 0 {
+				pictureURL = "\n"+item.Images[0]
+			}
+			message := fmt.Sprintf("Coming up %s: **%s**! Bidding starts at **$%d.%02d**.\n\n%s%s", discordTime(e.OpensAt), item.Title, item.StartBid/100, item.StartBid%100, item.Description, pictureURL)
+			b.announce(VerbosityItems, message)
+		case *auction.PriceDroppedEvent:
+			item, _ := b.auction.GetItem(e.ItemID)
+			if item == nil {
+				break
+			}
+			message := fmt.Sprintf("The price of **%s** has dropped to **$%d.%02d**! Say `!take` to claim it.", item.Title, e.PriceCents/100, e.PriceCents%100)
+			if current := b.auction.CurrentItem(); current == nil || current.ID != item.ID {
+				message = fmt.Sprintf("The price of **%s** has dropped to **$%d.%02d**! Say `!take %s` to claim it.", item.Title, e.PriceCents/100, e.PriceCents%100, itemRef(item))
+			}
+			if e.NextDrop == 0 {
+				message += " It won't drop any further."
+			}
+			b.announce(VerbosityAll, message)
+		case *auction.SealedBidEvent:
+			item, _ := b.auction.GetItem(e.ItemID)
+			if item == nil {
+				break
+			}
+			b.announce(VerbosityAll, fmt.Sprintf("A sealed bid is in for **%s**. There are now %d bidders.", item.Title, e.BidCount))
+		case *auction.BidEvent:
+			item, _ := b.auction.GetItem(e.ItemID)
+			if item == nil {
+				break
+			}
+			if e.Proxy {
+				b.announce(VerbosityAll, fmt.Sprintf("<@%s>'s maximum bid has raised the high bid on **%s** to **$%d.%02d**.", e.Bidder, item.Title, e.BidCents/100, e.BidCents%100))
+			}
+			if e.ReserveMet != nil && *e.ReserveMet {
+				// Only announce the bid that first meets the reserve. The top bid is
+				// last, so the one before it is first.
+				bids, err := b.auction.GetTopBids(e.ItemID, 2)
+				if err == nil && (len(bids) < 2 || bids[0].BidCents < item.Reserve) {
+					b.announce(VerbosityAll, fmt.Sprintf("The reserve price for **%s** has been met!", item.Title))
+				}
+			}
 		case *auction.DeleteBidEvent:
 			topBids, err := b.auction.GetTopBids(e.ItemID, 1)
 			if err != nil {
-				return
-			}
-			currentItem := b.auction.CurrentItem()
-			if currentItem == nil {
-				return
+				break
 			}
-			if e.ItemID != currentItem.ID {
-				return
+			if !b.auction.IsOpen(e.ItemID) {
+				break
 			}
 			if len(topBids) == 0 {
 				message := fmt.Sprintf("<@%s>'s top bid of $%d.%02d has been rescinded. There are no longer any bids!", e.Bidder, e.BidCents / 100, e.BidCents % 100)
-				_, _ = b.discord.ChannelMessageSend(b.discordChannel, message)
+				b.announce(VerbosityAll, message)
 			} else if e.BidCents > topBids[0].BidCents {
 				message := fmt.Sprintf("<@%s>'s top bid of $%d.%02d has been rescinded. The current top bid is **$%d.%02d** by <@%s>!", e.Bidder, e.BidCents / 100, e.BidCents % 100, topBids[0].BidCents / 100, topBids[0].BidCents % 100, topBids[0].Bidder)
-				_, _ = b.discord.ChannelMessageSend(b.discordChannel, message)
+				b.announce(VerbosityAll, message)
 			}
+		case *auction.AuctionArchivedEvent:
+			b.announce(VerbosityItems, "This auction is over. Thank you all for taking part!")
+		case *auction.ConnectionLostEvent:
+			log.Printf("Lost touch with the auction. Waiting for it to come back.\n")
+		case *auction.ConnectionRestoredEvent:
+			b.resync()
 		}
 	}
 }
 
+// resync tells the channel where bidding stands after the bot has been out of
+// touch with the auction, since things may have changed in the meantime.
+func (b *boundAuction) resync() {
+	item := b.auction.CurrentItem()
+	if item == nil {
+		return
+	}
+	bids, err := b.auction.GetTopBids(item.ID, 1)
+	if err != nil {
+		return
+	}
+	message := fmt.Sprintf("Sorry for the interruption! Bidding for **%s** is still open, and there are no bids yet.", item.Title)
+	if item.State == auction.StatePaused {
+		message = fmt.Sprintf("Sorry for the interruption! Bidding for **%s** is still paused.", item.Title)
+	} else if item.Mode == auction.ModeSealed {
+		message = fmt.Sprintf("Sorry for the interruption! Sealed bidding for **%s** is still open.", item.Title)
+	} else if price, _, err := b.auction.CurrentPrice(item.ID); err == nil && len(bids) == 0 {
+		message = fmt.Sprintf("Sorry for the interruption! **%s** is still up for grabs at **$%d.%02d**.", item.Title, price/100, price%100)
+	} else if len(bids) == 1 {
+		message = fmt.Sprintf("Sorry for the interruption! Bidding for **%s** is still open. The current high bid is **$%d.%02d** by <@%s>.", item.Title, bids[0].BidCents/100, bids[0].BidCents%100, bids[0].Bidder)
+	}
+	b.announce(VerbosityItems, message)
+}
+
+// discordTime formats a deadline from an auction event so that discord shows it
+// as a relative time in each reader's own timezone.
+func discordTime(millis int64) string {
+	return fmt.Sprintf("<t:%d:R>", millis/1000)
+}
+
 func (b *AuctionBot) handleMessage(s *discordgo.Session, m *discordgo.MessageCreate) {
 	if s != b.discord {
 		log.Println("Got a message from the wrong discord session???")
@@ -115,51 +322,341 @@ func (b *AuctionBot) handleMessage(s *discordgo.Session, m *discordgo.MessageCre
 	if m.Author.ID == s.State.User.ID {
 		return
 	}
-	if m.ChannelID != b.discordChannel {
+	if !strings.HasPrefix(m.Content, "!") {
+		return
+	}
+	// Direct messages are accepted too, so that maximum and sealed bids can be
+	// kept secret.
+	if m.GuildID == "" {
+		if !directMessageCommands[commandName(m)] {
+			return
+		}
+		bound, problem := b.directMessageAuction(m)
+		if bound == nil {
+			b.sendDirectMessage(m.Author.ID, problem)
+			return
+		}
+		if !bound.allowsUser(m.Author.ID) {
+			b.sendDirectMessage(m.Author.ID, "Sorry, you don't have a role that can bid in this auction.")
+			return
+		}
+		bound.processCommand(m)
 		return
 	}
-	if !strings.HasPrefix(m.Content, "!") {
+	bound, channel := b.channel(m.ChannelID)
+	// Other bots' commands are none of our business.
+	if bound == nil || !channelCommands[commandName(m)] {
 		return
 	}
-	b.processCommand(m)
+	if !channel.allows(m.Member) {
+		_, _ = b.discord.ChannelMessageSend(m.ChannelID, fmt.Sprintf("%s, you don't have a role that can bid here.", m.Author.Mention()))
+		return
+	}
+	bound.processCommand(m)
 }
 
-func (b *AuctionBot) processCommand(m *discordgo.MessageCreate) {
+// channelCommands are the commands the bot answers in auction channels, and
+// directMessageCommands the ones it answers in direct messages.
+var (
+	channelCommands       = map[string]bool{"bid": true, "maxbid": true, "take": true, "buynow": true}
+	directMessageCommands = map[string]bool{"bid": true, "maxbid": true}
+)
+
+// commandName returns the command given by a message starting with "!".
+func commandName(m *discordgo.MessageCreate) string {
+	return strings.Split(strings.TrimSpace(m.Content[1:]), " ")[0]
+}
+
+// directMessageAuction works out which auction a command sent in a direct
+// message is about, from the item it names or the one up for auction. It
+// returns a message asking which item was meant if that's ambiguous.
+func (b *AuctionBot) directMessageAuction(m *discordgo.MessageCreate) (*boundAuction, string) {
+	auctions := b.auctionList()
+	if len(auctions) == 1 {
+		return auctions[0], ""
+	}
+	args := strings.Split(strings.TrimSpace(m.Content[1:]), " ")[1:]
+	var found []*boundAuction
+	for _, bound := range auctions {
+		if item, _, _ := bound.commandItem(args); item != nil {
+			found = append(found, bound)
+		}
+	}
+	switch len(found) {
+	case 0:
+		// Let the first auction explain that there's nothing to bid on.
+		return auctions[0], ""
+	case 1:
+		return found[0], ""
+	}
+	return nil, "There's more than one auction going on right now, so I can't tell which item you mean. Please say which, like `!bid item price`."
+}
+
+func (b *boundAuction) processCommand(m *discordgo.MessageCreate) {
 	parts := strings.Split(strings.TrimSpace(m.Content[1:]), " ")
 	command := parts[0]
 	args := parts[1:]
+	if m.GuildID == "" {
+		switch command {
+		case "bid":
+			b.handleBid(m, args)
+		case "maxbid":
+			b.handleMaxBid(m, args)
+		}
+		return
+	}
 	switch command {
 	case "bid":
 		b.handleBid(m, args)
+	case "maxbid":
+		b.handleMaxBid(m, args)
+	case "take":
+		b.handleTake(m, args)
+	case "buynow":
+		b.handleBuyNow(m, args)
 	}
 }
 
+// parseCents parses a dollar amount such as "50" or "$12.50" into cents.
+func parseCents(s string) (int, error) {
+	dollars, err := strconv.ParseFloat(strings.TrimLeft(s, "$"), 64)
+	if err != nil {
+		return 0, err
+	}
+	return int(math.Round(dollars * 100)), nil
+}
 
-func (b *AuctionBot) handleBid(m *discordgo.MessageCreate, args []string) {
-	currentItem := b.auction.CurrentItem()
+// displayName returns the user's nickname on the server the message came from,
+// falling back to their username. guildID may be empty for direct messages, in
+// which case the server of the auction's first channel is used.
+func (b *boundAuction) displayName(user *discordgo.User, guildID string) string {
+	if guildID == "" {
+		guildID = b.channelGuild(b.channelList()[0].ID)
+	}
+	if member, err := b.discord.GuildMember(guildID, user.ID); err == nil {
+		if member.Nick != "" {
+			return member.Nick
+		}
+	}
+	return user.Username
+}
+
+func (b *AuctionBot) sendDirectMessage(userID, message string) {
+	channel, err := b.discord.UserChannelCreate(userID)
+	if err != nil {
+		log.Printf("Couldn't open a DM channel with %s: %v.\n", userID, err)
+		return
+	}
+	_, _ = b.discord.ChannelMessageSend(channel.ID, message)
+}
+
+
+// itemRef is how people should refer to an item in commands.
+func itemRef(item *auction.Item) string {
+	if item.ExternalID != "" {
+		return item.ExternalID
+	}
+	return item.ID
+}
+
+// commandItem works out which item a command like `!bid [item] price` is about.
+// It returns the item and the remaining arguments, or a message explaining
+// what went wrong.
+func (b *boundAuction) commandItem(args []string) (*auction.Item, []string, string) {
+	if len(args) > 1 {
+		item, err := b.auction.FindItem(args[0])
+		if err != nil || !b.auction.IsOpen(item.ID) {
+			return nil, nil, fmt.Sprintf("There's no open item called `%s`.", args[0])
+		}
+		return item, args[1:], ""
+	}
+	currentItem, err := b.auction.GetCurrentItem()
+	if err == auction.ErrNoCurrentItem {
+		if openItems, err := b.auction.OpenItems(); err == nil && len(openItems) > 0 {
+			return nil, nil, "Nothing's up for live auction right now. To bid on a silent auction item, say `!bid item price`."
+		}
+		return nil, nil, "Nothing's up for auction right now."
+	}
+	if err != nil {
+		return nil, nil, friendlyError(err)
+	}
+	return currentItem, args, ""
+}
+
+// friendlyError explains an error from the auction in a way that makes sense to
+// bidders. Errors they can't do anything about are logged instead.
+func friendlyError(err error) string {
+	var tooLow *auction.ErrBidTooLow
+	var rejected *auction.RejectedError
+	switch {
+	case errors.Is(err, auction.ErrNoCurrentItem):
+		return "nothing's up for auction right now."
+	case errors.Is(err, auction.ErrItemNotFound):
+		return "I couldn't find that item."
+	case errors.Is(err, auction.ErrItemClosed):
+		return "bidding on that item has closed."
+	case errors.Is(err, auction.ErrItemPaused):
+		return "bidding on that item is paused for now."
+	case errors.Is(err, auction.ErrAuctionArchived):
+		return "this auction is over."
+	case errors.As(err, &tooLow):
+		return tooLow.Error() + "."
+	case errors.As(err, &rejected):
+		return rejected.Reason + "."
+	}
+	log.Printf("Auction error: %v.\n", err)
+	return "something went wrong. Please try again, or ask for help if it keeps happening."
+}
+
+func (b *boundAuction) handleBid(m *discordgo.MessageCreate, args []string) {
+	currentItem, args, problem := b.commandItem(args)
 	if currentItem == nil {
-		_, _ = b.discord.ChannelMessageSend(m.ChannelID, "Nothing's up for auction right now.")
+		_, _ = b.discord.ChannelMessageSend(m.ChannelID, problem)
 		return
 	}
 	if len(args) != 1 {
-		_, _ = b.discord.ChannelMessageSend(m.ChannelID, "To bid, say `!bid price`, e.g. `!bid 50` to bid 50 dollars.")
+		_, _ = b.discord.ChannelMessageSend(m.ChannelID, "To bid, say `!bid price`, e.g. `!bid 50` to bid 50 dollars, or `!bid item price` for a silent auction item.")
 		return
 	}
-	bidCents, err := strconv.ParseFloat(strings.TrimLeft(args[0], "$"), 64)
+	if currentItem.Mode == auction.ModeSealed {
+		b.handleSealedBid(m, currentItem, args[0])
+		return
+	}
+	if m.GuildID == "" {
+		b.sendDirectMessage(m.Author.ID, fmt.Sprintf("Bids on **%s** are public, so please make them in the auction channel.", currentItem.Title))
+		return
+	}
+	bidDollars, err := parseCents(args[0])
 	if err != nil {
 		_, _ = b.discord.ChannelMessageSend(m.ChannelID, fmt.Sprintf("%s, that was not a valid bid.", m.Author.Mention()))
 		return
 	}
-	bidDollars := int(math.Round(bidCents * 100))
-	nick := m.Author.Username
-	if member, err := b.discord.GuildMember(m.GuildID, m.Author.ID); err == nil {
-		if member.Nick != "" {
-			nick = member.Nick
+	nick := b.displayName(m.Author, m.GuildID)
+	if err := b.auction.Bid(currentItem.ID, bidDollars, m.Author.ID, nick); err != nil {
+		_, _ = b.discord.ChannelMessageSend(m.ChannelID, fmt.Sprintf("%s, your bid failed: %s", m.Author.Mention(), friendlyError(err)))
+		return
+	}
+	if currentItem.Mode == auction.ModeDutch {
+		if topBids, err := b.auction.GetTopBids(currentItem.ID, 1); err == nil && len(topBids) == 1 {
+			_, _ = b.discord.ChannelMessageSend(m.ChannelID, fmt.Sprintf("%s has claimed **%s** at $%d.%02d!", m.Author.Mention(), currentItem.Title, topBids[0].BidCents/100, topBids[0].BidCents%100))
+		}
+		return
+	}
+	// Someone's maximum bid may have outbid this one straight away.
+	if topBids, err := b.auction.GetTopBids(currentItem.ID, 1); err == nil && len(topBids) == 1 && topBids[0].Bidder != m.Author.ID {
+		_, _ = b.discord.ChannelMessageSend(m.ChannelID, fmt.Sprintf("%s, your bid was accepted, but another bidder's maximum bid has already outbid you.", m.Author.Mention()))
+		return
+	}
+	message := fmt.Sprintf("Thank you! The current high bid on **%s** is $%d.%02d, by %s.", currentItem.Title, bidDollars / 100, bidDollars % 100, m.Author.Mention())
+	reserve := ""
+	if hasReserve, met := b.auction.ReserveStatus(currentItem.ID); hasReserve && met {
+		reserve = " The reserve price has been met."
+	} else if hasReserve {
+		reserve = " The reserve price has not been met yet."
+	}
+	_, _ = b.discord.ChannelMessageSend(m.ChannelID, message + reserve)
+	// Bidders in the auction's other channels need to hear about it too.
+	b.announceElsewhere(m.ChannelID, VerbosityAll, fmt.Sprintf("The current high bid on **%s** is $%d.%02d, by %s.%s", currentItem.Title, bidDollars / 100, bidDollars % 100, m.Author.Mention(), reserve))
+}
+
+func (b *boundAuction) handleTake(m *discordgo.MessageCreate, args []string) {
+	var item *auction.Item
+	if len(args) == 1 {
+		found, err := b.auction.FindItem(args[0])
+		if err != nil || !b.auction.IsOpen(found.ID) {
+			_, _ = b.discord.ChannelMessageSend(m.ChannelID, fmt.Sprintf("There's no open item called `%s`.", args[0]))
+			return
+		}
+		item = found
+	} else {
+		item = b.auction.CurrentItem()
+	}
+	if item == nil || item.Mode != auction.ModeDutch {
+		_, _ = b.discord.ChannelMessageSend(m.ChannelID, "There's no dutch auction item to take right now.")
+		return
+	}
+	if err := b.auction.Take(item.ID, m.Author.ID, b.displayName(m.Author, m.GuildID)); err != nil {
+		_, _ = b.discord.ChannelMessageSend(m.ChannelID, fmt.Sprintf("%s, you couldn't take it: %s", m.Author.Mention(), friendlyError(err)))
+		return
+	}
+	_, _ = b.discord.ChannelMessageSend(m.ChannelID, fmt.Sprintf("%s has claimed **%s**!", m.Author.Mention(), item.Title))
+}
+
+// handleBuyNow buys the named item, or the current one, outright at its buy-now
+// price.
+func (b *boundAuction) handleBuyNow(m *discordgo.MessageCreate, args []string) {
+	var item *auction.Item
+	if len(args) == 1 {
+		found, err := b.auction.FindItem(args[0])
+		if err != nil || !b.auction.IsOpen(found.ID) {
+			_, _ = b.discord.ChannelMessageSend(m.ChannelID, fmt.Sprintf("There's no open item called `%s`.", args[0]))
+			return
 		}
+		item = found
+	} else {
+		item = b.auction.CurrentItem()
+	}
+	if item == nil {
+		_, _ = b.discord.ChannelMessageSend(m.ChannelID, "There's no item open for bidding right now.")
+		return
+	}
+	if item.BuyNow == 0 {
+		_, _ = b.discord.ChannelMessageSend(m.ChannelID, fmt.Sprintf("%s, **%s** can't be bought outright.", m.Author.Mention(), item.Title))
+		return
+	}
+	if err := b.auction.BuyNow(item.ID, m.Author.ID, b.displayName(m.Author, m.GuildID)); err != nil {
+		_, _ = b.discord.ChannelMessageSend(m.ChannelID, fmt.Sprintf("%s, you couldn't buy it: %s", m.Author.Mention(), friendlyError(err)))
 	}
-	if err := b.auction.Bid(bidDollars, m.Author.ID, nick); err != nil {
-		_, _ = b.discord.ChannelMessageSend(m.ChannelID, fmt.Sprintf("%s, your bid failed: %v", m.Author.Mention(), err))
+}
+
+// handleSealedBid places a bid on a sealed-bid item, confirming it privately so
+// that nobody else learns the amount.
+func (b *boundAuction) handleSealedBid(m *discordgo.MessageCreate, item *auction.Item, amount string) {
+	if m.GuildID != "" {
+		_ = b.discord.ChannelMessageDelete(m.ChannelID, m.ID)
+	}
+	cents, err := parseCents(amount)
+	if err != nil {
+		b.sendDirectMessage(m.Author.ID, "That was not a valid bid.")
+		return
+	}
+	if err := b.auction.Bid(item.ID, cents, m.Author.ID, b.displayName(m.Author, m.GuildID)); err != nil {
+		b.sendDirectMessage(m.Author.ID, fmt.Sprintf("Your bid failed: %s", friendlyError(err)))
+		return
+	}
+	b.sendDirectMessage(m.Author.ID, fmt.Sprintf("Your sealed bid of $%d.%02d on **%s** is in. Nobody will see it until bidding closes, and you can change it by bidding again.", cents/100, cents%100, item.Title))
+}
+
+func (b *boundAuction) handleMaxBid(m *discordgo.MessageCreate, args []string) {
+	if m.GuildID != "" {
+		// Keep the maximum secret, and carry on the conversation in private.
+		_ = b.discord.ChannelMessageDelete(m.ChannelID, m.ID)
+	}
+	currentItem, args, problem := b.commandItem(args)
+	if currentItem == nil {
+		b.sendDirectMessage(m.Author.ID, problem)
+		return
+	}
+	if len(args) != 1 {
+		b.sendDirectMessage(m.Author.ID, "To set a maximum bid, say `!maxbid price`, e.g. `!maxbid 300`, or `!maxbid item price` for a silent auction item. I'll bid for you, as little as I can at a time, until your maximum is reached. Nobody else will see your maximum.")
 		return
 	}
-	_, _ = b.discord.ChannelMessageSend(m.ChannelID, fmt.Sprintf("Thank you! The current high bid on **%s** is $%d.%02d, by %s.", currentItem.Title, bidDollars / 100, bidDollars % 100, m.Author.Mention()))
+	maxCents, err := parseCents(args[0])
+	if err != nil {
+		b.sendDirectMessage(m.Author.ID, "That was not a valid maximum bid.")
+		return
+	}
+	if err := b.auction.SetMaxBid(currentItem.ID, maxCents, m.Author.ID, b.displayName(m.Author, m.GuildID)); err != nil {
+		b.sendDirectMessage(m.Author.ID, fmt.Sprintf("Your maximum bid failed: %s", friendlyError(err)))
+		return
+	}
+	message := fmt.Sprintf("Your maximum bid of $%d.%02d on **%s** is set.", maxCents/100, maxCents%100, currentItem.Title)
+	if topBids, err := b.auction.GetTopBids(currentItem.ID, 1); err == nil && len(topBids) == 1 {
+		if topBids[0].Bidder == m.Author.ID {
+			message += fmt.Sprintf(" You're the high bidder at $%d.%02d.", topBids[0].BidCents/100, topBids[0].BidCents%100)
+		} else {
+			message += " Another bidder's maximum is at least as high, so you've been outbid."
+		}
+	}
+	b.sendDirectMessage(m.Author.ID, message)
 }
@@ -0,0 +1,210 @@
+package bot
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/bwmarrin/discordgo"
+
+	"github.com/PonyFest/auction-bot/auction"
+)
+
+// Verbosity is how much a channel hears about its auction.
+type Verbosity string
+
+const (
+	// VerbosityAll announces everything, including the bidding as it happens.
+	VerbosityAll Verbosity = "all"
+	// VerbosityItems announces items opening, closing, pausing and coming up,
+	// but not the bidding on them.
+	VerbosityItems Verbosity = "items"
+	// VerbosityNone announces nothing, so the channel is only for bidding.
+	VerbosityNone Verbosity = "none"
+)
+
+// level ranks verbosities, so that a channel hears the announcements at its
+// verbosity and everything less chatty.
+var level = map[Verbosity]int{
+	VerbosityNone:  0,
+	VerbosityItems: 1,
+	VerbosityAll:   2,
+}
+
+// ParseVerbosity parses a verbosity, which defaults to VerbosityAll if empty.
+func ParseVerbosity(s string) (Verbosity, error) {
+	if s == "" {
+		return VerbosityAll, nil
+	}
+	if _, ok := level[Verbosity(s)]; !ok {
+		return "", fmt.Errorf("unknown verbosity %q: expected all, items or none", s)
+	}
+	return Verbosity(s), nil
+}
+
+// Channel is a discord channel that an auction is run in, and its settings.
+type Channel struct {
+	// ID is the discord channel's ID.
+	ID string `json:"channel"`
+	// Verbosity is how much of the auction is announced in the channel. It
+	// defaults to VerbosityAll.
+	Verbosity Verbosity `json:"verbosity,omitempty"`
+	// AllowedRoles are the IDs of the roles whose members can bid in the
+	// channel. Anyone can if there are none.
+	AllowedRoles []string `json:"allowedRoles,omitempty"`
+}
+
+// hears reports whether the channel should get announcements at the given
+// verbosity.
+func (c *Channel) hears(verbosity Verbosity) bool {
+	v := c.Verbosity
+	if v == "" {
+		v = VerbosityAll
+	}
+	return level[v] >= level[verbosity]
+}
+
+// allows reports whether a member of the channel's guild can bid there.
+func (c *Channel) allows(member *discordgo.Member) bool {
+	if len(c.AllowedRoles) == 0 {
+		return true
+	}
+	if member == nil {
+		return false
+	}
+	for _, role := range member.Roles {
+		for _, allowed := range c.AllowedRoles {
+			if role == allowed {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Bind runs an auction in another discord channel, which may be in another
+// guild. An auction can be bound to several channels, which all hear its
+// announcements and take its bids; binding a channel again updates its
+// settings.
+func (b *AuctionBot) Bind(auc *auction.Auction, channel Channel) error {
+	if channel.ID == "" {
+		return fmt.Errorf("no discord channel given")
+	}
+	if _, err := ParseVerbosity(string(channel.Verbosity)); err != nil {
+		return err
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if bound, existing := b.findChannel(channel.ID); bound != nil {
+		if bound.auction != auc {
+			return fmt.Errorf("discord channel %s is already bound to another auction", channel.ID)
+		}
+		*existing = channel
+		return nil
+	}
+	for _, bound := range b.auctions {
+		if bound.auction == auc {
+			bound.channels = append(bound.channels, &channel)
+			return nil
+		}
+	}
+	bound := &boundAuction{
+		AuctionBot: b,
+		channels:   []*Channel{&channel},
+		auction:    auc,
+	}
+	b.auctions = append(b.auctions, bound)
+	go bound.handleAuctionUpdates()
+	return nil
+}
+
+// channel returns the settings of the bound channel with the given ID, and its
+// auction, which is nil if the channel isn't bound.
+func (b *AuctionBot) channel(channelID string) (*boundAuction, Channel) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	bound, channel := b.findChannel(channelID)
+	if bound == nil {
+		return nil, Channel{}
+	}
+	return bound, *channel
+}
+
+// findChannel returns the bound channel with the given ID and its auction, or
+// nils if it isn't bound. It must be called with b.mu held.
+func (b *AuctionBot) findChannel(channelID string) (*boundAuction, *Channel) {
+	for _, bound := range b.auctions {
+		for _, channel := range bound.channels {
+			if channel.ID == channelID {
+				return bound, channel
+			}
+		}
+	}
+	return nil, nil
+}
+
+// auctionList returns the bound auctions.
+func (b *AuctionBot) auctionList() []*boundAuction {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return append([]*boundAuction(nil), b.auctions...)
+}
+
+// channelList returns a copy of the settings of the auction's channels.
+func (b *boundAuction) channelList() []Channel {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	channels := make([]Channel, len(b.channels))
+	for i, channel := range b.channels {
+		channels[i] = *channel
+	}
+	return channels
+}
+
+// channelGuild returns the ID of the guild a channel is in, or "" if it can't
+// be found.
+func (b *AuctionBot) channelGuild(channelID string) string {
+	if channel, err := b.discord.State.Channel(channelID); err == nil {
+		return channel.GuildID
+	}
+	if channel, err := b.discord.Channel(channelID); err == nil {
+		return channel.GuildID
+	}
+	return ""
+}
+
+// allowsUser reports whether a user can bid in the auction from a direct
+// message, which they can if they could bid in any of its channels.
+func (b *boundAuction) allowsUser(userID string) bool {
+	for _, channel := range b.channelList() {
+		if len(channel.AllowedRoles) == 0 {
+			return true
+		}
+		guildID := b.channelGuild(channel.ID)
+		if guildID == "" {
+			continue
+		}
+		if member, err := b.discord.GuildMember(guildID, userID); err == nil && channel.allows(member) {
+			return true
+		}
+	}
+	return false
+}
+
+// announce sends a message to each of the auction's channels that hears
+// announcements at the given verbosity.
+func (b *boundAuction) announce(verbosity Verbosity, message string) {
+	b.announceElsewhere("", verbosity, message)
+}
+
+// announceElsewhere announces a message like announce, except in the channel
+// with the given ID, which has already heard about it.
+func (b *boundAuction) announceElsewhere(channelID string, verbosity Verbosity, message string) {
+	for _, channel := range b.channelList() {
+		if channel.ID == channelID || !channel.hears(verbosity) {
+			continue
+		}
+		if _, err := b.discord.ChannelMessageSend(channel.ID, message); err != nil {
+			log.Printf("Couldn't send a message to channel %s: %v.\n", channel.ID, err)
+		}
+	}
+}
@@ -0,0 +1,387 @@
+// Package catalog reads and writes auction item catalogs as CSV or JSON, so
+// that items can be maintained in a spreadsheet and loaded in bulk.
+//
+// CSV catalogs have a header row naming the columns externalId, title,
+// description, images, startBid, reserve, buyNow, donator, country, mode,
+// pricing, dutch, softClose and rules, in any order. Images are separated by
+// whitespace, and prices are given in dollars (e.g. "25" or "12.50"). The
+// dutch, softClose and rules columns hold JSON in the same shape the API uses,
+// with prices in cents, or null to remove them. JSON catalogs are an array of
+// items in the same shape the API returns them, with startBid in cents.
+//
+// Importing an item that already exists only changes the fields the catalog
+// gives it: columns that are missing or blank in a CSV catalog, and keys that
+// are missing from a JSON one, are left as they are. A reserve or buy-now price
+// of 0 removes it.
+package catalog
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/PonyFest/auction-bot/auction"
+)
+
+type Format string
+
+const (
+	CSV  Format = "csv"
+	JSON Format = "json"
+)
+
+var csvColumns = []string{"externalId", "title", "description", "images", "startBid", "reserve", "buyNow", "donator", "country", "mode", "pricing", "dutch", "softClose", "rules"}
+
+func ParseFormat(s string) (Format, error) {
+	switch strings.ToLower(strings.TrimPrefix(s, ".")) {
+	case "csv", "text/csv":
+		return CSV, nil
+	case "json", "application/json":
+		return JSON, nil
+	}
+	return "", fmt.Errorf("unknown catalog format %q", s)
+}
+
+// Row is a single item read from a catalog. Line is the spreadsheet row (for
+// CSV, where the header is row 1) or the array position (for JSON, counting
+// from 1) the item came from. Fields are the JSON names of the item's fields
+// that the catalog gives, such as "reserve"; the rest of Item is unset.
+type Row struct {
+	Line   int
+	Item   auction.Item
+	Fields []string
+}
+
+// Ignore drops a field from the row, so that importing it leaves the field as
+// it is.
+func (r *Row) Ignore(field string) {
+	fields := r.Fields[:0:0]
+	for _, f := range r.Fields {
+		if f != field {
+			fields = append(fields, f)
+		}
+	}
+	r.Fields = fields
+}
+
+// apply sets the fields the row gives on an item.
+func (r Row) apply(item *auction.Item) {
+	for _, field := range r.Fields {
+		switch field {
+		case "externalId":
+			item.ExternalID = r.Item.ExternalID
+		case "title":
+			item.Title = r.Item.Title
+		case "description":
+			item.Description = r.Item.Description
+		case "images":
+			item.Images = r.Item.Images
+		case "startBid":
+			item.StartBid = r.Item.StartBid
+		case "reserve":
+			item.Reserve = r.Item.Reserve
+		case "buyNow":
+			item.BuyNow = r.Item.BuyNow
+		case "donator":
+			item.Donator = r.Item.Donator
+		case "country":
+			item.Country = r.Item.Country
+		case "mode":
+			item.Mode = r.Item.Mode
+		case "pricing":
+			item.Pricing = r.Item.Pricing
+		case "dutch":
+			item.Dutch = r.Item.Dutch
+		case "softClose":
+			item.SoftClose = r.Item.SoftClose
+		case "rules":
+			item.Rules = r.Item.Rules
+		}
+	}
+}
+
+type RowError struct {
+	Line       int    `json:"line"`
+	ExternalID string `json:"externalId,omitempty"`
+	Error      string `json:"error"`
+}
+
+// Read parses a catalog. Rows that can't be parsed are reported as RowErrors;
+// an error is only returned if the catalog as a whole is unreadable.
+func Read(r io.Reader, format Format) ([]Row, []RowError, error) {
+	switch format {
+	case CSV:
+		return readCSV(r)
+	case JSON:
+		return readJSON(r)
+	}
+	return nil, nil, fmt.Errorf("unknown catalog format %q", format)
+}
+
+func readCSV(r io.Reader) ([]Row, []RowError, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	header, err := reader.Read()
+	if err != nil {
+		return nil, nil, fmt.Errorf("couldn't read CSV header: %v", err)
+	}
+	columns := map[string]int{}
+	for i, name := range header {
+		columns[strings.TrimSpace(name)] = i
+	}
+	if _, ok := columns["externalId"]; !ok {
+		return nil, nil, errors.New("the CSV header must include an externalId column")
+	}
+	var rows []Row
+	var rowErrors []RowError
+	for line := 2; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			if _, ok := err.(*csv.ParseError); ok {
+				rowErrors = append(rowErrors, RowError{Line: line, Error: err.Error()})
+				continue
+			}
+			return nil, nil, err
+		}
+		field := func(name string) string {
+			if i, ok := columns[name]; ok && i < len(record) {
+				return strings.TrimSpace(record[i])
+			}
+			return ""
+		}
+		row := Row{Line: line}
+		item := &row.Item
+		item.ExternalID = field("externalId")
+		// problem is the first cell that couldn't be parsed, if any.
+		problem := ""
+		for _, name := range csvColumns {
+			value := field(name)
+			if value == "" {
+				continue
+			}
+			row.Fields = append(row.Fields, name)
+			switch name {
+			case "title":
+				item.Title = value
+			case "description":
+				item.Description = value
+			case "images":
+				item.Images = strings.Fields(value)
+			case "donator":
+				item.Donator = value
+			case "country":
+				item.Country = value
+			case "mode":
+				item.Mode = value
+			case "pricing":
+				item.Pricing = value
+			case "startBid":
+				if item.StartBid, err = parseDollars(value); err != nil {
+					problem = fmt.Sprintf("%q is not a valid starting bid", value)
+				}
+			case "reserve":
+				if item.Reserve, err = parseDollars(value); err != nil {
+					problem = fmt.Sprintf("%q is not a valid reserve price", value)
+				}
+			case "buyNow":
+				if item.BuyNow, err = parseDollars(value); err != nil {
+					problem = fmt.Sprintf("%q is not a valid buy-now price", value)
+				}
+			case "dutch":
+				if err := json.Unmarshal([]byte(value), &item.Dutch); err != nil {
+					problem = fmt.Sprintf("%q is not a valid dutch auction schedule: %v", value, err)
+				}
+			case "softClose":
+				if err := json.Unmarshal([]byte(value), &item.SoftClose); err != nil {
+					problem = fmt.Sprintf("%q is not a valid soft close: %v", value, err)
+				}
+			case "rules":
+				if err := json.Unmarshal([]byte(value), &item.Rules); err != nil {
+					problem = fmt.Sprintf("%q are not valid bid rules: %v", value, err)
+				}
+			}
+			if problem != "" {
+				break
+			}
+		}
+		if problem != "" {
+			rowErrors = append(rowErrors, RowError{Line: line, ExternalID: item.ExternalID, Error: problem})
+			continue
+		}
+		rows = append(rows, row)
+	}
+	return rows, rowErrors, nil
+}
+
+func parseDollars(s string) (int, error) {
+	dollars, err := strconv.ParseFloat(strings.TrimPrefix(s, "$"), 64)
+	if err != nil {
+		return 0, err
+	}
+	return int(math.Round(dollars * 100)), nil
+}
+
+func readJSON(r io.Reader) ([]Row, []RowError, error) {
+	var raw []json.RawMessage
+	if err := json.NewDecoder(r).Decode(&raw); err != nil {
+		return nil, nil, fmt.Errorf("couldn't decode JSON catalog: %v", err)
+	}
+	var rows []Row
+	var rowErrors []RowError
+	for i, blob := range raw {
+		row := Row{Line: i + 1}
+		var keys map[string]json.RawMessage
+		if err := json.Unmarshal(blob, &keys); err != nil {
+			rowErrors = append(rowErrors, RowError{Line: row.Line, Error: err.Error()})
+			continue
+		}
+		if err := json.Unmarshal(blob, &row.Item); err != nil {
+			rowErrors = append(rowErrors, RowError{Line: row.Line, Error: err.Error()})
+			continue
+		}
+		// Only the fields that can be imported count; the ID, state and
+		// outcome are the auction's own.
+		for _, name := range csvColumns {
+			if _, ok := keys[name]; ok {
+				row.Fields = append(row.Fields, name)
+			}
+		}
+		rows = append(rows, row)
+	}
+	return rows, rowErrors, nil
+}
+
+// Write writes items out as a catalog that Read can load again.
+func Write(w io.Writer, format Format, items []auction.Item) error {
+	switch format {
+	case CSV:
+		writer := csv.NewWriter(w)
+		if err := writer.Write(csvColumns); err != nil {
+			return err
+		}
+		for _, item := range items {
+			reserve, buyNow := "", ""
+			if item.Reserve > 0 {
+				reserve = fmt.Sprintf("%d.%02d", item.Reserve/100, item.Reserve%100)
+			}
+			if item.BuyNow > 0 {
+				buyNow = fmt.Sprintf("%d.%02d", item.BuyNow/100, item.BuyNow%100)
+			}
+			dutch, softClose, rules, err := jsonCells(item.Dutch, item.SoftClose, item.Rules)
+			if err != nil {
+				return err
+			}
+			record := []string{
+				item.ExternalID,
+				item.Title,
+				item.Description,
+				strings.Join(item.Images, " "),
+				fmt.Sprintf("%d.%02d", item.StartBid/100, item.StartBid%100),
+				reserve,
+				buyNow,
+				item.Donator,
+				item.Country,
+				item.Mode,
+				item.Pricing,
+				dutch,
+				softClose,
+				rules,
+			}
+			if err := writer.Write(record); err != nil {
+				return err
+			}
+		}
+		writer.Flush()
+		return writer.Error()
+	case JSON:
+		if items == nil {
+			items = []auction.Item{}
+		}
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(items)
+	}
+	return fmt.Errorf("unknown catalog format %q", format)
+}
+
+// jsonCells encodes the dutch schedule, soft close and bid rules of an item
+// for its CSV cells, which are left blank for those it doesn't have.
+func jsonCells(dutch *auction.DutchSchedule, softClose *auction.SoftClose, rules *auction.BidRules) (string, string, string, error) {
+	var cells [3]string
+	for i, value := range []interface{}{dutch, softClose, rules} {
+		if reflect.ValueOf(value).IsNil() {
+			continue
+		}
+		encoded, err := json.Marshal(value)
+		if err != nil {
+			return "", "", "", err
+		}
+		cells[i] = string(encoded)
+	}
+	return cells[0], cells[1], cells[2], nil
+}
+
+type Result struct {
+	DryRun  bool       `json:"dryRun"`
+	Created []string   `json:"created"`
+	Updated []string   `json:"updated"`
+	Errors  []RowError `json:"errors"`
+}
+
+// Import upserts every row into the auction, keyed by external ID. If dryRun is
+// set, rows are validated and classified but nothing is written. Failing rows
+// don't prevent the others from being imported.
+func Import(a *auction.Auction, rows []Row, rowErrors []RowError, dryRun bool) Result {
+	result := Result{
+		DryRun:  dryRun,
+		Created: []string{},
+		Updated: []string{},
+		Errors:  append([]RowError{}, rowErrors...),
+	}
+	seen := map[string]int{}
+	for _, row := range rows {
+		item := row.Item
+		fail := func(err error) {
+			result.Errors = append(result.Errors, RowError{Line: row.Line, ExternalID: item.ExternalID, Error: err.Error()})
+		}
+		if item.ExternalID == "" {
+			fail(errors.New("missing externalId"))
+			continue
+		}
+		if line, ok := seen[item.ExternalID]; ok {
+			fail(fmt.Errorf("duplicate externalId, first used on row %d", line))
+			continue
+		}
+		seen[item.ExternalID] = row.Line
+		var created bool
+		var err error
+		if dryRun {
+			created, err = a.CheckUpsert(item.ExternalID, row.apply)
+		} else {
+			_, created, err = a.UpsertItem(item.ExternalID, row.apply)
+		}
+		if err != nil {
+			fail(err)
+			continue
+		}
+		if created {
+			result.Created = append(result.Created, item.ExternalID)
+		} else {
+			result.Updated = append(result.Updated, item.ExternalID)
+		}
+	}
+	sort.SliceStable(result.Errors, func(i, j int) bool {
+		return result.Errors[i].Line < result.Errors[j].Line
+	})
+	return result
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/PonyFest/auction-bot/auction"
+	"github.com/PonyFest/auction-bot/catalog"
+)
+
+// commands are subcommands that can be run instead of the bot, as in
+// `auction-bot import --redis-url=... catalog.csv`.
+var commands = map[string]func(args []string) error{
+	"import":    importCommand,
+	"export":    exportCommand,
+	"reconcile": reconcileCommand,
+	"archive":   archiveCommand,
+}
+
+func commandAuction(storage storageConfig) (*auction.Auction, error) {
+	store, err := storage.open()
+	if err != nil {
+		return nil, err
+	}
+	return auction.New(store), nil
+}
+
+// fileFormat returns the explicitly requested format, or guesses it from the
+// file extension.
+func fileFormat(format, path string) (catalog.Format, error) {
+	if format != "" {
+		return catalog.ParseFormat(format)
+	}
+	if ext := filepath.Ext(path); ext != "" {
+		return catalog.ParseFormat(ext)
+	}
+	return "", errors.New("--format is required when it can't be guessed from the file name")
+}
+
+func importCommand(args []string) error {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	var storage storageConfig
+	storage.register(fs)
+	format := fs.String("format", "", "Catalog format (csv or json); guessed from the file name if omitted")
+	dryRun := fs.Bool("dry-run", false, "Report what would change without changing anything")
+	_ = fs.Parse(args)
+	if fs.NArg() != 1 {
+		return errors.New("usage: import [flags] <catalog file>")
+	}
+	path := fs.Arg(0)
+	f, err := fileFormat(*format, path)
+	if err != nil {
+		return err
+	}
+	a, err := commandAuction(storage)
+	if err != nil {
+		return err
+	}
+	defer a.Close()
+	var r io.Reader = os.Stdin
+	if path != "-" {
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		r = file
+	}
+	rows, rowErrors, err := catalog.Read(r, f)
+	if err != nil {
+		return err
+	}
+	result := catalog.Import(a, rows, rowErrors, *dryRun)
+	verb := ""
+	if result.DryRun {
+		verb = "would be "
+	}
+	fmt.Printf("%d items %screated, %d items %supdated.\n", len(result.Created), verb, len(result.Updated), verb)
+	for _, e := range result.Errors {
+		if e.ExternalID != "" {
+			fmt.Printf("row %d (%s): %s\n", e.Line, e.ExternalID, e.Error)
+		} else {
+			fmt.Printf("row %d: %s\n", e.Line, e.Error)
+		}
+	}
+	if len(result.Errors) > 0 {
+		return fmt.Errorf("%d rows had errors", len(result.Errors))
+	}
+	return nil
+}
+
+func exportCommand(args []string) error {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	var storage storageConfig
+	storage.register(fs)
+	format := fs.String("format", "", "Catalog format (csv or json); guessed from the file name if omitted")
+	_ = fs.Parse(args)
+	if fs.NArg() > 1 {
+		return errors.New("usage: export [flags] [catalog file]")
+	}
+	path := fs.Arg(0)
+	if path == "" {
+		path = "-"
+		if *format == "" {
+			*format = string(catalog.CSV)
+		}
+	}
+	f, err := fileFormat(*format, path)
+	if err != nil {
+		return err
+	}
+	a, err := commandAuction(storage)
+	if err != nil {
+		return err
+	}
+	defer a.Close()
+	items, err := a.GetItems()
+	if err != nil {
+		return err
+	}
+	if path == "-" {
+		return catalog.Write(os.Stdout, f, items)
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := catalog.Write(file, f, items); err != nil {
+		_ = file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// reconcileCommand checks the total raised against the winning bids on every
+// sold item, and corrects it if --fix is given. It fails if the totals don't
+// match and weren't fixed.
+func reconcileCommand(args []string) error {
+	fs := flag.NewFlagSet("reconcile", flag.ExitOnError)
+	var storage storageConfig
+	storage.register(fs)
+	fix := fs.Bool("fix", false, "Replace the recorded total with the total of the winning bids if they differ")
+	_ = fs.Parse(args)
+	if fs.NArg() != 0 {
+		return errors.New("usage: reconcile [flags]")
+	}
+	a, err := commandAuction(storage)
+	if err != nil {
+		return err
+	}
+	defer a.Close()
+	r, err := a.ReconcileTotal(*fix)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Recorded total: %s. Total of winning bids: %s.\n", dollars(r.Recorded), dollars(r.Computed))
+	switch {
+	case r.Discrepancy() == 0:
+		fmt.Println("The totals match.")
+	case r.Fixed:
+		fmt.Printf("The recorded total was off by %s, and has been corrected.\n", dollars(r.Discrepancy()))
+	default:
+		return fmt.Errorf("the recorded total is off by %s; run with --fix to correct it", dollars(r.Discrepancy()))
+	}
+	return nil
+}
+
+// archiveCommand archives the auction chosen with --auction once it's over, so
+// that it can no longer be changed.
+func archiveCommand(args []string) error {
+	fs := flag.NewFlagSet("archive", flag.ExitOnError)
+	var storage storageConfig
+	storage.register(fs)
+	_ = fs.Parse(args)
+	if fs.NArg() != 0 {
+		return errors.New("usage: archive [flags]")
+	}
+	a, err := commandAuction(storage)
+	if err != nil {
+		return err
+	}
+	defer a.Close()
+	if err := a.Archive(); err != nil {
+		return err
+	}
+	fmt.Println("The auction has been archived.")
+	return nil
+}
+
+// dollars formats a signed amount of cents.
+func dollars(cents int) string {
+	sign := ""
+	if cents < 0 {
+		sign = "-"
+		cents = -cents
+	}
+	return fmt.Sprintf("%s$%d.%02d", sign, cents/100, cents%100)
+}
@@ -1,10 +1,15 @@
 package main
 
 import (
+	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
+	"io/ioutil"
 	"log"
+	"os"
+	"strings"
+	"time"
 
 	"github.com/go-redis/redis/v7"
 
@@ -14,24 +19,58 @@ import (
 )
 
 type config struct {
-	redisURL string
+	storage storageConfig
 	discordToken string
 	discordChannel string
 	apiPassword string
+	adminPassword string
 	bind string
+	buyNowCutoff float64
+	autoAdvance time.Duration
+	timeZone *time.Location
+	// channels are the main auction's discord channels besides
+	// discordChannel, and the main channel's settings if it's listed.
+	channels []bot.Channel
+	// auctions are the other auctions to run, each in its own discord channels.
+	auctions []boundAuction
+}
+
+// boundAuction is an auction to run alongside the main one, and the discord
+// channels to run it in.
+type boundAuction struct {
+	id string
+	channels []bot.Channel
+}
+
+// channelConfig binds a discord channel to an auction, in the file given to
+// --discord-channels.
+type channelConfig struct {
+	// Auction is the ID of the auction, or empty for the main one.
+	Auction string `json:"auction"`
+	bot.Channel
 }
 
 func parseConfig() (config, error) {
 	c := config{}
-	flag.StringVar(&c.redisURL, "redis-url", "", "URL of the redis database")
+	c.storage.register(flag.CommandLine)
 	flag.StringVar(&c.discordToken, "discord-token", "", "Discord bot auth token")
 	flag.StringVar(&c.discordChannel, "discord-channel", "", "ID of the auction discord channel")
 	flag.StringVar(&c.apiPassword, "api-password", "", "The password required to hit the HTTP API")
+	flag.StringVar(&c.adminPassword, "admin-password", "", "The password that grants admin access to the HTTP API, such as seeing maximum bids")
 	flag.StringVar(&c.bind, "bind", "0.0.0.0:8080", "The address:port to bind the HTTP API to.")
+	flag.Float64Var(&c.buyNowCutoff, "buy-now-cutoff", auction.DefaultBuyNowCutoff, "The fraction of an item's buy-now price that bidding can reach before buying it now is no longer offered")
+	flag.DurationVar(&c.autoAdvance, "auto-advance", 0, "How long to wait after an item closes before opening the next item in the run queue, or 0 to only open it when asked")
+	auctions := flag.String("auctions", "", "Other auctions to run alongside --auction, each in its own discord channel, as a comma-separated list of auction-id=channel-id")
+	channels := flag.String("discord-channels", "", "A JSON file of more discord channels to run auctions in, possibly in other guilds, as a list of {\"auction\": auction-id, \"channel\": channel-id, \"verbosity\": all, items or none, \"allowedRoles\": [role-id, ...]}")
+	timeZone := flag.String("timezone", "Local", "The IANA time zone, such as America/New_York, that scheduled opening times are given in when they don't say")
 	flag.Parse()
 
-	if c.redisURL == "" {
-		return c, errors.New("--redis-url is required")
+	if err := c.storage.validate(); err != nil {
+		return c, err
+	}
+	var err error
+	if c.timeZone, err = time.LoadLocation(*timeZone); err != nil {
+		return c, fmt.Errorf("invalid --timezone %q: %v", *timeZone, err)
 	}
 	if c.discordToken == "" {
 		return c, errors.New("--discord-token is required")
@@ -39,25 +78,140 @@ func parseConfig() (config, error) {
 	if c.discordChannel == "" {
 		return c, errors.New("--discord-channel is required")
 	}
+	if c.auctions, err = parseAuctions(*auctions, c.storage.auction); err != nil {
+		return c, err
+	}
+	if *channels != "" {
+		if err := c.loadChannels(*channels); err != nil {
+			return c, fmt.Errorf("couldn't load --discord-channels: %v", err)
+		}
+	}
 	return c, nil
 }
 
+// loadChannels adds the channels listed in a file, as given to
+// --discord-channels, to the auctions they're for. Auctions that are only
+// listed there are run too.
+func (c *config) loadChannels(path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var channels []channelConfig
+	if err := json.Unmarshal(data, &channels); err != nil {
+		return err
+	}
+	for _, channel := range channels {
+		if _, err := bot.ParseVerbosity(string(channel.Verbosity)); err != nil {
+			return fmt.Errorf("channel %s: %v", channel.ID, err)
+		}
+		if channel.Auction == "" || channel.Auction == c.storage.auction {
+			c.channels = append(c.channels, channel.Channel)
+			continue
+		}
+		if err := auction.CheckNamespace(channel.Auction); err != nil {
+			return err
+		}
+		found := false
+		for i := range c.auctions {
+			if c.auctions[i].id == channel.Auction {
+				c.auctions[i].channels = append(c.auctions[i].channels, channel.Channel)
+				found = true
+			}
+		}
+		if !found {
+			c.auctions = append(c.auctions, boundAuction{id: channel.Auction, channels: []bot.Channel{channel.Channel}})
+		}
+	}
+	return nil
+}
+
+// parseAuctions parses a list of auctions and their channels, as given to
+// --auctions. mainID is the ID of the main auction, which can't be repeated.
+func parseAuctions(list, mainID string) ([]boundAuction, error) {
+	if list == "" {
+		return nil, nil
+	}
+	seen := map[string]bool{mainID: true}
+	var auctions []boundAuction
+	for _, entry := range strings.Split(list, ",") {
+		parts := strings.SplitN(strings.TrimSpace(entry), "=", 2)
+		if len(parts) != 2 || parts[1] == "" {
+			return nil, fmt.Errorf("invalid --auctions entry %q: expected auction-id=channel-id", entry)
+		}
+		if err := auction.CheckNamespace(parts[0]); err != nil {
+			return nil, err
+		}
+		if seen[parts[0]] {
+			return nil, fmt.Errorf("auction %q is listed more than once", parts[0])
+		}
+		seen[parts[0]] = true
+		auctions = append(auctions, boundAuction{id: parts[0], channels: []bot.Channel{{ID: parts[1]}}})
+	}
+	return auctions, nil
+}
+
+// startAuction opens the auction with the given ID and sets it up as asked.
+func startAuction(c *config, id string) (*auction.Auction, error) {
+	store, err := c.storage.openAuction(id)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't open storage: %v", err)
+	}
+	a := auction.New(store)
+	if err := a.SetBuyNowCutoff(c.buyNowCutoff); err != nil {
+		return nil, fmt.Errorf("invalid arguments: %v", err)
+	}
+	if err := a.SetAutoAdvance(c.autoAdvance); err != nil {
+		return nil, fmt.Errorf("invalid arguments: %v", err)
+	}
+	a.SetTimeZone(c.timeZone)
+	go a.RunScheduler()
+	return a, nil
+}
+
 func main() {
+	if len(os.Args) > 1 {
+		if command, ok := commands[os.Args[1]]; ok {
+			if err := command(os.Args[2:]); err != nil {
+				log.Fatalf("%s failed: %v.\n", os.Args[1], err)
+			}
+			return
+		}
+	}
 	c, err := parseConfig()
 	if err != nil {
 		log.Fatalf("invalid arguments: %v.\n", err)
 	}
-	r, err := getRedisClient(c.redisURL)
+	a, err := startAuction(&c, c.storage.auction)
 	if err != nil {
-		log.Fatalf("couldn't get redis client: %v.\n", err)
+		log.Fatalf("%v.\n", err)
 	}
-	a := auction.New(r)
 	b, err := bot.New(a, c.discordToken, c.discordChannel)
 	if err != nil {
 		log.Fatalf("couldn't create bot: %v.\n", err)
 	}
+	for _, channel := range c.channels {
+		if err := b.Bind(a, channel); err != nil {
+			log.Fatalf("couldn't bind channel: %v.\n", err)
+		}
+	}
+	server := api.New(a, c.apiPassword, c.adminPassword)
+	if c.storage.auction != "" {
+		server.AddAuction(c.storage.auction, a)
+	}
+	for _, bound := range c.auctions {
+		other, err := startAuction(&c, bound.id)
+		if err != nil {
+			log.Fatalf("auction %q: %v.\n", bound.id, err)
+		}
+		for _, channel := range bound.channels {
+			if err := b.Bind(other, channel); err != nil {
+				log.Fatalf("auction %q: couldn't bind channel: %v.\n", bound.id, err)
+			}
+		}
+		server.AddAuction(bound.id, other)
+	}
 	go b.RunForever()
-	server := api.New(a, c.apiPassword)
 	log.Fatalln(server.ListenAndServe(c.bind))
 }
 